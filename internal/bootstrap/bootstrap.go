@@ -5,6 +5,7 @@ package bootstrap
 
 func Init() {
 	initConf()
+	initLanguage()
 	initStorage()
 	initServer()
 }
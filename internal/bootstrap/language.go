@@ -0,0 +1,20 @@
+package bootstrap
+
+import (
+	"context"
+	"log"
+	"nightcord-server/internal/service/executor"
+	"nightcord-server/internal/service/language"
+)
+
+func initLanguage() {
+	err := language.InitLanguages()
+	if err != nil {
+		log.Fatalf("Failed to load languages: %v", err)
+	}
+
+	log.Println("Languages loaded successfully")
+
+	// 在沙箱中检测各语言的编译器或解释器版本
+	executor.DetectLanguageVersions(context.Background())
+}
@@ -6,20 +6,42 @@ package bootstrap
 import (
 	"log"
 	"nightcord-server/internal/conf"
+	"nightcord-server/internal/service/executor"
 	"nightcord-server/internal/service/storage"
+	"time"
 )
 
 func initStorage() {
 	// 使用配置文件中的存储配置初始化存储引擎
+	c := conf.Conf.Storage
 	storageConfig := &storage.Config{
-		StoreDir: conf.Conf.Storage.StoreDir,
-		DBPath:   conf.Conf.Storage.DBPath,
+		StoreDir: c.StoreDir,
+		DBPath:   c.DBPath,
+		Backend:  c.Backend,
+		S3: storage.S3Config{
+			Endpoint:  c.S3.Endpoint,
+			Region:    c.S3.Region,
+			Bucket:    c.S3.Bucket,
+			AccessKey: c.S3.AccessKey,
+			SecretKey: c.S3.SecretKey,
+			Prefix:    c.S3.Prefix,
+			PathStyle: c.S3.PathStyle,
+			Timeout:   time.Duration(c.S3.Timeout) * time.Second,
+		},
+		CacheDir:      c.CacheDir,
+		CacheSize:     int64(c.CacheSize) * 1024,
+		SyncInterval:  time.Duration(c.SyncInterval) * time.Second,
+		GCInterval:    time.Duration(c.GCInterval) * time.Second,
+		KeepVersions:  c.KeepVersions,
+		VersionMaxAge: time.Duration(c.VersionMaxAge) * time.Second,
 	}
 
 	err := storage.InitStorageEngine(storageConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage engine: %v", err)
 	}
+	// 上传的测试输入使用评测模块编译运行校验程序
+	storage.GetStorageEngineInstance().SetValidateFunc(executor.ValidateInput)
 
 	log.Println("Storage engine initialized successfully")
 }
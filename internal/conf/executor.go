@@ -1,15 +1,31 @@
 package conf
 
 type ExecutorConf struct {
-	JobQueue       int     `yaml:"job_queue" json:"job_queue"`           // 任务队列大小
-	JobPool        int     `yaml:"job_pool" json:"job_pool"`             // 任务协程池池数量
-	RunQueue       int     `yaml:"run_queue" json:"run_queue"`           // 运行任务队列大小
-	RunPool        int     `yaml:"run_pool" json:"run_pool"`             // 运行协程池数量
-	ExtraCPUTime   float64 `yaml:"extra_cpu_time" json:"extra_cpu_time"` // seconds 在超出限制时间后的额外时间
-	CompileTimeout float64 `yaml:"compile_timeout"`                      // seconds 最大编译时间
-	CompileMemory  int     `yaml:"compile_memory"`                       // KB 最大编译内存
-	CPUTimeLimit   float64 `yaml:"cpu_time_limit"`                       // seconds 默认运行时间
-	MemoryLimit    uint    `yaml:"memory_limit"`                         // KB 默认运行内存
+	JobQueue              int     `yaml:"job_queue" json:"job_queue"`           // 任务队列大小
+	JobPool               int     `yaml:"job_pool" json:"job_pool"`             // 任务协程池池数量
+	RunQueue              int     `yaml:"run_queue" json:"run_queue"`           // 运行任务队列大小
+	RunPool               int     `yaml:"run_pool" json:"run_pool"`             // 运行协程池数量
+	ExtraCPUTime          float64 `yaml:"extra_cpu_time" json:"extra_cpu_time"` // seconds 在超出限制时间后的额外时间
+	CompileTimeout        float64 `yaml:"compile_timeout"`                      // seconds 最大编译时间
+	CompileMemory         int     `yaml:"compile_memory"`                       // KB 最大编译内存
+	CPUTimeLimit          float64 `yaml:"cpu_time_limit"`                       // seconds 默认运行时间
+	MemoryLimit           uint    `yaml:"memory_limit"`                         // KB 默认运行内存
+	StackLimit            int     `yaml:"stack_limit"`                          // KB 默认栈空间，-1表示不限制，-2表示与内存限制相同
+	FileSizeLimit         int     `yaml:"file_size_limit"`                      // KB 运行时单个写入文件大小，0表示不限制
+	CompileFileSizeLimit  int     `yaml:"compile_file_size_limit"`              // KB 编译时单个写入文件大小，0表示不限制
+	WorkDirQuota          int     `yaml:"work_dir_quota"`                       // KB 工作目录磁盘配额（含源码与编译产物），0表示不限制
+	ProcessLimit          int     `yaml:"process_limit"`                        // 默认运行进程与线程数，0表示不限制
+	CompileProcessLimit   int     `yaml:"compile_process_limit"`                // 编译进程与线程数，0表示不限制
+	OutputLimit           int     `yaml:"output_limit"`                         // KB 运行时标准输出与标准错误各自保留的长度，超出部分丢弃，0表示不限制
+	CompileOutputLimit    int     `yaml:"compile_output_limit"`                 // KB 编译输出（标准输出与标准错误各自）保留的最大长度，0表示不限制
+	PlaygroundOutputLimit int     `yaml:"playground_output_limit"`              // KB 自测模式下运行时标准输出与标准错误各自保留的长度，超出部分丢弃
+	SubmissionSizeLimit   int     `yaml:"submission_size_limit"`                // KB 一次提交中所有源文件（含压缩包解压后）的总大小
+	SubmissionFileLimit   int     `yaml:"submission_file_limit"`                // 一次提交中的最大源文件数
+	ArtifactTTL           int     `yaml:"artifact_ttl"`                         // seconds POST /compile 产生的编译产物在最后一次使用后的保留时间
+	ArtifactLimit         int     `yaml:"artifact_limit"`                       // 同时保留的编译产物数量，超出时淘汰最早过期的
+	CompileCacheDir       string  `yaml:"compile_cache_dir"`                    // 编译产物缓存目录
+	CompileCacheSize      int     `yaml:"compile_cache_size"`                   // KB 编译产物缓存容量，超出时按最近最少使用淘汰，0表示不缓存
+	GraderDir             string  `yaml:"grader_dir"`                           // 存储引擎中的目录，未指定题目的提交只能使用其中的评测程序文件，为空时不允许使用评测程序
 }
 
 func (c *ExecutorConf) Default() {
@@ -40,4 +56,49 @@ func (c *ExecutorConf) Default() {
 	if c.MemoryLimit == 0 {
 		c.MemoryLimit = 262144
 	}
+	if c.StackLimit == 0 {
+		c.StackLimit = -2
+	}
+	if c.FileSizeLimit == 0 {
+		c.FileSizeLimit = 65536
+	}
+	if c.CompileFileSizeLimit == 0 {
+		c.CompileFileSizeLimit = 262144
+	}
+	if c.ProcessLimit == 0 {
+		c.ProcessLimit = 64
+	}
+	if c.CompileProcessLimit == 0 {
+		c.CompileProcessLimit = 64
+	}
+	if c.OutputLimit == 0 {
+		c.OutputLimit = 16384
+	}
+	if c.CompileOutputLimit == 0 {
+		c.CompileOutputLimit = 64
+	}
+	if c.PlaygroundOutputLimit == 0 {
+		c.PlaygroundOutputLimit = 1024
+	}
+	if c.SubmissionSizeLimit == 0 {
+		c.SubmissionSizeLimit = 10240
+	}
+	if c.SubmissionFileLimit == 0 {
+		c.SubmissionFileLimit = 256
+	}
+	if c.ArtifactTTL == 0 {
+		c.ArtifactTTL = 600
+	}
+	if c.ArtifactLimit == 0 {
+		c.ArtifactLimit = 100
+	}
+	if c.CompileCacheDir == "" {
+		c.CompileCacheDir = "./cache/compile"
+	}
+	if c.CompileCacheSize == 0 {
+		c.CompileCacheSize = 262144
+	}
+	if c.GraderDir == "" {
+		c.GraderDir = "graders"
+	}
 }
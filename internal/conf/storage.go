@@ -2,12 +2,44 @@ package conf
 
 // StorageConf 存储引擎配置
 type StorageConf struct {
-	StoreDir string `yaml:"store_dir" json:"store_dir"` // 存储目录
-	DBPath   string `yaml:"db_path" json:"db_path"`     // 数据库文件路径
+	StoreDir        string `yaml:"store_dir" json:"store_dir"`                 // 存储目录，使用远端后端时只用于暂存上传中的内容
+	DBPath          string `yaml:"db_path" json:"db_path"`                     // 数据库文件路径
+	ImportSizeLimit int    `yaml:"import_size_limit" json:"import_size_limit"` // KB 导入的测试数据包解压后的总大小，0表示不限制
+	ImportFileLimit int    `yaml:"import_file_limit" json:"import_file_limit"` // 导入的测试数据包中的最大文件数，0表示不限制
+	UploadSizeLimit int    `yaml:"upload_size_limit" json:"upload_size_limit"` // KB 上传的单个文件的最大大小，0表示不限制
+	Backend         string `yaml:"backend" json:"backend"`                     // 文件内容的存放位置：local（存储目录）或 s3
+	S3              S3Conf `yaml:"s3" json:"s3"`                               // backend为s3时的连接配置
+	CacheDir        string `yaml:"cache_dir" json:"cache_dir"`                 // 远端后端的本地读缓存目录
+	CacheSize       int    `yaml:"cache_size" json:"cache_size"`               // KB 远端后端的本地读缓存容量，超出时按最近最少使用淘汰，0表示不缓存
+	SyncInterval    int    `yaml:"sync_interval" json:"sync_interval"`         // seconds 使用S3时各节点共用存储桶中的文件与题目，同步其他节点写入的周期，0表示只在写入与评测前同步
+	GCInterval      int    `yaml:"gc_interval" json:"gc_interval"`             // seconds 清理过期版本的周期，使用S3时同时回收存储桶中无引用的内容，0表示本节点不清理
+	KeepVersions    int    `yaml:"keep_versions" json:"keep_versions"`         // 每个文件最多保留的版本数（含当前版本），0表示不限制
+	VersionMaxAge   int    `yaml:"version_max_age" json:"version_max_age"`     // seconds 历史版本的最长保留时间，当前版本总是保留，0表示不限制
+}
+
+// S3Conf S3兼容对象存储配置
+type S3Conf struct {
+	Endpoint  string `yaml:"endpoint" json:"endpoint"`     // 服务地址，如 http://127.0.0.1:9000
+	Region    string `yaml:"region" json:"region"`         // 区域，为空时使用 us-east-1
+	Bucket    string `yaml:"bucket" json:"bucket"`         // 存储桶
+	AccessKey string `yaml:"access_key" json:"-"`          // 访问密钥，为空时发送匿名请求
+	SecretKey string `yaml:"secret_key" json:"-"`          // 私有访问密钥
+	Prefix    string `yaml:"prefix" json:"prefix"`         // 对象键前缀
+	PathStyle bool   `yaml:"path_style" json:"path_style"` // 使用 endpoint/bucket/key 形式的地址，MinIO等自建服务通常需要开启
+	Timeout   int    `yaml:"timeout" json:"timeout"`       // seconds 建立连接与等待响应的超时，0表示使用默认值30秒
 }
 
 // Default 设置默认配置
 func (s *StorageConf) Default() {
 	s.StoreDir = "./storage/files"
 	s.DBPath = "./storage/metadata.db"
+	s.ImportSizeLimit = 262144
+	s.ImportFileLimit = 2000
+	s.UploadSizeLimit = 1048576
+	s.Backend = "local"
+	s.CacheDir = "./storage/cache"
+	s.CacheSize = 1048576
+	s.SyncInterval = 5
+	s.GCInterval = 3600
+	s.KeepVersions = 20
 }
@@ -6,9 +6,11 @@ package model
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"syscall"
+	"time"
 )
 
 // 代码运行器闭包，内含一个 Executor 结构体模版，可以以这个模版为基础运行不同的Testcase
@@ -20,7 +22,7 @@ type TestcaseType int
 const (
 	SingleTest   TestcaseType = 0 // 单个测试数据，使用SubmitRequest下的Stdin与ExpectedOutput字段
 	MultipleTest TestcaseType = 1 // 多个测试数据，使用SubmitRequest下的Testcase字段
-	FileTest     TestcaseType = 2 // 文件测试数据，暂未实现
+	FileTest     TestcaseType = 2 // 文件测试数据，Testcase中的Stdin与ExpectedOutput为存储引擎中的文件名
 )
 
 // RunJob 表示运行任务，由运行协程池调度执行
@@ -44,38 +46,156 @@ type TestResultWithIndex struct {
 type TestcaseReq struct {
 	Stdin          string `json:"stdin,omitempty"`
 	ExpectedOutput string `json:"expected_output,omitempty"`
+	Subtask        int    `json:"subtask,omitempty"` // 所属子任务ID，0表示不属于任何子任务
+	// FileTest 时固定使用的文件版本，0表示当前版本；提交题目时可只填写版本，按顺序对应题目的测试点
+	StdinVersion          int64 `json:"stdin_version,omitempty"`
+	ExpectedOutputVersion int64 `json:"expected_output_version,omitempty"`
+}
+
+// 输出比较方式，用于 SubmitRequest.Checker 与题目的checker
+const (
+	CheckerDefault = ""       // 忽略末尾换行
+	CheckerExact   = "exact"  // 逐字节比较
+	CheckerTokens  = "tokens" // 按空白分隔的记号比较，忽略空白的数量与种类
+)
+
+// IsValidChecker 检查是否为支持的输出比较方式
+func IsValidChecker(checker string) bool {
+	switch checker {
+	case CheckerDefault, CheckerExact, CheckerTokens:
+		return true
+	}
+	return false
+}
+
+// Subtask 表示题目的子任务，其中的测试点全部通过才得分
+type Subtask struct {
+	ID    int     `json:"id"` // 正整数，测试点通过subtask引用
+	Score float64 `json:"score"`
 }
 
 // SubmitRequest 表示提交评测时的请求体
 type SubmitRequest struct {
-	SourceCode     string        `json:"source_code"`
-	Stdin          string        `json:"stdin,omitempty"`
-	ExpectedOutput string        `json:"expected_output,omitempty"`
-	CpuTimeLimit   float64       `json:"cpu_time_limit,omitempty"`
-	MemoryLimit    uint          `json:"memory_limit,omitempty"`
-	LanguageID     int           `json:"language_id"`
-	Testcase       []TestcaseReq `json:"test_case,omitempty"`
-	TestcaseType   TestcaseType  `json:"test_case_type,omitempty"`
+	SourceCode     string               `json:"source_code"`       // 写入语言的source_file
+	Files          map[string]string    `json:"files,omitempty"`   // 额外的源文件，键为相对工作目录的路径
+	Archive        string               `json:"archive,omitempty"` // base64编码的zip、tar或tar.gz压缩包，解压到工作目录
+	Stdin          string               `json:"stdin,omitempty"`
+	ExpectedOutput string               `json:"expected_output,omitempty"`
+	CpuTimeLimit   float64              `json:"cpu_time_limit,omitempty"`
+	MemoryLimit    uint                 `json:"memory_limit,omitempty"`
+	StackLimit     int                  `json:"stack_limit,omitempty"` // KB，取值见 StackLimitUnlimited 与 StackLimitMemory
+	Playground     bool                 `json:"playground,omitempty"`  // 自测模式，未提供预期输出的测试点返回Finished而非评测结果
+	LanguageID     int                  `json:"language_id"`
+	CompileOptions []string             `json:"compile_options,omitempty"` // 编译选项，须在语言的compile_options中
+	Graders        map[int][]GraderFile `json:"graders,omitempty"`         // 按语言ID指定的评测程序文件，用于函数式题目
+	Testcase       []TestcaseReq        `json:"test_case,omitempty"`
+	TestcaseType   TestcaseType         `json:"test_case_type,omitempty"`
+	ProblemID      int64                `json:"problem_id,omitempty"` // 使用题目的测试点，未指定的限制、比较方式与评测程序取题目的设置
+	Checker        string               `json:"checker,omitempty"`    // 输出比较方式，取值见 CheckerDefault 等常量
+	Subtasks       []Subtask            `json:"subtasks,omitempty"`   // 子任务，测试点通过Subtask字段引用
+}
+
+// ErrInvalidLimit 请求中的资源限制无效
+var ErrInvalidLimit = errors.New("invalid limit")
+
+// validateLimits 检查请求中的时间与栈空间限制，栈空间只允许0、正数与 StackLimitUnlimited、StackLimitMemory
+func validateLimits(cpuTimeLimit float64, stackLimit int) error {
+	if cpuTimeLimit < 0 {
+		return fmt.Errorf("%w: cpu_time_limit cannot be negative", ErrInvalidLimit)
+	}
+	if stackLimit < StackLimitMemory {
+		return fmt.Errorf("%w: invalid stack_limit %d", ErrInvalidLimit, stackLimit)
+	}
+	return nil
+}
+
+// Validate 检查提交请求中的资源限制
+func (r *SubmitRequest) Validate() error {
+	return validateLimits(r.CpuTimeLimit, r.StackLimit)
+}
+
+// CompileResponse 表示 POST /compile 的结果
+type CompileResponse struct {
+	Compilation CompilationResult `json:"compilation"`
+	Handle      string            `json:"handle,omitempty"`     // 编译产物句柄，用于 POST /run
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"` // 句柄过期时间，每次运行后顺延
+	Status      Status            `json:"status"`
+	Message     string            `json:"message"`
+}
+
+// RunRequest 表示 POST /run 的请求，使用已编译的产物运行一次
+type RunRequest struct {
+	Handle       string  `json:"handle"`
+	Stdin        string  `json:"stdin,omitempty"`
+	CpuTimeLimit float64 `json:"cpu_time_limit,omitempty"`
+	MemoryLimit  uint    `json:"memory_limit,omitempty"`
+	StackLimit   int     `json:"stack_limit,omitempty"` // KB，取值见 StackLimitUnlimited 与 StackLimitMemory
+}
+
+// Validate 检查运行请求中的资源限制
+func (r *RunRequest) Validate() error {
+	return validateLimits(r.CpuTimeLimit, r.StackLimit)
+}
+
+// GraderFile 表示放置在提交旁的评测程序文件（提供main函数的grader或stub）
+type GraderFile struct {
+	Path string `json:"path"` // 放置在工作目录中的相对路径
+	File string `json:"file"` // 存储引擎中的文件名
+}
+
+// Validator 表示校验测试输入的程序，输入从标准输入读入，退出码为0表示输入有效
+// 无效时标准错误（为空时为标准输出）作为校验信息
+type Validator struct {
+	LanguageID     int      `json:"language_id"`
+	Source         string   `json:"source"` // 存储引擎中的源文件名
+	CompileOptions []string `json:"compile_options,omitempty"`
+	CpuTimeLimit   float64  `json:"cpu_time_limit,omitempty"`
+	MemoryLimit    uint     `json:"memory_limit,omitempty"`
+	Reject         bool     `json:"reject,omitempty"` // 拒绝上传未通过校验的输入，否则只记录校验结果
 }
 
 // CompilationResult 表示编译结果
 type CompilationResult struct {
-	Success     bool    `json:"success"`
-	Output      string  `json:"output"`       // 编译输出（包括警告）
-	CompileTime float64 `json:"compile_time"` // 编译耗时（秒）
-	Message     string  `json:"message"`
+	Success     bool         `json:"success"`
+	Output      string       `json:"output"`                // 编译器标准输出
+	Stderr      string       `json:"stderr"`                // 编译器标准错误（gcc、javac等的错误与警告）
+	Truncated   bool         `json:"truncated,omitempty"`   // 输出是否因超出长度限制被截断
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"` // 从编译输出中解析出的诊断信息
+	CompileTime float64      `json:"compile_time"`          // 编译耗时（秒），命中缓存时为原编译耗时
+	Cached      bool         `json:"cached"`                // 是否复用了缓存的编译产物
+	Message     string       `json:"message"`
 }
 
+// Diagnostic 表示一条编译诊断信息
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"` // 从1开始，0表示编译器未给出列号
+	Severity string `json:"severity"`         // 取值见 DiagnosticError 等常量
+	Message  string `json:"message"`
+}
+
+// 诊断信息的严重程度
+const (
+	DiagnosticError   = "error"
+	DiagnosticWarning = "warning"
+	DiagnosticNote    = "note"
+)
+
 type RunResult = TestResult
 
 // TestResult 表示单个测试结果
 type TestResult struct {
-	Status  Status  `json:"status"`
-	Stderr  string  `json:"stderr"` // 运行时错误信息
-	Stdout  string  `json:"stdout"`
-	Message string  `json:"message"`
-	Time    float64 `json:"time"`   // 执行时间（秒）
-	Memory  uint    `json:"memory"` // 内存消耗（KB）
+	Status    Status  `json:"status"`
+	Stderr    string  `json:"stderr"` // 运行时错误信息
+	Stdout    string  `json:"stdout"`
+	Truncated bool    `json:"truncated,omitempty"` // 标准输出或标准错误是否因超出长度限制被截断
+	Message   string  `json:"message"`
+	Time      float64 `json:"time"`             // CPU时间（秒）
+	WallTime  float64 `json:"wall_time"`        // 实际经过的时间（秒）
+	Memory    uint    `json:"memory"`           // 内存消耗（KB）
+	ExitCode  int     `json:"exit_code"`        // 进程退出码，被信号终止时为0
+	Signal    int     `json:"signal,omitempty"` // 终止进程的信号，0表示正常退出
 }
 
 // JudgeResult 表示一次任务的评测结果
@@ -84,40 +204,67 @@ type JudgeResult struct {
 	TestResult  []TestResult      `json:"test_result"`
 	MaxTime     float64           `json:"max_time"`
 	MaxMemory   uint              `json:"max_memory"`
+	Subtasks    []SubtaskResult   `json:"subtasks,omitempty"`
+	Score       float64           `json:"score,omitempty"` // 通过的子任务分数之和
 	Status      Status            `json:"status"`
 	Message     string            `json:"message"`
+	Testcases   []TestcaseReq     `json:"test_case,omitempty"` // FileTest 时实际评测的文件及其版本，可原样作为请求的test_case提交以复现评测
+}
+
+// SubtaskResult 表示子任务的评测结果
+type SubtaskResult struct {
+	ID     int     `json:"id"`
+	Status Status  `json:"status"` // 子任务中最严重的测试点状态
+	Score  float64 `json:"score"`  // 全部通过时为子任务分数，否则为0
 }
 
+// 栈空间限制的特殊取值，0表示使用默认值
+const (
+	StackLimitUnlimited = -1 // 不限制栈空间
+	StackLimitMemory    = -2 // 栈空间与内存限制相同
+)
+
 // Limiter 表示评测限制
 type Limiter struct {
-	CpuTime float64
-	Memory  uint
+	CpuTime  float64
+	Memory   uint
+	Stack    int // KB，取值见 StackLimitUnlimited 与 StackLimitMemory
+	FileSize int // KB 单个写入文件的大小，0表示不限制
+	Process  int // 进程与线程总数，0表示不限制
+	Output   int // KB 标准输出与标准错误各自保留的长度，0表示不限制
 }
 
 // ExecutorResult 表示运行结果
 type ExecutorResult struct {
-	ExitCode int
-	Memory   uint
-	Time     float64
-	Signal   syscall.Signal
+	ExitCode        int
+	Memory          uint
+	Time            float64
+	Signal          syscall.Signal
+	IllegalSyscall  string // 被seccomp拦截的系统调用名，为空表示未触发
+	ProcessExceeded bool   // 是否超出进程与线程数限制
+	SetupError      string // 执行命令前的准备步骤失败原因，为空表示命令已执行
+	WallTime        float64
 }
 
 // Executor 表示运行器
 type Executor struct {
 	Command string
 	Limiter Limiter
+	Sandbox Sandbox
 	Dir     string
 	Stdin   *os.File
 	Stdout  *os.File
 	Stderr  *os.File
+	Report  *os.File // 监控进程回报违规信息的管道，为nil时不回报
 	RunFlag bool
 }
 
 // ExecutorPipe 表示运行器的管道组
 type ExecutorPipe struct {
-	In  *Pipe
-	Out *Pipe
-	Err *Pipe
+	In     *Pipe
+	Out    *Pipe
+	Err    *Pipe
+	Report *Pipe // 监控进程回报违规信息（被拦截的系统调用、超出进程数限制）
 }
 
 // Close 关闭管道组
@@ -132,6 +279,9 @@ func (p *ExecutorPipe) Close() error {
 	if terr := p.Err.Close(); terr != nil {
 		err = errors.Join(err, terr)
 	}
+	if terr := p.Report.Close(); terr != nil {
+		err = errors.Join(err, terr)
+	}
 	return err
 }
 
@@ -153,9 +303,17 @@ func NewExecutorPipe() (*ExecutorPipe, error) {
 		defer errPipe.Close()
 		return nil, err
 	}
+	reportPipe, err := NewPipe()
+	if err != nil {
+		in.Close()
+		out.Close()
+		errPipe.Close()
+		return nil, err
+	}
 	return &ExecutorPipe{
-		In:  in,
-		Out: out,
-		Err: errPipe,
+		In:     in,
+		Out:    out,
+		Err:    errPipe,
+		Report: reportPipe,
 	}, nil
 }
@@ -1,10 +1,118 @@
 package model
 
+import (
+	"sort"
+)
+
 // Language 对应lang.json中的每种编程语言
 type Language struct {
-	ID         int    `json:"id"`
-	Name       string `json:"name"`        // 语言名
-	SourceFile string `json:"source_file"` // 源文件名
-	CompileCmd string `json:"compile_cmd"` // 编译命令
-	RunCmd     string `json:"run_cmd"`     // 运行命令
+	ID               int               `json:"id"`
+	Name             string            `json:"name"`                         // 语言名
+	SourceFile       string            `json:"source_file"`                  // 源文件名
+	BinaryFile       string            `json:"binary_file,omitempty"`        // 编译产物文件名，为空时使用main
+	CompileCmd       string            `json:"compile_cmd"`                  // 编译命令，支持的占位符见 CommandPlaceholders
+	RunCmd           string            `json:"run_cmd"`                      // 运行命令，支持的占位符见 CommandPlaceholders
+	GraderCompileCmd string            `json:"grader_compile_cmd,omitempty"` // 提交附带评测程序时的编译命令，为空时使用compile_cmd
+	GraderRunCmd     string            `json:"grader_run_cmd,omitempty"`     // 提交附带评测程序时的运行命令，为空时使用run_cmd
+	TimeMultiplier   float64           `json:"time_multiplier,omitempty"`    // 运行时间限制倍率，0表示1倍
+	ExtraMemory      uint              `json:"extra_memory,omitempty"`       // KB 在运行内存限制之上额外允许的内存
+	CompileTimeout   float64           `json:"compile_timeout,omitempty"`    // seconds 编译时间限制，0表示使用默认值
+	CompileMemory    uint              `json:"compile_memory,omitempty"`     // KB 编译内存限制，0表示使用默认值
+	StackLimit       int               `json:"stack_limit,omitempty"`        // KB 运行时栈空间限制，0表示使用默认值
+	ProcessLimit     int               `json:"process_limit,omitempty"`      // 运行时进程与线程数限制，0表示使用默认值
+	Env              map[string]string `json:"env,omitempty"`                // 编译与运行时额外的环境变量
+	SandboxProfile   string            `json:"sandbox_profile,omitempty"`    // 运行时使用的seccomp过滤器配置名，为空时使用strict
+	CompileOptions   []string          `json:"compile_options,omitempty"`    // 提交时允许选择的编译选项，展开到{args}
+	DefaultOptions   []string          `json:"default_options,omitempty"`    // 提交未选择编译选项时使用的选项，须在compile_options中
+	Highlight        string            `json:"highlight,omitempty"`          // 客户端编辑器（Monaco等）使用的语法高亮标识
+	VersionCmd       string            `json:"version_cmd,omitempty"`        // 输出编译器或解释器版本的命令，启动时在沙箱中执行
+	Disabled         bool              `json:"disabled,omitempty"`           // 是否停用，停用的语言不再接受新的提交
+}
+
+// DefaultBinaryFile 未指定binary_file时的编译产物文件名
+const DefaultBinaryFile = "main"
+
+// CommandPlaceholders 编译与运行命令中支持的占位符
+//   - {source}: 源文件名
+//   - {binary}: 编译产物文件名
+//   - {memory_mb}: 当前阶段的内存限制（MB）
+//   - {workdir}: 工作目录的绝对路径
+//   - {args}: 提交时选择的编译选项，旧配置中的%s视为{args}
+//   - {graders}: 评测程序文件的相对路径，以空格分隔
+var CommandPlaceholders = []string{"{source}", "{binary}", "{memory_mb}", "{workdir}", "{args}", "{graders}"}
+
+// Binary 返回编译产物文件名
+func (l Language) Binary() string {
+	if l.BinaryFile == "" {
+		return DefaultBinaryFile
+	}
+	return l.BinaryFile
+}
+
+// CompileCommand 返回编译命令模板，grader表示提交是否附带评测程序
+func (l Language) CompileCommand(grader bool) string {
+	if grader && l.GraderCompileCmd != "" {
+		return l.GraderCompileCmd
+	}
+	return l.CompileCmd
+}
+
+// RunCommand 返回运行命令模板，grader表示提交是否附带评测程序
+func (l Language) RunCommand(grader bool) string {
+	if grader && l.GraderRunCmd != "" {
+		return l.GraderRunCmd
+	}
+	return l.RunCmd
+}
+
+// 运行模式下可用的seccomp过滤器配置名
+const (
+	SandboxProfileStrict  = "strict"  // 默认配置，禁止文件状态查询、进程与线程创建及休眠
+	SandboxProfileRuntime = "runtime" // 解释器与虚拟机配置，额外允许文件状态查询、线程创建及休眠
+)
+
+// SandboxProfiles 运行模式下可用的全部seccomp过滤器配置名
+var SandboxProfiles = []string{SandboxProfileStrict, SandboxProfileRuntime}
+
+// Sandbox 表示运行环境配置
+type Sandbox struct {
+	Profile string   // seccomp过滤器配置名，为空时使用默认配置
+	Env     []string // 额外的环境变量，格式为KEY=VALUE
+}
+
+// Sandbox 返回语言对应的运行环境配置
+func (l Language) Sandbox() Sandbox {
+	env := make([]string, 0, len(l.Env))
+	for k, v := range l.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return Sandbox{
+		Profile: l.SandboxProfile,
+		Env:     env,
+	}
+}
+
+// LanguageInfo 表示提供给客户端的语言信息
+type LanguageInfo struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	SourceFile     string     `json:"source_file"`
+	Compiled       bool       `json:"compiled"`            // 是否需要编译
+	Highlight      string     `json:"highlight,omitempty"` // 语法高亮标识
+	Version        string     `json:"version,omitempty"`   // 启动时检测到的编译器或解释器版本
+	TimeMultiplier float64    `json:"time_multiplier"`     // 运行时间限制倍率
+	ExtraMemory    uint       `json:"extra_memory"`        // KB 额外允许的内存
+	Limits         LimitInfo  `json:"limits"`              // 未指定限制时实际生效的运行限制（已应用倍率与额外内存）
+	CompileLimits  *LimitInfo `json:"compile_limits,omitempty"`
+	CompileOptions []string   `json:"compile_options,omitempty"` // 可选择的编译选项
+	DefaultOptions []string   `json:"default_options,omitempty"` // 未选择时使用的编译选项
+}
+
+// LimitInfo 表示资源限制
+type LimitInfo struct {
+	CpuTime float64 `json:"cpu_time"`          // 秒
+	Memory  uint    `json:"memory"`            // KB
+	Stack   int     `json:"stack,omitempty"`   // KB，-1表示不限制
+	Process int     `json:"process,omitempty"` // 进程与线程数
 }
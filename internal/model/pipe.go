@@ -52,6 +52,24 @@ func (p *Pipe) Read() (string, error) {
 	return buffer.String(), nil
 }
 
+// ReadLimit 从管道读取全部数据，仅保留前limit字节，超出部分读取后丢弃以免写入端阻塞
+// limit小于等于0表示不限制，返回读取的数据及是否发生截断
+func (p *Pipe) ReadLimit(limit int) (string, bool, error) {
+	if limit <= 0 {
+		s, err := p.Read()
+		return s, false, err
+	}
+	var buffer bytes.Buffer
+	_, err := io.CopyN(&buffer, p.Reader, int64(limit))
+	if err == io.EOF {
+		return buffer.String(), false, nil
+	} else if err != nil {
+		return buffer.String(), false, err
+	}
+	discarded, err := io.Copy(io.Discard, p.Reader)
+	return buffer.String(), discarded > 0, err
+}
+
 // CopyFrom 将任意Reader接口的数据持续写入管道Writer
 // 参数：
 //
@@ -23,8 +23,37 @@ const (
 	StatusRE        StatusId = 12
 	StatusIE        StatusId = 13
 	StatusEFE       StatusId = 14
+	StatusRESIGSYS  StatusId = 15
+	StatusPLE       StatusId = 16
+	StatusFinished  StatusId = 17 // 自测模式下未提供预期输出的正常结束，不参与评测结果的合并
 )
 
+// statusSeverity 合并多个测试点的结果时各状态的严重程度，数值越大越严重
+// 状态ID按加入的先后分配，不代表严重程度：新加入的运行错误与资源限制排在内部错误之前，
+// Finished 只比 AC 严重，任何错误都会覆盖它；未列出的状态（等待与评测中）为0
+var statusSeverity = map[StatusId]int{
+	StatusAC:        1,
+	StatusFinished:  2,
+	StatusWA:        3,
+	StatusTLE:       4,
+	StatusPLE:       5,
+	StatusCE:        6,
+	StatusRESIGSEGV: 7,
+	StatusRESIGXFSZ: 8,
+	StatusRESIGFPE:  9,
+	StatusRESIGABRT: 10,
+	StatusRESIGSYS:  11,
+	StatusRENZEC:    12,
+	StatusRE:        13,
+	StatusIE:        14,
+	StatusEFE:       15,
+}
+
+// Severity 返回状态的严重程度，合并结果时取最严重的状态
+func (s StatusId) Severity() int {
+	return statusSeverity[s]
+}
+
 func (s StatusId) String() string {
 	switch s {
 	case StatusPD:
@@ -57,6 +86,12 @@ func (s StatusId) String() string {
 		return "Internal Error"
 	case StatusEFE:
 		return "Exec Format Error"
+	case StatusRESIGSYS:
+		return "Runtime Error (Illegal Syscall)"
+	case StatusPLE:
+		return "Process Limit Exceeded"
+	case StatusFinished:
+		return "Finished"
 	default:
 		return "Unknown"
 	}
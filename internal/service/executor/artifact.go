@@ -0,0 +1,243 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"nightcord-server/internal/conf"
+	"nightcord-server/internal/model"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultArtifactTTL 配置未指定 artifact_ttl 时编译产物的保留时间
+const defaultArtifactTTL = 10 * time.Minute
+
+var (
+	// ErrArtifactNotFound 编译产物句柄不存在或已过期
+	ErrArtifactNotFound = errors.New("artifact not found or expired")
+	// ErrArtifactStoreFull 编译产物数量已达上限且全部正在运行，无法淘汰
+	ErrArtifactStoreFull = errors.New("artifact store full")
+)
+
+// artifact 表示 POST /compile 保留的编译产物
+type artifact struct {
+	lang      model.Language
+	req       model.SubmitRequest // 仅保留编译选项与评测程序，用于展开运行命令
+	workDir   string
+	expiresAt time.Time
+	running   int  // 正在进行的运行次数，大于0时不会被清理
+	deleted   bool // 已请求删除，等待运行结束后清理
+}
+
+// ArtifactStore 管理编译产物句柄，过期的产物由后台协程定期清理
+type ArtifactStore struct {
+	mu        sync.Mutex
+	artifacts map[string]*artifact
+	ttl       time.Duration
+	limit     int
+}
+
+var (
+	globalArtifactStore *ArtifactStore
+	onceArtifactStore   sync.Once
+)
+
+// GetArtifactStoreInstance 获取 ArtifactStore 的单例
+func GetArtifactStoreInstance() *ArtifactStore {
+	onceArtifactStore.Do(func() {
+		ttl := time.Duration(conf.Conf.Executor.ArtifactTTL) * time.Second
+		if ttl <= 0 {
+			ttl = defaultArtifactTTL
+		}
+		globalArtifactStore = NewArtifactStore(ttl, conf.Conf.Executor.ArtifactLimit)
+		go globalArtifactStore.cleanupLoop()
+	})
+	return globalArtifactStore
+}
+
+// NewArtifactStore 创建编译产物存储，不启动后台清理
+func NewArtifactStore(ttl time.Duration, limit int) *ArtifactStore {
+	return &ArtifactStore{
+		artifacts: make(map[string]*artifact),
+		ttl:       ttl,
+		limit:     limit,
+	}
+}
+
+// Compile 准备工作目录并编译，编译成功时保留工作目录并返回句柄
+// 编译产物数量已达上限且无法淘汰时返回 ErrArtifactStoreFull，编译前后各检查一次，已编译的产物被丢弃
+func (s *ArtifactStore) Compile(ctx context.Context, req model.SubmitRequest) (model.CompileResponse, error) {
+	var resp model.CompileResponse
+	s.mu.Lock()
+	ok := s.reserveLocked()
+	s.mu.Unlock()
+	if !ok {
+		return resp, ErrArtifactStoreFull
+	}
+	// 指定题目时使用题目的评测程序
+	if req.ProblemID != 0 {
+		syncStorage()
+		if err := ResolveProblem(&req); err != nil {
+			resp.Status = model.StatusIE.GetStatus()
+			resp.Message = fmt.Sprintf("Problem resolution failed: %v", err)
+			return resp, nil
+		}
+	}
+	lang, workDir, compileRes, err := PrepareEnvironmentAndCompile(ctx, req)
+	resp.Compilation = compileRes
+	if err != nil || !compileRes.Success {
+		if workDir != "" {
+			os.RemoveAll(workDir)
+		}
+		if err != nil {
+			resp.Status = model.StatusIE.GetStatus()
+			resp.Message = fmt.Sprintf("Environment preparation failed: %v", err)
+		} else {
+			resp.Status = model.StatusCE.GetStatus()
+			resp.Message = compileRes.Message
+		}
+		return resp, nil
+	}
+
+	handle := rand.Text()
+	expiresAt := time.Now().Add(s.ttl)
+	s.mu.Lock()
+	// 编译期间其他请求可能已占满存储
+	if !s.reserveLocked() {
+		s.mu.Unlock()
+		os.RemoveAll(workDir)
+		return model.CompileResponse{}, ErrArtifactStoreFull
+	}
+	s.artifacts[handle] = &artifact{
+		lang: lang,
+		req: model.SubmitRequest{
+			LanguageID:     req.LanguageID,
+			CompileOptions: req.CompileOptions,
+			Graders:        req.Graders,
+			ProblemID:      req.ProblemID,
+		},
+		workDir:   workDir,
+		expiresAt: expiresAt,
+	}
+	s.mu.Unlock()
+
+	resp.Status = model.StatusAC.GetStatus()
+	resp.Handle = handle
+	resp.ExpiresAt = &expiresAt
+	return resp, nil
+}
+
+// Run 使用句柄对应的编译产物运行一次，并顺延句柄的过期时间
+func (s *ArtifactStore) Run(ctx context.Context, req model.RunRequest) (model.RunResult, error) {
+	return s.RunStream(ctx, req, strings.NewReader(req.Stdin))
+}
+
+// RunStream 与 Run 相同，但从stdin读取标准输入而不使用 req.Stdin，用于较大的输入
+// 句柄不存在时在读取stdin之前返回 ErrArtifactNotFound
+func (s *ArtifactStore) RunStream(ctx context.Context, req model.RunRequest, stdin io.Reader) (model.RunResult, error) {
+	s.mu.Lock()
+	a, ok := s.artifacts[req.Handle]
+	if !ok || a.deleted || time.Now().After(a.expiresAt) {
+		s.mu.Unlock()
+		return model.RunResult{}, ErrArtifactNotFound
+	}
+	a.running++
+	a.expiresAt = time.Now().Add(s.ttl)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		a.running--
+		if !a.deleted {
+			a.expiresAt = time.Now().Add(s.ttl)
+		}
+		s.mu.Unlock()
+	}()
+
+	limiter := GetRunLimiter(a.lang, model.SubmitRequest{
+		CpuTimeLimit: req.CpuTimeLimit,
+		MemoryLimit:  req.MemoryLimit,
+		StackLimit:   req.StackLimit,
+	})
+	runCmd := GetRunCommand(a.lang, a.req, a.workDir, limiter)
+	runExe := GetRunExecutor(runCmd, limiter, a.lang.Sandbox(), a.workDir, true, stdin)
+	return GetRunManagerInstance().SubmitRunJob(NewRunJob(runExe, ctx)), nil
+}
+
+// Delete 立即删除句柄对应的编译产物，正在运行时推迟到运行结束后由清理协程删除
+func (s *ArtifactStore) Delete(handle string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.artifacts[handle]
+	if !ok || a.deleted {
+		return ErrArtifactNotFound
+	}
+	if a.running > 0 {
+		a.deleted = true
+		a.expiresAt = time.Time{}
+		return nil
+	}
+	s.removeLocked(handle)
+	return nil
+}
+
+// cleanupLoop 定期删除过期且未在运行的编译产物
+func (s *ArtifactStore) cleanupLoop() {
+	interval := min(s.ttl, time.Minute)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.Cleanup()
+	}
+}
+
+// Cleanup 删除过期且未在运行的编译产物
+func (s *ArtifactStore) Cleanup() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for handle, a := range s.artifacts {
+		if a.running == 0 && now.After(a.expiresAt) {
+			s.removeLocked(handle)
+		}
+	}
+}
+
+// reserveLocked 确保还能保留一个编译产物，数量已达上限时淘汰一个，全部正在运行而无法淘汰时返回false，调用者需持有锁
+func (s *ArtifactStore) reserveLocked() bool {
+	if s.limit <= 0 || len(s.artifacts) < s.limit {
+		return true
+	}
+	return s.evictLocked()
+}
+
+// evictLocked 淘汰最早过期且未在运行的编译产物，没有可淘汰的产物时返回false，调用者需持有锁
+func (s *ArtifactStore) evictLocked() bool {
+	var oldest string
+	for handle, a := range s.artifacts {
+		if a.running == 0 && (oldest == "" || a.expiresAt.Before(s.artifacts[oldest].expiresAt)) {
+			oldest = handle
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	s.removeLocked(oldest)
+	return true
+}
+
+// removeLocked 删除编译产物及其工作目录，调用者需持有锁
+func (s *ArtifactStore) removeLocked(handle string) {
+	os.RemoveAll(s.artifacts[handle].workDir)
+	delete(s.artifacts, handle)
+}
@@ -0,0 +1,216 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"nightcord-server/internal/conf"
+	"nightcord-server/internal/model"
+	"nightcord-server/internal/service/language"
+	"nightcord-server/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	cacheFilesDir   = "files"       // 缓存条目中存放编译产物的子目录
+	cacheResultFile = "result.json" // 缓存条目中保存编译结果的文件
+	cacheTmpPrefix  = ".tmp-"       // 正在写入的缓存条目目录前缀
+)
+
+// CompileCache 以内容哈希为键的编译产物缓存，存放在磁盘上，超出容量时按最近最少使用淘汰
+// 每个条目为缓存目录下以键命名的子目录，最近使用时间记录在目录的修改时间上，重启后据此恢复淘汰顺序
+type CompileCache struct {
+	dir string
+	mu  sync.Mutex     // 保护lru
+	lru *utils.DiskLRU // 条目名即为键
+}
+
+var (
+	globalCompileCache *CompileCache
+	onceCompileCache   sync.Once
+)
+
+// GetCompileCacheInstance 获取编译缓存的单例，未启用或初始化失败时返回nil
+func GetCompileCacheInstance() *CompileCache {
+	onceCompileCache.Do(func() {
+		if conf.Conf.Executor.CompileCacheSize <= 0 || conf.Conf.Executor.CompileCacheDir == "" {
+			return
+		}
+		cache, err := NewCompileCache(conf.Conf.Executor.CompileCacheDir, int64(conf.Conf.Executor.CompileCacheSize)*1024)
+		if err != nil {
+			log.Printf("Failed to initialize compile cache, caching disabled: %v", err)
+			return
+		}
+		globalCompileCache = cache
+	})
+	return globalCompileCache
+}
+
+// NewCompileCache 创建编译缓存，加载目录中已有的条目并清理未写完的条目
+func NewCompileCache(dir string, maxSize int64) (*CompileCache, error) {
+	lru, err := utils.NewDiskLRU(dir, maxSize, func(key string) string { return key }, func(d fs.DirEntry) (string, bool) {
+		return d.Name(), d.IsDir() && !strings.HasPrefix(d.Name(), cacheTmpPrefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CompileCache{dir: dir, lru: lru}, nil
+}
+
+// Key 根据语言、编译器版本、展开后的编译命令、编译限制与工作目录中的全部输入文件计算缓存键
+// command中的工作目录路径替换为占位符，不同工作目录中的相同提交得到相同的键
+// 同时返回输入文件的相对路径，写入缓存时这些文件不作为编译产物保存
+func (c *CompileCache) Key(lang model.Language, command string, limiter model.Limiter, workDir string) (string, []string, error) {
+	if absDir, err := filepath.Abs(workDir); err == nil {
+		command = strings.ReplaceAll(command, absDir, "{workdir}")
+	}
+	h := sha256.New()
+	writeField(h, fmt.Sprint(lang.ID))
+	writeField(h, command)
+	writeField(h, fmt.Sprintf("%+v", limiter))
+	writeField(h, language.GetLanguageVersion(lang.ID))
+	writeField(h, strings.Join(lang.Sandbox().Env, "\x00"))
+
+	// filepath.WalkDir 按字典序遍历，保证相同的文件集合得到相同的键
+	var inputs []string
+	err := filepath.WalkDir(workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(workDir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		writeField(h, filepath.ToSlash(rel))
+		fmt.Fprintf(h, "%d:", info.Size())
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		inputs = append(inputs, rel)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), inputs, nil
+}
+
+// Get 查找缓存条目，命中时将编译产物复制到工作目录并返回缓存的编译结果
+func (c *CompileCache) Get(key string, workDir string) (model.CompilationResult, bool) {
+	var res model.CompilationResult
+	c.mu.Lock()
+	ok := c.lru.Touch(key)
+	c.mu.Unlock()
+	if !ok {
+		return res, false
+	}
+
+	entryDir := filepath.Join(c.dir, key)
+	data, err := os.ReadFile(filepath.Join(entryDir, cacheResultFile))
+	if err == nil {
+		err = json.Unmarshal(data, &res)
+	}
+	if err == nil {
+		err = utils.CopyDir(filepath.Join(entryDir, cacheFilesDir), workDir)
+	}
+	if err != nil {
+		// 条目损坏或在复制期间被淘汰，视为未命中
+		c.remove(key)
+		return model.CompilationResult{}, false
+	}
+	return res, true
+}
+
+// Put 将工作目录中除输入文件以外的编译产物与编译结果写入缓存
+func (c *CompileCache) Put(key string, workDir string, inputs []string, res model.CompilationResult) error {
+	c.mu.Lock()
+	exists := c.lru.Contains(key)
+	c.mu.Unlock()
+	if exists {
+		return nil
+	}
+
+	tmpDir, err := os.MkdirTemp(c.dir, cacheTmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir) // 重命名成功后为空操作
+
+	filesDir := filepath.Join(tmpDir, cacheFilesDir)
+	err = filepath.WalkDir(workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(filesDir, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type().IsRegular() && !slices.Contains(inputs, rel):
+			return utils.CopyFile(path, target)
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, cacheResultFile), data, 0644); err != nil {
+		return err
+	}
+	size, err := utils.DirSize(tmpDir)
+	if err != nil {
+		return err
+	}
+	if size > c.lru.MaxSize() {
+		return nil // 单个条目超出容量，不缓存
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru.Contains(key) {
+		return nil // 并发的相同提交已写入
+	}
+	if err := os.Rename(tmpDir, c.lru.Path(key)); err != nil {
+		return err
+	}
+	c.lru.Add(key, size)
+	return nil
+}
+
+// remove 删除指定条目
+func (c *CompileCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Remove(key)
+}
+
+// writeField 以长度前缀写入字段，避免相邻字段拼接产生歧义
+func writeField(h hash.Hash, s string) {
+	fmt.Fprintf(h, "%d:%s", len(s), s)
+}
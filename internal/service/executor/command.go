@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"fmt"
+	"nightcord-server/internal/model"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// shellSafe 匹配无需引号即可安全传给 sh 的参数
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_+=.,/:@%-]+$`)
+
+// CommandVars 命令模板中占位符对应的值
+type CommandVars struct {
+	Source   string   // {source} 源文件名
+	Binary   string   // {binary} 编译产物文件名
+	MemoryMB uint     // {memory_mb} 内存限制（MB）
+	WorkDir  string   // {workdir} 工作目录的绝对路径
+	Args     []string // {args} 编译选项
+	Graders  []string // {graders} 评测程序文件的相对路径
+}
+
+// NewCommandVars 根据语言配置、工作目录与当前阶段的资源限制生成占位符的值
+func NewCommandVars(lang model.Language, workDir string, limiter model.Limiter, args []string) CommandVars {
+	absDir, err := filepath.Abs(workDir)
+	if err != nil {
+		absDir = workDir
+	}
+	return CommandVars{
+		Source:   lang.SourceFile,
+		Binary:   lang.Binary(),
+		MemoryMB: limiter.Memory / 1024,
+		WorkDir:  absDir,
+		Args:     args,
+	}
+}
+
+// ExpandCommand 展开命令模板中的占位符，旧配置中的%s按{args}处理
+func ExpandCommand(cmd string, vars CommandVars) string {
+	args := make([]string, len(vars.Args))
+	for i, arg := range vars.Args {
+		args[i] = shellQuote(arg)
+	}
+	argStr := strings.Join(args, " ")
+	graders := make([]string, len(vars.Graders))
+	for i, grader := range vars.Graders {
+		graders[i] = shellQuote(grader)
+	}
+	return strings.NewReplacer(
+		"{source}", shellQuote(vars.Source),
+		"{binary}", shellQuote(vars.Binary),
+		"{memory_mb}", strconv.FormatUint(uint64(vars.MemoryMB), 10),
+		"{workdir}", shellQuote(vars.WorkDir),
+		"{args}", argStr,
+		"{graders}", strings.Join(graders, " "),
+		"%s", argStr,
+	).Replace(cmd)
+}
+
+// ResolveCompileOptions 校验提交选择的编译选项，未选择时返回语言的默认选项
+func ResolveCompileOptions(lang model.Language, options []string) ([]string, error) {
+	if len(options) == 0 {
+		return lang.DefaultOptions, nil
+	}
+	for _, opt := range options {
+		if !slices.Contains(lang.CompileOptions, opt) {
+			return nil, fmt.Errorf("compile option %q is not allowed for %s", opt, lang.Name)
+		}
+	}
+	return options, nil
+}
+
+// shellQuote 在需要时用单引号包裹参数，避免被 sh 解释
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"nightcord-server/internal/model"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// diagnosticPattern 匹配 GCC/Clang（file:line:column: severity: message）
+// 与 javac（file:line: severity: message）风格的诊断行
+var diagnosticPattern = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)?\s*(fatal error|error|warning|note):\s*(.*)$`)
+
+// ParseDiagnostics 从编译输出中解析诊断信息，文件路径转换为相对工作目录的路径
+// javac 不输出列号，若诊断行后紧跟源码行与只含 ^ 的行，则以 ^ 的位置作为列号
+func ParseDiagnostics(output string, workDir string) []model.Diagnostic {
+	absDir, err := filepath.Abs(workDir)
+	if err != nil {
+		absDir = workDir
+	}
+	var diagnostics []model.Diagnostic
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		m := diagnosticPattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		d := model.Diagnostic{
+			File:     m[1],
+			Severity: m[4],
+			Message:  m[5],
+		}
+		d.Line, _ = strconv.Atoi(m[2])
+		if m[3] != "" {
+			d.Column, _ = strconv.Atoi(m[3])
+		} else if i+2 < len(lines) && strings.TrimSpace(lines[i+2]) == "^" {
+			d.Column = strings.Index(lines[i+2], "^") + 1
+		}
+		if d.Severity == "fatal error" {
+			d.Severity = model.DiagnosticError
+		}
+		if rel, err := filepath.Rel(absDir, d.File); err == nil && filepath.IsAbs(d.File) && !strings.HasPrefix(rel, "..") {
+			d.File = rel
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
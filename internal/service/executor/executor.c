@@ -1,25 +1,45 @@
 #include "executor.h"
 
-// 运行子进程过滤器
-scmp_filter_ctx runSeccompFilter;
+// 运行子进程过滤器，按SandboxProfile索引
+scmp_filter_ctx runSeccompFilters[PROFILE_COUNT];
 // 编译子进程过滤器
 scmp_filter_ctx compileSeccompFilter;
 
+// 准备步骤失败时使用的回报描述符，由childProcess设置
+static int setupReportFd = -1;
+
+/*
+ * 函数名：setupFailed
+ * 参数：const char *what - 失败的步骤
+ * 返回值：无（以退出码2结束）
+ * 功能描述：执行命令前的准备步骤失败时调用。输出错误到标准错误，并向回报管道写入"setup <步骤>: <原因>"，
+ * 使父进程能将其与命令自身以退出码2结束的情况区分开。
+ */
+void setupFailed(const char *what)
+{
+    int err = errno;
+    perror(what);
+    if (setupReportFd >= 0)
+    {
+        dprintf(setupReportFd, "setup %s: %s", what, strerror(err));
+    }
+    _exit(2);
+}
+
 // 设置seccomp过滤器
 void setupSeccomp(scmp_filter_ctx ctx)
 {
     // 加载seccomp过滤器
     if (seccomp_load(ctx) != 0)
     {
-        perror("seccomp_load failed");
-        exit(2);
+        setupFailed("seccomp_load failed");
     }
 }
 
 /**
  * 设置进程的资源限制。
  *
- * @param limiter 指向Limiter结构的指针，包含CPU时间和内存限制的配置值。
+ * @param limiter 指向Limiter结构的指针，包含CPU时间、内存、栈空间等限制的配置值。
  * @return 无返回值。若设置失败则终止程序。
  */
 void setLimits(Limiter *limiter)
@@ -31,8 +51,7 @@ void setLimits(Limiter *limiter)
     cpu_limit.rlim_max = (rlim_t)limiter->CpuTime_max;
     if (setrlimit(RLIMIT_CPU, &cpu_limit) == -1)
     {
-        perror("setrlimit(RLIMIT_CPU)");
-        exit(2);
+        setupFailed("setrlimit(RLIMIT_CPU)");
     }
 
     /* 设置虚拟内存使用限制（RLIMIT_AS），单位转换为字节 */
@@ -40,24 +59,229 @@ void setLimits(Limiter *limiter)
     mem_limit.rlim_max = limiter->Memory_max * 1024;
     if (setrlimit(RLIMIT_AS, &mem_limit) == -1)
     {
-        perror("setrlimit(RLIMIT_AS)");
-        exit(2);
+        setupFailed("setrlimit(RLIMIT_AS)");
+    }
+
+    /* 设置栈空间限制（RLIMIT_STACK），单位转换为字节 */
+    if (limiter->Stack != 0)
+    {
+        struct rlimit stack_limit;
+        stack_limit.rlim_cur = limiter->Stack < 0 ? RLIM_INFINITY : (rlim_t)limiter->Stack * 1024;
+        stack_limit.rlim_max = stack_limit.rlim_cur;
+        if (setrlimit(RLIMIT_STACK, &stack_limit) == -1)
+        {
+            setupFailed("setrlimit(RLIMIT_STACK)");
+        }
+    }
+
+    /* 设置写入文件大小限制（RLIMIT_FSIZE），超出时进程收到SIGXFSZ */
+    if (limiter->FileSize > 0)
+    {
+        struct rlimit fsize_limit;
+        fsize_limit.rlim_cur = (rlim_t)limiter->FileSize * 1024;
+        fsize_limit.rlim_max = fsize_limit.rlim_cur;
+        if (setrlimit(RLIMIT_FSIZE, &fsize_limit) == -1)
+        {
+            setupFailed("setrlimit(RLIMIT_FSIZE)");
+        }
     }
 
     /* 设置最大文件描述符数限制（RLIMIT_NOFILE） */
     struct rlimit nofile_limit = {1024, 1024}; // 最大文件描述符数
     if (setrlimit(RLIMIT_NOFILE, &nofile_limit) == -1)
     {
-        perror("setrlimit(RLIMIT_NOFILE)");
-        exit(2);
+        setupFailed("setrlimit(RLIMIT_NOFILE)");
     }
 
     /* 禁用核心转储（RLIMIT_CORE） */
     struct rlimit core_limit = {0, 0}; // 禁用核心转储
     if (setrlimit(RLIMIT_CORE, &core_limit) == -1)
     {
-        perror("setrlimit(RLIMIT_CORE)");
-        exit(2);
+        setupFailed("setrlimit(RLIMIT_CORE)");
+    }
+}
+
+/*
+ * 函数名：execCommand
+ * 参数：Executor *executor - 指向执行器结构体的指针
+ * 返回值：无（实际通过execl替换进程映像或_exit()退出）
+ * 功能描述：设置环境变量、应用资源限制与seccomp过滤器后，通过shell执行命令。
+ */
+void execCommand(Executor *executor)
+{
+    // 设置额外的环境变量
+    for (char **env = executor->Env; env != NULL && *env != NULL; env++)
+    {
+        putenv(*env);
+    }
+
+    // 应用资源限制配置
+    setLimits(&executor->Limit);
+
+    // 根据执行模式设置seccomp安全过滤器（运行模式或编译模式）
+    setupSeccomp(executor->RunFlag ? runSeccompFilters[executor->Profile] : compileSeccompFilter);
+
+    // 禁止进程后续获得新权限
+    if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) == -1)
+    {
+        setupFailed("prctl(PR_SET_NO_NEW_PRIVS)");
+    }
+
+    // 执行指定的命令字符串，通过shell解释执行
+    execl("/bin/sh", "sh", "-c", executor->Command, (char *)NULL);
+    setupFailed("execl fail");
+}
+
+/*
+ * 函数名：mirrorSignal
+ * 参数：int sig - 需要复现的信号
+ * 返回值：无（进程以该信号终止）
+ * 功能描述：恢复信号的默认处理方式后向自身发送该信号，使监控进程以与被监控进程相同的信号结束。
+ */
+void mirrorSignal(int sig)
+{
+    sigset_t set;
+    signal(sig, SIG_DFL);
+    sigemptyset(&set);
+    sigaddset(&set, sig);
+    sigprocmask(SIG_UNBLOCK, &set, NULL);
+    kill(getpid(), sig);
+    _exit(128 + sig);
+}
+
+/*
+ * 函数名：superviseProcess
+ * 参数：Executor *executor - 指向执行器结构体的指针
+ * 返回值：无（以被监控进程相同的退出码或信号结束）
+ * 功能描述：监控进程。派生一个被ptrace追踪的子进程执行命令，追踪选项会被其派生的所有进程与线程继承。
+ * 被追踪进程触发运行过滤器中的SCMP_ACT_TRACE规则时，向ReportFd写入"syscall <系统调用号>"；
+ * 存活的进程与线程数超出Limit.Process时，向ReportFd写入"nproc <限制值>"，两种情况下都会杀死子进程。
+ * 子进程结束后监控进程复现其退出码或信号（shell报告其派生进程被信号终止时复现该信号），
+ * 使父进程通过wait4得到的结果与直接运行一致。
+ */
+void superviseProcess(Executor *executor)
+{
+    // 监控进程复现信号时不产生核心转储
+    struct rlimit core_limit = {0, 0};
+    setrlimit(RLIMIT_CORE, &core_limit);
+
+    pid_t pid = fork();
+    if (pid == -1)
+    {
+        setupFailed("fork(supervise)");
+    }
+    if (pid == 0)
+    {
+        // 回报管道保留到exec为止，用于报告准备步骤失败的原因
+        if (executor->ReportFd >= 0)
+        {
+            fcntl(executor->ReportFd, F_SETFD, FD_CLOEXEC);
+        }
+        if (ptrace(PTRACE_TRACEME, 0, NULL, NULL) == -1)
+        {
+            setupFailed("ptrace(PTRACE_TRACEME)");
+        }
+        // 停止自身，等待监控进程设置追踪选项
+        raise(SIGSTOP);
+        execCommand(executor);
+    }
+
+    int status;
+    int traced = 0;
+    int reported = 0;
+    int procs = 1;      // 存活的被追踪进程与线程数
+    int lastSignal = 0; // 最近一个被信号终止的派生进程所收到的信号
+    for (;;)
+    {
+        // 等待所有被追踪进程（包括shell派生的子进程）的状态变化
+        pid_t wpid = waitpid(-1, &status, __WALL);
+        if (wpid == -1)
+        {
+            if (errno == EINTR)
+            {
+                continue;
+            }
+            setupFailed("waitpid(supervise)");
+        }
+        if (WIFEXITED(status) || WIFSIGNALED(status))
+        {
+            if (wpid != pid)
+            {
+                procs--;
+                if (WIFSIGNALED(status))
+                {
+                    lastSignal = WTERMSIG(status);
+                }
+                continue;
+            }
+            if (WIFSIGNALED(status))
+            {
+                mirrorSignal(WTERMSIG(status));
+            }
+            // shell以128+信号值的退出码报告其子进程被信号终止，此时复现该信号
+            if (lastSignal != 0 && WEXITSTATUS(status) == 128 + lastSignal)
+            {
+                mirrorSignal(lastSignal);
+            }
+            _exit(WEXITSTATUS(status));
+        }
+        if (!WIFSTOPPED(status))
+        {
+            continue;
+        }
+
+        int sig = WSTOPSIG(status);
+        int event = status >> 16;
+        if (!traced)
+        {
+            // 首次停止来自子进程的raise(SIGSTOP)，设置的追踪选项会被其派生的进程继承
+            if (ptrace(PTRACE_SETOPTIONS, pid, NULL,
+                       PTRACE_O_TRACESECCOMP | PTRACE_O_TRACEEXEC | PTRACE_O_TRACEFORK |
+                           PTRACE_O_TRACEVFORK | PTRACE_O_TRACECLONE | PTRACE_O_EXITKILL) == -1)
+            {
+                kill(pid, SIGKILL);
+                setupFailed("ptrace(PTRACE_SETOPTIONS)");
+            }
+            traced = 1;
+        }
+        if (event == PTRACE_EVENT_SECCOMP)
+        {
+            // 事件消息为规则中SCMP_ACT_TRACE携带的系统调用号
+            unsigned long nr;
+            if (!reported && ptrace(PTRACE_GETEVENTMSG, wpid, NULL, &nr) == 0 && executor->ReportFd >= 0)
+            {
+                dprintf(executor->ReportFd, "syscall %lu", nr);
+                reported = 1;
+            }
+            kill(wpid, SIGKILL);
+            kill(pid, SIGKILL);
+        }
+        else if (event == PTRACE_EVENT_FORK || event == PTRACE_EVENT_VFORK || event == PTRACE_EVENT_CLONE)
+        {
+            procs++;
+            if (executor->Limit.Process > 0 && procs > executor->Limit.Process)
+            {
+                // 新进程已被创建并自动追踪，连同父进程一起杀死
+                unsigned long child;
+                if (ptrace(PTRACE_GETEVENTMSG, wpid, NULL, &child) == 0)
+                {
+                    kill((pid_t)child, SIGKILL);
+                }
+                if (!reported && executor->ReportFd >= 0)
+                {
+                    dprintf(executor->ReportFd, "nproc %d", executor->Limit.Process);
+                    reported = 1;
+                }
+                kill(wpid, SIGKILL);
+                kill(pid, SIGKILL);
+            }
+        }
+        // 追踪事件与新进程的初始SIGSTOP不转发给被追踪进程
+        if (event != 0 || sig == SIGSTOP)
+        {
+            sig = 0;
+        }
+        ptrace(PTRACE_CONT, wpid, NULL, sig);
     }
 }
 
@@ -65,78 +289,59 @@ void setLimits(Limiter *limiter)
  * 函数名：childProcess
  * 参数：Executor *executor - 指向执行器结构体的指针，包含执行命令所需的各种配置（如文件描述符、目录、资源限制等）
  * 返回值：int - 退出状态码（实际通过_exit()退出，返回值由_exit参数决定）
- * 功能描述：在子进程中执行必要的初始化操作并启动指定的命令执行。主要步骤包括重定向标准输入输出、切换工作目录、设置资源限制和安全限制，最后通过shell执行命令。
+ * 功能描述：在子进程中执行必要的初始化操作并启动指定的命令执行。主要步骤包括重定向标准输入输出、切换工作目录，
+ * 随后成为监控进程，由其派生的子进程设置资源限制和安全限制后通过shell执行命令。
  */
 int childProcess(Executor *executor)
 {
+    setupReportFd = executor->ReportFd;
 
     // 重定向标准错误输出到executor指定的文件描述符，并关闭原始描述符
     if (dup2(executor->StderrFd, STDERR_FILENO) == -1)
     {
-        perror("dup2(STDERR_FILENO)");
-        _exit(3);
+        setupFailed("dup2(STDERR_FILENO)");
     }
     close(executor->StderrFd);
 
     // 重定向标准输入到executor指定的文件描述符，并关闭原始描述符
     if (dup2(executor->StdinFd, STDIN_FILENO) == -1)
     {
-        perror("dup2(STDIN_FILENO)");
-        _exit(2);
+        setupFailed("dup2(STDIN_FILENO)");
     }
     close(executor->StdinFd);
 
     // 重定向标准输出到executor指定的文件描述符，并关闭原始描述符
     if (dup2(executor->StdoutFd, STDOUT_FILENO) == -1)
     {
-        perror("dup2(STDOUT_FILENO)");
-        _exit(2);
+        setupFailed("dup2(STDOUT_FILENO)");
     }
     close(executor->StdoutFd);
 
-    if (executor->RunFlag)
+    // 关闭继承的其他描述符（保留回报管道，由监控进程使用）。监控进程不会exec，
+    // 若不关闭会一直持有其他并发任务的管道，使其读取端在对方进程退出前收不到EOF
+    DIR *dir = opendir("/proc/self/fd");
+    if (dir)
     {
-        int max_fd = sysconf(_SC_OPEN_MAX);
-        // 目录流关闭
-        DIR *dir = opendir("/proc/self/fd");
-        if (dir)
+        struct dirent *entry;
+        while ((entry = readdir(dir)) != NULL)
         {
-            struct dirent *entry;
-            while ((entry = readdir(dir)) != NULL)
+            int fd = atoi(entry->d_name);
+            if (fd > 2 && fd != dirfd(dir) && fd != executor->ReportFd)
             {
-                int fd = atoi(entry->d_name);
-                if (fd > 2 && fd != dirfd(dir))
-                {
-                    close(fd);
-                }
+                close(fd);
             }
-            closedir(dir);
         }
+        closedir(dir);
     }
 
     if (executor->Dir != NULL && chdir(executor->Dir) == -1)
     {
-        perror("pre-chdir failed");
-        _exit(2);
+        setupFailed("pre-chdir failed");
     }
 
-    // 应用资源限制配置
-    setLimits(&executor->Limit);
-
-    // 根据执行模式设置seccomp安全过滤器（运行模式或编译模式）
-    setupSeccomp(executor->RunFlag ? runSeccompFilter : compileSeccompFilter);
-
-    // 禁止进程后续获得新权限
-    if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) == -1)
-    {
-        perror("prctl(PR_SET_NO_NEW_PRIVS)");
-        _exit(2);
-    }
-
-    // 执行指定的命令字符串，通过shell解释执行
-    execl("/bin/sh", "sh", "-c", executor->Command, (char *)NULL);
-    perror("execl fail");
-    _exit(2);
+    // 由监控进程追踪执行，以便识别被拦截的系统调用并限制进程数
+    superviseProcess(executor);
+    return 0;
 }
 
 /**
@@ -165,14 +370,26 @@ int Execute(Executor *executor)
     return 0;
 }
 
-// 获取运行子进程过滤器
-scmp_filter_ctx getRunSeccompFilter()
+// 判断系统调用是否在列表中
+int containsCall(const int *calls, int n, int call)
+{
+    for (int i = 0; i < n; i++)
+    {
+        if (calls[i] == call)
+        {
+            return 1;
+        }
+    }
+    return 0;
+}
+
+// 获取运行子进程过滤器，profile为SandboxProfile中的配置
+scmp_filter_ctx getRunSeccompFilter(int profile)
 {
     scmp_filter_ctx ctx = seccomp_init(SCMP_ACT_ALLOW);
     if (!ctx)
     {
-        perror("seccomp_init failed");
-        exit(2);
+        setupFailed("seccomp_init failed");
     }
     int killCalls[] = {
         SCMP_SYS(kill),
@@ -247,17 +464,43 @@ scmp_filter_ctx getRunSeccompFilter()
         SCMP_SYS(open_by_handle_at),
     };
 
+    // 解释器与虚拟机配置额外允许的系统调用，clone仅允许创建线程
+    int runtimeCalls[] = {
+        SCMP_SYS(clone),
+        SCMP_SYS(nanosleep),
+        SCMP_SYS(clock_nanosleep),
+        SCMP_SYS(stat),
+        SCMP_SYS(lstat),
+        SCMP_SYS(fstat),
+    };
+    int runtimeCallsNum = sizeof(runtimeCalls) / sizeof(runtimeCalls[0]);
+
     seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(open), 1,
                      SCMP_A0(SCMP_CMP_MASKED_EQ, O_WRONLY | O_RDWR, 0));
     seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(openat), 1,
                      SCMP_A1(SCMP_CMP_MASKED_EQ, O_WRONLY | O_RDWR, 0));
 
+    if (profile == PROFILE_RUNTIME)
+    {
+        // clone3的参数无法过滤，令其返回ENOSYS使运行时回退到clone；clone仅拦截不创建线程的调用
+        if (seccomp_rule_add(ctx, SCMP_ACT_ERRNO(ENOSYS), SCMP_SYS(clone3), 0) != 0 ||
+            seccomp_rule_add(ctx, SCMP_ACT_TRACE(SCMP_SYS(clone)), SCMP_SYS(clone), 1,
+                             SCMP_A0(SCMP_CMP_MASKED_EQ, CLONE_THREAD, 0)) != 0)
+        {
+            setupFailed("seccomp_rule_add failed");
+        }
+    }
+
+    // 以SCMP_ACT_TRACE拦截，并携带系统调用号供监控进程回报
     for (int i = 0; i < sizeof(killCalls) / sizeof(killCalls[0]); i++)
     {
-        if (seccomp_rule_add(ctx, SCMP_ACT_KILL, killCalls[i], 0) != 0)
+        if (profile == PROFILE_RUNTIME && containsCall(runtimeCalls, runtimeCallsNum, killCalls[i]))
         {
-            perror("seccomp_rule_add failed");
-            exit(2);
+            continue;
+        }
+        if (seccomp_rule_add(ctx, SCMP_ACT_TRACE(killCalls[i]), killCalls[i], 0) != 0)
+        {
+            setupFailed("seccomp_rule_add failed");
         }
     }
     return ctx;
@@ -269,8 +512,7 @@ scmp_filter_ctx getCompileSeccompFilter()
     scmp_filter_ctx ctx = seccomp_init(SCMP_ACT_ALLOW);
     if (!ctx)
     {
-        perror("seccomp_init failed");
-        exit(2);
+        setupFailed("seccomp_init failed");
     }
     int killCalls[] = {
         SCMP_SYS(kill),
@@ -285,8 +527,7 @@ scmp_filter_ctx getCompileSeccompFilter()
     {
         if (seccomp_rule_add(ctx, SCMP_ACT_KILL, killCalls[i], 0) != 0)
         {
-            perror("seccomp_rule_add failed");
-            exit(2);
+            setupFailed("seccomp_rule_add failed");
         }
     }
     return ctx;
@@ -295,7 +536,10 @@ scmp_filter_ctx getCompileSeccompFilter()
 // 初始化全局变量的过滤器
 void InitFilter()
 {
-    runSeccompFilter = getRunSeccompFilter();
+    for (int i = 0; i < PROFILE_COUNT; i++)
+    {
+        runSeccompFilters[i] = getRunSeccompFilter(i);
+    }
     compileSeccompFilter = getCompileSeccompFilter();
     return;
 }
\ No newline at end of file
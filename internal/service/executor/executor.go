@@ -14,6 +14,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"math"
 	"nightcord-server/internal/conf"
 	"nightcord-server/internal/model"
@@ -22,7 +23,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -53,47 +56,58 @@ func PrepareEnvironmentAndCompile(ctx context.Context, req model.SubmitRequest)
 		return
 	}
 
-	// 临时目录创建阶段：使用互斥锁保证目录创建的原子性，创建随机名称的临时工作目录
-	folderLock.Lock()
-	// 假设 utils.RandomString 存在，类似于 ProcessJob 中使用的 random.String。
-	// 如果 utils.RandomString 不可用，则需要替换为项目实际的随机字符串生成器。
-	var folderName string
-	// 注意: 这里的 random.String(6) 需要确认是否为 utils.RandomString(6)
-	// 根据 ProcessJob 的上下文，应该是 utils.RandomString
-	// 如果 random 是一个标准库或者项目内定义的包，请相应调整
-	// 为保持与 ProcessJob 中逻辑的一致性，暂时保留 random.String，但建议后续确认为 utils.RandomString
-	// 修正：根据上下文，应该是 utils.RandomString
-	folderName = utils.RandomString(6) // 使用 utils 包下的 RandomString
-	err = utils.EnsureDir("tem")
+	// 编译选项校验阶段：提交选择的编译选项必须在语言声明的允许列表中
+	options, err := ResolveCompileOptions(lang, req.CompileOptions)
 	if err != nil {
-		folderLock.Unlock()
-		workDir = "" // 确保在错误时 workDir 为空，以便 defer os.RemoveAll 不会误删
 		return
 	}
-	workDir = filepath.Join("tem", folderName)
-	err = os.Mkdir(workDir, 0755)
+
+	// 临时目录创建阶段：创建随机名称的临时工作目录
+	workDir, err = NewWorkDir()
 	if err != nil {
-		folderLock.Unlock()
-		workDir = "" // 确保在错误时 workDir 为空
 		return
 	}
-	folderLock.Unlock() // 在成功创建或 Mkdir 错误处理后解锁
 
-	// 源码写入阶段：将用户提交的源代码写入配置指定的文件中
-	sourceFilePath := filepath.Join(workDir, lang.SourceFile)
-	if err = os.WriteFile(sourceFilePath, []byte(req.SourceCode), 0644); err != nil {
+	// 源码写入阶段：将用户提交的源代码、额外文件与压缩包写入工作目录
+	if err = WriteSubmission(workDir, lang, req); err != nil {
 		// 如果写入失败，workDir 仍然有效，调用者应该负责清理
 		return
 	}
 
+	// 评测程序写入阶段：函数式题目将评测程序文件放置在提交旁，并改用对应的编译命令
+	graders, err := SelectGraders(lang, req)
+	if err != nil {
+		return
+	}
+	if err = WriteGraders(workDir, graders); err != nil {
+		return
+	}
+	compileCmd := lang.CompileCommand(len(graders) > 0)
+
 	// 编译阶段：如果语言需要编译，执行编译命令并处理编译结果
-	if strings.TrimSpace(lang.CompileCmd) != "" {
-		compileCmdStr := fmt.Sprintf(lang.CompileCmd, "") // 假设 CompileCmd 可能为将来使用留有占位符
-		limiter := model.Limiter{
-			CpuTime: conf.Conf.Executor.CompileTimeout,
-			Memory:  uint(conf.Conf.Executor.CompileMemory),
+	if strings.TrimSpace(compileCmd) != "" {
+		limiter := GetCompileLimiter(lang)
+		vars := NewCommandVars(lang, workDir, ResolveLimiter(limiter, false), options)
+		vars.Graders = GraderPaths(graders)
+		compileCmdStr := ExpandCommand(compileCmd, vars)
+
+		// 缓存查找阶段：相同语言、编译命令、编译限制与源码的提交直接复用缓存的编译产物
+		cache := GetCompileCacheInstance()
+		var cacheKey string
+		var inputs []string
+		if cache != nil {
+			cacheKey, inputs, err = cache.Key(lang, compileCmdStr, limiter, workDir)
+			if err != nil {
+				return
+			}
+			if cachedRes, ok := cache.Get(cacheKey, workDir); ok {
+				compileRes = cachedRes
+				compileRes.Cached = true
+				return lang, workDir, compileRes, nil
+			}
 		}
-		compileRunExe := GetRunExecutor(compileCmdStr, limiter, workDir, false)
+
+		compileRunExe := GetRunExecutor(compileCmdStr, limiter, lang.Sandbox(), workDir, false)
 
 		runJob := NewRunJob(compileRunExe, ctx)
 
@@ -102,19 +116,42 @@ func PrepareEnvironmentAndCompile(ctx context.Context, req model.SubmitRequest)
 		compileRes = model.CompilationResult{
 			Success:     exeRes.Status.Id == model.StatusAC, // 根据编译结果设置编译成功状态
 			Message:     exeRes.Message,                     // 编译消息
-			Output:      exeRes.Stdout,
 			CompileTime: exeRes.Time,
 		}
 
-		if !compileRes.Success {
-			// 如果编译失败，根据 compileRes.Message 设置错误
-			// 调用者将检查 compileRes.Success
+		// 截断过长的编译输出，并从中解析诊断信息
+		outputLimit := -1
+		if conf.Conf.Executor.CompileOutputLimit > 0 {
+			outputLimit = conf.Conf.Executor.CompileOutputLimit * 1024
+		}
+		var stdoutTruncated, stderrTruncated bool
+		compileRes.Output, stdoutTruncated = utils.TruncateString(exeRes.Stdout, outputLimit)
+		compileRes.Stderr, stderrTruncated = utils.TruncateString(exeRes.Stderr, outputLimit)
+		compileRes.Truncated = stdoutTruncated || stderrTruncated || exeRes.Truncated
+		compileRes.Diagnostics = ParseDiagnostics(compileRes.Output+"\n"+compileRes.Stderr, workDir)
+
+		switch exeRes.Status.Id {
+		case model.StatusAC:
+		case model.StatusIE:
+			// 执行器自身出错，作为错误传播，调用者报告为内部错误
 			if compileRes.Message != "" {
-				err = errors.New(compileRes.Message) // 将编译消息作为错误传播
+				err = errors.New(compileRes.Message)
 			} else {
-				err = errors.New("compilation failed without specific message") // 通用编译错误
+				err = errors.New("compilation failed without specific message")
 			}
 			return // 在此返回，workDir 已设置，供调用者清理
+		default:
+			// 编译器报错、编译超时或超出资源限制均视为编译错误，调用者将检查 compileRes.Success
+			if compileRes.Message == "" {
+				compileRes.Message = exeRes.Status.Description
+			}
+		}
+
+		// 缓存写入阶段：仅缓存编译成功的结果，写入失败不影响本次评测
+		if compileRes.Success && cache != nil {
+			if err := cache.Put(cacheKey, workDir, inputs, compileRes); err != nil {
+				log.Printf("Failed to cache compile artifacts: %v", err)
+			}
 		}
 	} else {
 		compileRes.Success = true // 不需要编译，因此视为“成功”
@@ -123,6 +160,31 @@ func PrepareEnvironmentAndCompile(ctx context.Context, req model.SubmitRequest)
 	return lang, workDir, compileRes, nil
 }
 
+// GetRunCommand 根据提交的编译选项与评测程序展开语言的运行命令
+// 编译选项与评测程序已在 PrepareEnvironmentAndCompile 中校验
+func GetRunCommand(lang model.Language, req model.SubmitRequest, workDir string, limiter model.Limiter) string {
+	options, _ := ResolveCompileOptions(lang, req.CompileOptions)
+	graders, _ := SelectGraders(lang, req)
+	vars := NewCommandVars(lang, workDir, ResolveLimiter(limiter, true), options)
+	vars.Graders = GraderPaths(graders)
+	return ExpandCommand(lang.RunCommand(len(graders) > 0), vars)
+}
+
+// NewWorkDir 在 tem 下创建随机名称的临时工作目录，由调用者负责清理
+func NewWorkDir() (string, error) {
+	// 使用互斥锁保证目录创建的原子性
+	folderLock.Lock()
+	defer folderLock.Unlock()
+	if err := utils.EnsureDir("tem"); err != nil {
+		return "", err
+	}
+	workDir := filepath.Join("tem", utils.RandomString(6))
+	if err := os.Mkdir(workDir, 0755); err != nil {
+		return "", err
+	}
+	return workDir, nil
+}
+
 // GetRunExecutor 创建并返回一个执行命令的闭包函数，该闭包会：
 //   - 根据配置创建带资源限制的执行器
 //   - 处理标准输入输出管道
@@ -132,26 +194,23 @@ func PrepareEnvironmentAndCompile(ctx context.Context, req model.SubmitRequest)
 // 参数:
 //   - command: 需要执行的完整命令字符串
 //   - limiter: 资源限制配置（CPU时间单位：秒，内存单位：KB）
+//   - sandbox: 运行环境配置（seccomp过滤器配置名与额外的环境变量）
 //   - dir: 命令执行的工作目录
 //   - runFlag: 标识是否运行模式（影响CGO执行器行为）
 //   - stdin: 可变参数，可传递单个io.Reader作为标准输入
 //
 // 返回值:
 //   - func(context.Context) model.RunResult: 接收上下文返回执行结果的闭包函数
-func GetRunExecutor(command string, limiter model.Limiter, dir string, runFlag bool, stdin ...io.Reader) func(context.Context) model.RunResult {
+func GetRunExecutor(command string, limiter model.Limiter, sandbox model.Sandbox, dir string, runFlag bool, stdin ...io.Reader) func(context.Context) model.RunResult {
 	// 设置默认资源限制值（当未指定时使用配置中的默认值）
-	if limiter.CpuTime == 0 {
-		limiter.CpuTime = conf.Conf.Executor.CPUTimeLimit
-	}
-	if limiter.Memory == 0 {
-		limiter.Memory = conf.Conf.Executor.MemoryLimit
-	}
+	limiter = ResolveLimiter(limiter, runFlag)
 
 	// 创建基础执行器模板
 	exeTemplate := model.Executor{
 		Command: command,
 		Dir:     dir,
 		Limiter: limiter,
+		Sandbox: sandbox,
 		RunFlag: runFlag,
 	}
 
@@ -171,17 +230,22 @@ func GetRunExecutor(command string, limiter model.Limiter, dir string, runFlag b
 		runExe.Stdin = exePipe.In.Reader
 		runExe.Stdout = exePipe.Out.Writer
 		runExe.Stderr = exePipe.Err.Writer
-
-		// 将测试用例输入写入管道
-		if len(stdin) > 0 {
-			_, err = exePipe.In.CopyFrom(stdin[0])
-		}
-		if err != nil {
-			res.Status = model.StatusIE.GetStatus()
-			res.Message = fmt.Sprintf("write stdin pipe failed: %v", err.Error())
-			return
+		runExe.Report = exePipe.Report.Writer
+
+		// 启用工作目录配额时，单个文件的大小不超过剩余配额
+		quota := int64(conf.Conf.Executor.WorkDirQuota) * 1024
+		if quota > 0 {
+			used, err := utils.DirSize(runExe.Dir)
+			if err != nil {
+				res.Status = model.StatusIE.GetStatus()
+				res.Message = fmt.Sprintf("stat work directory failed: %v", err.Error())
+				return
+			}
+			remain := max((quota-used)/1024, 1)
+			if runExe.Limiter.FileSize == 0 || int64(runExe.Limiter.FileSize) > remain {
+				runExe.Limiter.FileSize = int(remain)
+			}
 		}
-		exePipe.In.Writer.Close()
 
 		// 执行目标程序并获取结果
 		pid, err := ProcessExecutor(runExe)
@@ -192,54 +256,81 @@ func GetRunExecutor(command string, limiter model.Limiter, dir string, runFlag b
 			return
 		}
 
+		// 关闭父进程中由子进程使用的管道端，子进程及其后代退出后读取端即可收到EOF
+		exePipe.In.Reader.Close()
+		exePipe.Out.Writer.Close()
+		exePipe.Err.Writer.Close()
+		exePipe.Report.Writer.Close()
+
+		// 在进程运行期间并发写入输入并读取输出，避免数据超出管道缓冲区时双方互相阻塞
+		var wg sync.WaitGroup
+		var stdinErr, stdoutErr, stderrErr error
+		var stdoutTruncated, stderrTruncated bool
+		var report string
+		outputLimit := runExe.Limiter.Output * 1024
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			defer exePipe.In.Writer.Close()
+			if len(stdin) > 0 {
+				_, stdinErr = exePipe.In.CopyFrom(stdin[0])
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			res.Stdout, stdoutTruncated, stdoutErr = exePipe.Out.ReadLimit(outputLimit)
+		}()
+		go func() {
+			defer wg.Done()
+			res.Stderr, stderrTruncated, stderrErr = exePipe.Err.ReadLimit(outputLimit)
+		}()
+		go func() {
+			defer wg.Done()
+			report, _ = exePipe.Report.Read()
+		}()
+
 		var exeRes model.ExecutorResult
 
 		monitorProcess(ctx, pid, &exeRes)
+		wg.Wait()
 
-		// 关闭输出管道并读取结果
-		exePipe.Out.Writer.Close()
-		exePipe.Err.Writer.Close()
+		// 读取监控进程回报的违规信息
+		ParseReport(report, &exeRes, SyscallName)
 
-		// 处理错误输出
-		res.Stderr, err = exePipe.Err.Read()
-		if err != nil {
+		// 程序未读完输入即退出时写入会返回EPIPE，属于正常情况
+		if stdinErr != nil && !errors.Is(stdinErr, syscall.EPIPE) {
 			res.Status = model.StatusIE.GetStatus()
-			res.Message = fmt.Sprintf("read stderr pipe failed: %v", err.Error())
+			res.Message = fmt.Sprintf("write stdin pipe failed: %v", stdinErr.Error())
+			return
 		}
-
-		// 处理标准输出
-		res.Stdout, err = exePipe.Out.Read()
-		if err != nil {
+		if stderrErr != nil {
 			res.Status = model.StatusIE.GetStatus()
-			res.Message = fmt.Sprintf("read stdout pipe failed: %v", err.Error())
+			res.Message = fmt.Sprintf("read stderr pipe failed: %v", stderrErr.Error())
+		}
+		if stdoutErr != nil {
+			res.Status = model.StatusIE.GetStatus()
+			res.Message = fmt.Sprintf("read stdout pipe failed: %v", stdoutErr.Error())
 			return
 		}
+		res.Truncated = stdoutTruncated || stderrTruncated
+		res.ExitCode = exeRes.ExitCode
+		res.Signal = int(exeRes.Signal)
+
+		// 检查工作目录是否超出配额
+		quotaExceeded := false
+		if quota > 0 {
+			if used, err := utils.DirSize(runExe.Dir); err == nil && used > quota {
+				quotaExceeded = true
+			}
+		}
 
 		// 设置执行时间和内存消耗
 		res.Memory = exeRes.Memory
 		res.Time = math.Round(exeRes.Time*1000) / 1000
+		res.WallTime = math.Round(exeRes.WallTime*1000) / 1000
 
 		// 根据退出码和信号判断执行状态
-		switch {
-		case exeRes.ExitCode == 3:
-			res.Status = model.StatusIE.GetStatus()
-			res.Message = "stderr pipe setup failed."
-		case exeRes.ExitCode == 2:
-			res.Status = model.StatusIE.GetStatus()
-			res.Message = res.Stderr
-		case exeRes.ExitCode == -1:
-			res.Status = model.StatusIE.GetStatus()
-			res.Message = "context canceled"
-		case res.Time >= runExe.Limiter.CpuTime:
-			res.Status = model.StatusTLE.GetStatus()
-		case res.Memory > runExe.Limiter.Memory*1024:
-			res.Status = model.StatusRESIGSEGV.GetStatus()
-		case exeRes.Signal != 0:
-			res.Status = SignalStatus(exeRes.Signal).GetStatus()
-			res.Message = SignalMessage(exeRes.Signal)
-		default:
-			res.Status = model.StatusAC.GetStatus()
-		}
+		res.Status, res.Message = RunStatus(exeRes, runExe.Limiter, runFlag, quotaExceeded)
 		return
 	}
 }
@@ -250,6 +341,7 @@ func monitorProcess(ctx context.Context, pid int, result *model.ExecutorResult)
 	var rusage syscall.Rusage
 
 	// 启动goroutine等待进程结束
+	start := time.Now()
 	go func() {
 		_, _ = syscall.Wait4(pid, &status, 0, &rusage)
 		close(done)
@@ -271,17 +363,29 @@ func monitorProcess(ctx context.Context, pid int, result *model.ExecutorResult)
 		}
 	}
 	// 正常处理结果
+	result.WallTime = time.Since(start).Seconds()
 	userTime := float64(rusage.Utime.Sec) + float64(rusage.Utime.Usec)/1e6
 	sysTime := float64(rusage.Stime.Sec) + float64(rusage.Stime.Usec)/1e6
 	result.Time = userTime + sysTime
 	result.Memory = uint(rusage.Maxrss)
 }
 
+// sandboxProfiles 运行模式下可用的seccomp过滤器配置，与executor.h中的SandboxProfile对应
+var sandboxProfiles = map[string]C.int{
+	"":                          C.PROFILE_STRICT,
+	model.SandboxProfileStrict:  C.PROFILE_STRICT,
+	model.SandboxProfileRuntime: C.PROFILE_RUNTIME,
+}
+
 // ProcessExecutor 执行运行器
 func ProcessExecutor(executor model.Executor) (int, error) {
+	if _, ok := sandboxProfiles[executor.Sandbox.Profile]; !ok {
+		return 0, fmt.Errorf("unknown sandbox profile: %s", executor.Sandbox.Profile)
+	}
 	cExe := ExecutorGo2C(executor)
 	defer C.free(unsafe.Pointer(cExe.Dir))
 	defer C.free(unsafe.Pointer(cExe.Command))
+	defer freeCStringArray(cExe.Env)
 	exitCode := C.Execute(cExe)
 	if int32(exitCode) == 0 {
 		return 0, errors.New("executor error")
@@ -289,8 +393,22 @@ func ProcessExecutor(executor model.Executor) (int, error) {
 	return int(exitCode), nil
 }
 
+// SyscallName 将系统调用号解析为当前架构下的系统调用名
+func SyscallName(nr int) string {
+	name := C.seccomp_syscall_resolve_num_arch(C.SCMP_ARCH_NATIVE, C.int(nr))
+	if name == nil {
+		return fmt.Sprintf("syscall(%d)", nr)
+	}
+	defer C.free(unsafe.Pointer(name))
+	return C.GoString(name)
+}
+
 // ExecutorGo2C 将运行器的go结构体转换为c结构体
 func ExecutorGo2C(executor model.Executor) *C.Executor {
+	reportFd := -1
+	if executor.Report != nil {
+		reportFd = int(executor.Report.Fd())
+	}
 	return &C.Executor{
 		Command: C.CString(executor.Command),
 		Dir:     C.CString(executor.Dir),
@@ -299,14 +417,45 @@ func ExecutorGo2C(executor model.Executor) *C.Executor {
 			CpuTime_max: C.float(executor.Limiter.CpuTime + conf.Conf.Executor.ExtraCPUTime),
 			Memory_cur:  C.int(executor.Limiter.Memory),
 			Memory_max:  C.int(executor.Limiter.Memory),
+			Stack:       C.long(executor.Limiter.Stack),
+			FileSize:    C.long(executor.Limiter.FileSize),
+			Process:     C.int(executor.Limiter.Process),
 		},
 		StdinFd:  C.int(executor.Stdin.Fd()),
 		StdoutFd: C.int(executor.Stdout.Fd()),
 		StderrFd: C.int(executor.Stderr.Fd()),
+		ReportFd: C.int(reportFd),
+		Profile:  sandboxProfiles[executor.Sandbox.Profile],
+		Env:      cStringArray(executor.Sandbox.Env),
 		RunFlag:  C.int(utils.BoolToInt(executor.RunFlag)),
 	}
 }
 
+// cStringArray 将字符串切片转换为以NULL结尾的C字符串数组，需使用freeCStringArray释放
+func cStringArray(strs []string) **C.char {
+	if len(strs) == 0 {
+		return nil
+	}
+	arr := (**C.char)(C.malloc(C.size_t(len(strs)+1) * C.size_t(unsafe.Sizeof((*C.char)(nil)))))
+	items := unsafe.Slice(arr, len(strs)+1)
+	for i, str := range strs {
+		items[i] = C.CString(str)
+	}
+	items[len(strs)] = nil
+	return arr
+}
+
+// freeCStringArray 释放cStringArray创建的数组
+func freeCStringArray(arr **C.char) {
+	if arr == nil {
+		return
+	}
+	for p := arr; *p != nil; p = (**C.char)(unsafe.Add(unsafe.Pointer(p), unsafe.Sizeof(*p))) {
+		C.free(unsafe.Pointer(*p))
+	}
+	C.free(unsafe.Pointer(arr))
+}
+
 func init() {
 	// 初始化过滤器
 	C.InitFilter()
@@ -8,9 +8,13 @@
 #include <signal.h>
 #include <seccomp.h>
 #include <sys/syscall.h>
+#include <linux/sched.h>
 #include <sys/prctl.h>
+#include <sys/ptrace.h>
 #include <fcntl.h>
 #include <dirent.h>
+#include <errno.h>
+#include <string.h>
 
 // Limiter 表示限制条件
 typedef struct
@@ -19,8 +23,19 @@ typedef struct
     float CpuTime_max;
     int Memory_cur; // kb
     int Memory_max;
+    long Stack;    // kb，-1表示不限制，0表示不设置
+    long FileSize; // kb，0表示不限制
+    int Process;   // 进程与线程总数，0表示不限制
 } Limiter;
 
+// SandboxProfile 表示运行模式下的seccomp过滤器配置
+typedef enum
+{
+    PROFILE_STRICT = 0,  // 默认配置，禁止文件状态查询、进程与线程创建及休眠
+    PROFILE_RUNTIME = 1, // 解释器与虚拟机配置，额外允许文件状态查询、线程创建及休眠
+    PROFILE_COUNT
+} SandboxProfile;
+
 // Executor 表示运行器
 typedef struct
 {
@@ -30,6 +45,9 @@ typedef struct
     int StdinFd;
     int StdoutFd;
     int StderrFd;
+    int ReportFd; // 回报违规信息与准备步骤失败原因的描述符，-1表示不回报
+    int Profile;  // 运行模式下使用的SandboxProfile
+    char **Env;   // 以NULL结尾的额外环境变量，格式为KEY=VALUE
     int RunFlag;
 } Executor;
 
@@ -10,7 +10,6 @@ import (
 	"nightcord-server/internal/conf"
 	"nightcord-server/internal/model"
 	"nightcord-server/internal/service/storage"
-	"nightcord-server/utils"
 	"os"
 	"strings"
 	"sync"
@@ -339,6 +338,23 @@ func (jr *JobRunner) handleJob(job *Job) {
 			jr.jobFinish <- struct{}{}
 		}()
 
+		if job.Request.ProblemID != 0 || job.Request.TestcaseType == model.FileTest {
+			syncStorage()
+		}
+		// 指定题目时从存储引擎读取测试点与题目设置
+		if job.Request.ProblemID != 0 {
+			if err := ResolveProblem(&job.Request); err != nil {
+				result.Status = model.StatusIE.GetStatus()
+				result.Message = fmt.Sprintf("Problem resolution failed: %v", err)
+				return
+			}
+		}
+		// 固定文件版本，并在结果中报告实际评测的版本
+		if job.Request.TestcaseType == model.FileTest {
+			PinFileVersions(storage.GetStorageEngineInstance(), job.Request.Testcase)
+			result.Testcases = job.Request.Testcase
+		}
+
 		// 1. 调用 PrepareEnvironmentAndCompile
 		lang, wd, compileRes, err := PrepareEnvironmentAndCompile(job.ctx, job.Request)
 		workDir = wd // 赋值给外层变量以便defer可以清理
@@ -352,12 +368,9 @@ func (jr *JobRunner) handleJob(job *Job) {
 
 		result.Compilation = compileRes
 		if !compileRes.Success {
-			if compileRes.Message != "" {
-				result.Message = compileRes.Message
-				result.Status = model.StatusIE.GetStatus() // 编译错误信息通常表示内部或配置问题
-			} else {
-				result.Status = model.StatusCE.GetStatus() // 标准编译错误
-			}
+			// 执行器内部错误已通过 err 报告，此处均为编译错误
+			result.Status = model.StatusCE.GetStatus()
+			result.Message = compileRes.Message
 			return // 编译失败，提前返回
 		}
 
@@ -376,10 +389,8 @@ func (jr *JobRunner) handleJob(job *Job) {
 		var wg sync.WaitGroup
 		wg.Add(numTestCases)
 
-		var limiter = model.Limiter{
-			CpuTime: job.Request.CpuTimeLimit,
-			Memory:  job.Request.MemoryLimit,
-		}
+		limiter := GetRunLimiter(lang, job.Request)
+		runCmd := GetRunCommand(lang, job.Request, workDir, limiter)
 
 		for i, tc := range job.Request.Testcase {
 			// 为每个测试用例启动一个 goroutine
@@ -400,7 +411,7 @@ func (jr *JobRunner) handleJob(job *Job) {
 					// 读取输入文件
 					var stdinReader io.Reader
 					if currentTestcase.Stdin != "" {
-						inputFile, err := storageEngine.ReadFile(currentTestcase.Stdin)
+						inputFile, err := storageEngine.ReadFileVersion(currentTestcase.Stdin, currentTestcase.StdinVersion)
 						if err != nil {
 							res = model.TestResultWithIndex{
 								Index: index,
@@ -419,7 +430,7 @@ func (jr *JobRunner) handleJob(job *Job) {
 					// 读取期望输出文件
 					var expectedOutputReader io.Reader
 					if currentTestcase.ExpectedOutput != "" {
-						outputFile, err := storageEngine.ReadFile(currentTestcase.ExpectedOutput)
+						outputFile, err := storageEngine.ReadFileVersion(currentTestcase.ExpectedOutput, currentTestcase.ExpectedOutputVersion)
 						if err != nil {
 							res = model.TestResultWithIndex{
 								Index: index,
@@ -441,7 +452,7 @@ func (jr *JobRunner) handleJob(job *Job) {
 					}
 				}
 
-				runExe := GetRunExecutor(lang.RunCmd, limiter, workDir, true, testcase.Stdin)
+				runExe := GetRunExecutor(runCmd, limiter, lang.Sandbox(), workDir, true, testcase.Stdin)
 
 				runJob := NewRunJob(runExe, job.ctx)
 				// runManager 变量从外部作用域捕获
@@ -459,10 +470,14 @@ func (jr *JobRunner) handleJob(job *Job) {
 
 					// 验证输出结果是否符合预期
 					if expectedOutput != "" {
-						if !utils.StringsEqualIgnoreFinalNewline(testCaseResult.Stdout, expectedOutput) {
+						if !CheckOutput(job.Request.Checker, testCaseResult.Stdout, expectedOutput) {
 							testCaseResult.Status = model.StatusWA.GetStatus()
 						}
+					} else if job.Request.Playground {
+						testCaseResult.Status = model.StatusFinished.GetStatus()
 					}
+				} else if testCaseResult.Status.Id == model.StatusAC && job.Request.Playground {
+					testCaseResult.Status = model.StatusFinished.GetStatus()
 				}
 				res = model.TestResultWithIndex{
 					Index:      index,
@@ -474,10 +489,13 @@ func (jr *JobRunner) handleJob(job *Job) {
 		wg.Wait() // 等待所有测试用例的 goroutine 完成
 		close(resultChan)
 
-		// 从通道中收集测试结果
+		// 从通道中收集测试结果，Finished 不参与合并，所有测试点均无错误时整体为 Finished
+		finished := false
 		for res := range resultChan {
 			result.TestResult[res.Index] = res.TestResult
-			if result.Status.Id < res.TestResult.Status.Id {
+			if res.TestResult.Status.Id == model.StatusFinished {
+				finished = true
+			} else if result.Status.Id.Severity() < res.TestResult.Status.Id.Severity() {
 				result.Status = res.TestResult.Status
 			}
 			if result.MaxTime < res.TestResult.Time {
@@ -488,6 +506,10 @@ func (jr *JobRunner) handleJob(job *Job) {
 			}
 			result.Message = res.TestResult.Message
 		}
+		if finished && result.Status.Id == model.StatusAC {
+			result.Status = model.StatusFinished.GetStatus()
+		}
+		result.Subtasks, result.Score = JudgeSubtasks(job.Request.Subtasks, job.Request.Testcase, result.TestResult)
 
 		// workDir 的清理已在 defer 中处理
 		// 结果的发送也已在 defer 中处理
@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"nightcord-server/internal/model"
+	"nightcord-server/internal/service/language"
+	"os"
+	"strings"
+)
+
+// DetectLanguageVersions 在沙箱中执行各语言的 version_cmd，记录检测到的版本信息
+// 检测失败只记录日志，不影响语言的使用
+func DetectLanguageVersions(ctx context.Context) {
+	for _, lang := range language.GetAllLanguages() {
+		if strings.TrimSpace(lang.VersionCmd) == "" {
+			continue
+		}
+		version, err := DetectLanguageVersion(ctx, lang)
+		if err != nil {
+			log.Printf("Failed to detect version of %s: %v", lang.Name, err)
+			continue
+		}
+		language.SetLanguageVersion(lang.ID, version)
+	}
+}
+
+// DetectLanguageVersion 以编译模式的限制执行 version_cmd，返回输出的第一行非空内容
+// 部分编译器（如 javac、java）将版本输出到标准错误，标准输出为空时使用标准错误
+func DetectLanguageVersion(ctx context.Context, lang model.Language) (string, error) {
+	workDir, err := NewWorkDir()
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+
+	runExe := GetRunExecutor(lang.VersionCmd, GetCompileLimiter(lang), lang.Sandbox(), workDir, false)
+	res := GetRunManagerInstance().SubmitRunJob(NewRunJob(runExe, ctx))
+	if res.Status.Id != model.StatusAC {
+		return "", fmt.Errorf("%s: %s", res.Status.Description, res.Message)
+	}
+
+	output := res.Stdout
+	if strings.TrimSpace(output) == "" {
+		output = res.Stderr
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("no version output")
+}
+
+// GetLanguageInfo 汇总语言配置、默认限制与检测到的版本，用于客户端展示
+func GetLanguageInfo(lang model.Language) model.LanguageInfo {
+	timeMultiplier := lang.TimeMultiplier
+	if timeMultiplier <= 0 {
+		timeMultiplier = 1
+	}
+	runLimiter := ResolveLimiter(GetRunLimiter(lang, model.SubmitRequest{}), true)
+	info := model.LanguageInfo{
+		ID:             lang.ID,
+		Name:           lang.Name,
+		SourceFile:     lang.SourceFile,
+		Compiled:       strings.TrimSpace(lang.CompileCmd) != "",
+		Highlight:      lang.Highlight,
+		Version:        language.GetLanguageVersion(lang.ID),
+		TimeMultiplier: timeMultiplier,
+		ExtraMemory:    lang.ExtraMemory,
+		CompileOptions: lang.CompileOptions,
+		DefaultOptions: lang.DefaultOptions,
+		Limits: model.LimitInfo{
+			CpuTime: runLimiter.CpuTime,
+			Memory:  runLimiter.Memory,
+			Stack:   runLimiter.Stack,
+			Process: runLimiter.Process,
+		},
+	}
+	if info.Compiled {
+		compileLimiter := ResolveLimiter(GetCompileLimiter(lang), false)
+		info.CompileLimits = &model.LimitInfo{
+			CpuTime: compileLimiter.CpuTime,
+			Memory:  compileLimiter.Memory,
+			Stack:   compileLimiter.Stack,
+			Process: compileLimiter.Process,
+		}
+	}
+	return info
+}
@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"nightcord-server/internal/conf"
+	"nightcord-server/internal/model"
+)
+
+// GetRunLimiter 根据提交请求与语言配置计算运行限制
+// 未指定的时间与内存限制使用配置中的默认值，随后应用语言的时间倍率与额外内存
+// 自测模式的输出需要完整返回给用户，使用单独的输出长度限制
+func GetRunLimiter(lang model.Language, req model.SubmitRequest) model.Limiter {
+	limiter := model.Limiter{
+		CpuTime: req.CpuTimeLimit,
+		Memory:  req.MemoryLimit,
+		Stack:   req.StackLimit,
+		Process: lang.ProcessLimit,
+	}
+	if req.Playground {
+		limiter.Output = conf.Conf.Executor.PlaygroundOutputLimit
+	}
+	if limiter.CpuTime == 0 {
+		limiter.CpuTime = conf.Conf.Executor.CPUTimeLimit
+	}
+	if limiter.Memory == 0 {
+		limiter.Memory = conf.Conf.Executor.MemoryLimit
+	}
+	if limiter.Stack == 0 {
+		limiter.Stack = lang.StackLimit
+	}
+	if lang.TimeMultiplier > 0 {
+		limiter.CpuTime *= lang.TimeMultiplier
+	}
+	limiter.Memory += lang.ExtraMemory
+	return limiter
+}
+
+// GetCompileLimiter 根据语言配置计算编译限制
+func GetCompileLimiter(lang model.Language) model.Limiter {
+	limiter := model.Limiter{
+		CpuTime:  lang.CompileTimeout,
+		Memory:   lang.CompileMemory,
+		FileSize: conf.Conf.Executor.CompileFileSizeLimit,
+		Process:  conf.Conf.Executor.CompileProcessLimit,
+	}
+	if limiter.CpuTime == 0 {
+		limiter.CpuTime = conf.Conf.Executor.CompileTimeout
+	}
+	if limiter.Memory == 0 {
+		limiter.Memory = uint(conf.Conf.Executor.CompileMemory)
+	}
+	return limiter
+}
+
+// ResolveLimiter 为未指定的限制填入配置中的默认值，并将与内存相同的栈限制展开为具体数值
+// 文件大小与进程数的默认值仅在运行模式下生效，输出长度限制在编译时同样生效
+func ResolveLimiter(limiter model.Limiter, runFlag bool) model.Limiter {
+	if limiter.CpuTime == 0 {
+		limiter.CpuTime = conf.Conf.Executor.CPUTimeLimit
+	}
+	if limiter.Memory == 0 {
+		limiter.Memory = conf.Conf.Executor.MemoryLimit
+	}
+	if limiter.Stack == 0 {
+		limiter.Stack = conf.Conf.Executor.StackLimit
+	}
+	if limiter.Stack == model.StackLimitMemory {
+		limiter.Stack = int(limiter.Memory)
+	}
+	if limiter.FileSize == 0 && runFlag {
+		limiter.FileSize = conf.Conf.Executor.FileSizeLimit
+	}
+	if limiter.Process == 0 && runFlag {
+		limiter.Process = conf.Conf.Executor.ProcessLimit
+	}
+	if limiter.Output == 0 {
+		limiter.Output = conf.Conf.Executor.OutputLimit
+	}
+	return limiter
+}
@@ -0,0 +1,120 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"log"
+
+	"nightcord-server/internal/model"
+	"nightcord-server/internal/service/storage"
+	"nightcord-server/utils"
+)
+
+// syncStorage 同步其他节点写入的文件与题目，刚在其他节点上传的文件或创建的题目也能评测
+// 同步失败时使用本地已有的元数据继续评测
+func syncStorage() {
+	if err := storage.GetStorageEngineInstance().Sync(); err != nil {
+		log.Printf("Failed to sync storage metadata: %v", err)
+	}
+}
+
+// ResolveProblem 从存储引擎读取提交指定的题目，将其测试点写入请求
+// 请求中未指定的限制与比较方式使用题目的设置，子任务与评测程序总是使用题目的设置
+func ResolveProblem(req *model.SubmitRequest) error {
+	problem, err := storage.GetStorageEngineInstance().GetProblem(req.ProblemID)
+	if err != nil {
+		return err
+	}
+	// 请求中的测试点只用于固定文件版本，保证重新评测的结果可复现
+	pinned := req.Testcase
+	req.TestcaseType = model.FileTest
+	req.Testcase = make([]model.TestcaseReq, len(problem.Testcases))
+	for i, tc := range problem.Testcases {
+		req.Testcase[i] = model.TestcaseReq{
+			Stdin:          tc.Input,
+			ExpectedOutput: tc.Output,
+			Subtask:        tc.Subtask,
+		}
+		if i < len(pinned) {
+			req.Testcase[i].StdinVersion = pinned[i].StdinVersion
+			req.Testcase[i].ExpectedOutputVersion = pinned[i].ExpectedOutputVersion
+		}
+	}
+	req.Subtasks = problem.Subtasks
+	if req.CpuTimeLimit == 0 {
+		req.CpuTimeLimit = problem.CpuTimeLimit
+	}
+	if req.MemoryLimit == 0 {
+		req.MemoryLimit = problem.MemoryLimit
+	}
+	if req.StackLimit == 0 {
+		req.StackLimit = problem.StackLimit
+	}
+	if req.Checker == model.CheckerDefault {
+		req.Checker = problem.Checker
+	}
+	// 评测程序总是使用题目的设置，提交中的评测程序被忽略，避免选手替换main或读取其他文件
+	req.Graders = problem.Graders
+	return nil
+}
+
+// PinFileVersions 将FileTest测试点中未指定的文件版本固定为当前版本，评测期间文件被覆盖时仍读取同一版本
+// 读取元数据失败的文件保持为0，评测该测试点时再报告错误
+func PinFileVersions(se *storage.StorageEngine, testcases []model.TestcaseReq) {
+	pin := func(filename string, version *int64) {
+		if filename == "" || *version != 0 {
+			return
+		}
+		if metadata, err := se.GetFileMetadata(filename); err == nil {
+			*version = metadata.Version
+		}
+	}
+	for i := range testcases {
+		pin(testcases[i].Stdin, &testcases[i].StdinVersion)
+		pin(testcases[i].ExpectedOutput, &testcases[i].ExpectedOutputVersion)
+	}
+}
+
+// CheckOutput 按比较方式判断程序输出是否与期望输出一致
+func CheckOutput(checker, output, expected string) bool {
+	switch checker {
+	case model.CheckerExact:
+		return output == expected
+	case model.CheckerTokens:
+		return utils.TokensEqual(output, expected)
+	default:
+		return utils.StringsEqualIgnoreFinalNewline(output, expected)
+	}
+}
+
+// JudgeSubtasks 根据测试点结果计算各子任务的状态与得分
+// 子任务的状态为其中最严重的测试点状态（见 model.StatusId.Severity），全部通过时获得子任务的分数
+func JudgeSubtasks(subtasks []model.Subtask, testcases []model.TestcaseReq, results []model.TestResult) ([]model.SubtaskResult, float64) {
+	if len(subtasks) == 0 {
+		return nil, 0
+	}
+	subtaskResults := make([]model.SubtaskResult, len(subtasks))
+	indexes := make(map[int]int, len(subtasks))
+	for i, subtask := range subtasks {
+		subtaskResults[i] = model.SubtaskResult{ID: subtask.ID, Status: model.StatusAC.GetStatus()}
+		indexes[subtask.ID] = i
+	}
+	for i, tc := range testcases {
+		idx, ok := indexes[tc.Subtask]
+		if !ok || i >= len(results) {
+			continue
+		}
+		if subtaskResults[idx].Status.Id.Severity() < results[i].Status.Id.Severity() {
+			subtaskResults[idx].Status = results[i].Status
+		}
+	}
+	var score float64
+	for i, subtask := range subtasks {
+		if subtaskResults[i].Status.Id == model.StatusAC {
+			subtaskResults[i].Score = subtask.Score
+			score += subtask.Score
+		}
+	}
+	return subtaskResults, score
+}
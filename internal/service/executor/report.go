@@ -0,0 +1,60 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"fmt"
+	"math"
+	"nightcord-server/internal/model"
+	"strconv"
+	"strings"
+)
+
+// ParseReport 解析监控进程回报的信息，格式为"syscall <系统调用号>"、"nproc <限制值>"或"setup <失败原因>"
+// syscallName 将系统调用号解析为系统调用名，运行时为 SyscallName
+func ParseReport(report string, result *model.ExecutorResult, syscallName func(int) string) {
+	kind, value, ok := strings.Cut(report, " ")
+	if !ok {
+		return
+	}
+	switch kind {
+	case "syscall":
+		if nr, err := strconv.Atoi(value); err == nil {
+			result.IllegalSyscall = syscallName(nr)
+		}
+	case "nproc":
+		result.ProcessExceeded = true
+	case "setup":
+		result.SetupError = value
+	}
+}
+
+// RunStatus 根据运行结果、回报的违规信息与限制判断执行状态，返回状态与附加信息
+// quotaExceeded 表示工作目录超出配额，runFlag 为false时非0退出码视为编译错误
+func RunStatus(exeRes model.ExecutorResult, limiter model.Limiter, runFlag bool, quotaExceeded bool) (model.Status, string) {
+	switch {
+	case exeRes.SetupError != "":
+		return model.StatusIE.GetStatus(), fmt.Sprintf("executor setup failed: %s", exeRes.SetupError)
+	case exeRes.ExitCode == -1:
+		return model.StatusIE.GetStatus(), "context canceled"
+	case exeRes.IllegalSyscall != "":
+		return model.StatusRESIGSYS.GetStatus(), fmt.Sprintf("Illegal syscall: %s", exeRes.IllegalSyscall)
+	case exeRes.ProcessExceeded:
+		return model.StatusPLE.GetStatus(), fmt.Sprintf("Process limit exceeded: %d", limiter.Process)
+	case quotaExceeded:
+		return model.StatusRESIGXFSZ.GetStatus(), "Work directory quota exceeded"
+	case math.Round(exeRes.Time*1000)/1000 >= limiter.CpuTime:
+		return model.StatusTLE.GetStatus(), ""
+	case exeRes.Memory > limiter.Memory*1024:
+		return model.StatusRESIGSEGV.GetStatus(), ""
+	case exeRes.Signal != 0:
+		return SignalStatus(exeRes.Signal).GetStatus(), SignalMessage(exeRes.Signal)
+	case !runFlag && exeRes.ExitCode != 0:
+		return model.StatusCE.GetStatus(), fmt.Sprintf("Compiler exited with code %d", exeRes.ExitCode)
+	case exeRes.ExitCode != 0:
+		return model.StatusRENZEC.GetStatus(), fmt.Sprintf("Exited with code %d", exeRes.ExitCode)
+	default:
+		return model.StatusAC.GetStatus(), ""
+	}
+}
@@ -20,6 +20,8 @@ func SignalStatus(s syscall.Signal) model.StatusId {
 		return model.StatusRESIGABRT
 	case syscall.SIGXCPU:
 		return model.StatusTLE
+	case syscall.SIGSYS:
+		return model.StatusRESIGSYS
 	default:
 		return model.StatusRE
 	}
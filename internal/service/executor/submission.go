@@ -0,0 +1,159 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"nightcord-server/internal/conf"
+	"nightcord-server/internal/model"
+	"nightcord-server/internal/service/storage"
+	"nightcord-server/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WriteSubmission 将提交的源码、额外文件与压缩包写入工作目录
+// 所有路径均限制在工作目录内，写入完成后语言的source_file必须存在
+func WriteSubmission(workDir string, lang model.Language, req model.SubmitRequest) error {
+	limit := utils.ArchiveLimit{
+		MaxFiles: conf.Conf.Executor.SubmissionFileLimit,
+		MaxSize:  int64(conf.Conf.Executor.SubmissionSizeLimit) * 1024,
+	}
+
+	var files []utils.ArchiveFile
+	if req.Archive != "" {
+		data, err := base64.StdEncoding.DecodeString(req.Archive)
+		if err != nil {
+			return fmt.Errorf("invalid archive encoding: %v", err)
+		}
+		files, err = utils.ReadArchive(data, limit)
+		if err != nil {
+			return fmt.Errorf("invalid archive: %v", err)
+		}
+	}
+	// 按路径排序保证写入顺序确定，files中的文件覆盖压缩包中的同名文件
+	names := make([]string, 0, len(req.Files))
+	for name := range req.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		clean, err := utils.CleanRelativePath(name)
+		if err != nil {
+			return err
+		}
+		files = append(files, utils.ArchiveFile{Name: clean, Data: []byte(req.Files[name])})
+	}
+	// source_code 最后写入，优先级最高；仅提交单个源文件时即使为空也写入
+	if req.SourceCode != "" || (len(req.Files) == 0 && req.Archive == "") {
+		files = append(files, utils.ArchiveFile{Name: lang.SourceFile, Data: []byte(req.SourceCode)})
+	}
+
+	var size int64
+	for _, f := range files {
+		size += int64(len(f.Data))
+	}
+	if limit.MaxFiles > 0 && len(files) > limit.MaxFiles {
+		return fmt.Errorf("submission contains more than %d files", limit.MaxFiles)
+	}
+	if limit.MaxSize > 0 && size > limit.MaxSize {
+		return fmt.Errorf("submission exceeds %d bytes", limit.MaxSize)
+	}
+
+	for _, f := range files {
+		path := filepath.Join(workDir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, f.Data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(workDir, lang.SourceFile)); err != nil {
+		return fmt.Errorf("source file %s not found in submission", lang.SourceFile)
+	}
+	return nil
+}
+
+// SelectGraders 返回提交语言对应的评测程序文件，路径经过规范化
+// 提交指定了评测程序但不包含该语言时返回错误
+// 指定题目的提交的评测程序由 ResolveProblem 替换为题目的设置；未指定题目时只能使用 GraderDir 下的文件
+func SelectGraders(lang model.Language, req model.SubmitRequest) ([]model.GraderFile, error) {
+	if len(req.Graders) == 0 {
+		return nil, nil
+	}
+	graders, ok := req.Graders[lang.ID]
+	if !ok || len(graders) == 0 {
+		return nil, fmt.Errorf("no grader provided for %s", lang.Name)
+	}
+	cleaned := make([]model.GraderFile, len(graders))
+	for i, grader := range graders {
+		path, err := utils.CleanRelativePath(grader.Path)
+		if err != nil {
+			return nil, err
+		}
+		file, err := storage.CleanFilename(grader.File)
+		if err != nil {
+			return nil, err
+		}
+		if req.ProblemID == 0 && !inGraderDir(file) {
+			return nil, fmt.Errorf("grader %s is not in %s", grader.File, conf.Conf.Executor.GraderDir)
+		}
+		cleaned[i] = model.GraderFile{Path: path, File: file}
+	}
+	return cleaned, nil
+}
+
+// inGraderDir 判断存储引擎中的文件是否位于 GraderDir 下
+func inGraderDir(file string) bool {
+	dir, err := storage.CleanFilename(conf.Conf.Executor.GraderDir)
+	return err == nil && strings.HasPrefix(file, dir+"/")
+}
+
+// GraderPaths 返回评测程序文件的相对路径，用于展开{graders}占位符
+func GraderPaths(graders []model.GraderFile) []string {
+	paths := make([]string, len(graders))
+	for i, grader := range graders {
+		paths[i] = grader.Path
+	}
+	return paths
+}
+
+// WriteGraders 从存储引擎读取评测程序文件并放置到工作目录
+// 在提交写入之后调用，覆盖提交中的同名文件，避免选手替换评测程序
+func WriteGraders(workDir string, graders []model.GraderFile) error {
+	storageEngine := storage.GetStorageEngineInstance()
+	for _, grader := range graders {
+		if err := writeGrader(storageEngine, workDir, grader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGrader(storageEngine *storage.StorageEngine, workDir string, grader model.GraderFile) error {
+	src, err := storageEngine.ReadFile(grader.File)
+	if err != nil {
+		return fmt.Errorf("failed to read grader %s: %v", grader.File, err)
+	}
+	defer src.Close()
+	path := filepath.Join(workDir, filepath.FromSlash(grader.Path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -6,7 +6,8 @@ import (
 
 // SubmitJob 提交评测任务到消息队列，并阻塞等待执行结果返回
 func SubmitJob(req model.SubmitRequest) model.JudgeResult {
-	if req.TestcaseType == model.SingleTest {
+	// 自测模式未提供测试点时，使用Stdin与ExpectedOutput作为单个测试点；指定题目时由JobRunner读取题目的测试点
+	if req.ProblemID == 0 && (req.TestcaseType == model.SingleTest || (req.Playground && len(req.Testcase) == 0)) {
 		req.Testcase = []model.TestcaseReq{
 			{
 				Stdin:          req.Stdin,
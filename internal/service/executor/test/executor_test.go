@@ -4,10 +4,14 @@
 package executor_test
 
 import (
+	"errors"
 	"nightcord-server/internal/conf"
 	"nightcord-server/internal/model"
 	"nightcord-server/internal/service/executor"
+	"nightcord-server/internal/service/storage"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -88,3 +92,292 @@ func TestExecutor(t *testing.T) {
 	t.Logf("%+v", res)
 
 }
+
+func TestExpandCommand(t *testing.T) {
+	vars := executor.CommandVars{
+		Source:   "main.cpp",
+		Binary:   "main",
+		MemoryMB: 256,
+		WorkDir:  "/tmp/work dir",
+		Args:     []string{"-O2", "-std=c++20"},
+	}
+	got := executor.ExpandCommand("g++ {source} -o {binary} {args} -C {workdir} -Xmx{memory_mb}m", vars)
+	want := "g++ main.cpp -o main -O2 -std=c++20 -C '/tmp/work dir' -Xmx256m"
+	if got != want {
+		t.Errorf("ExpandCommand() = %q, want %q", got, want)
+	}
+
+	// 旧配置中的%s按{args}处理
+	if got := executor.ExpandCommand("gcc main.c -o main%s", executor.CommandVars{}); got != "gcc main.c -o main" {
+		t.Errorf("ExpandCommand() with legacy placeholder = %q", got)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	output := "main.c: In function 'main':\n" +
+		"main.c:3:5: error: 'x' undeclared (first use in this function)\n" +
+		"main.c:4:1: warning: control reaches end of non-void function [-Wreturn-type]\n" +
+		"Main.java:3: error: ';' expected\n" +
+		"        int x = 1\n" +
+		"                 ^\n" +
+		"1 error\n"
+	got := executor.ParseDiagnostics(output, ".")
+	want := []model.Diagnostic{
+		{File: "main.c", Line: 3, Column: 5, Severity: model.DiagnosticError, Message: "'x' undeclared (first use in this function)"},
+		{File: "main.c", Line: 4, Column: 1, Severity: model.DiagnosticWarning, Message: "control reaches end of non-void function [-Wreturn-type]"},
+		{File: "Main.java", Line: 3, Column: 18, Severity: model.DiagnosticError, Message: "';' expected"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d diagnostics, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("diagnostics[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCompileCache(t *testing.T) {
+	cache, err := executor.NewCompileCache(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang := model.Language{ID: 1, Name: "C", SourceFile: "main.c", CompileCmd: "gcc {source} -o {binary}"}
+	limiter := model.Limiter{CpuTime: 10, Memory: 262144}
+	key := func(workDir string, command string, limiter model.Limiter) string {
+		key, _, err := cache.Key(lang, command, limiter, workDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	compile := func(source string, binary string) (string, string) {
+		workDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(workDir, "main.c"), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// 工作目录路径不影响缓存键
+		key, inputs, err := cache.Key(lang, "gcc main.c -o main -I"+workDir, limiter, workDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binary != "" {
+			if err := os.WriteFile(filepath.Join(workDir, "main"), []byte(binary), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := cache.Put(key, workDir, inputs, model.CompilationResult{Success: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return key, workDir
+	}
+
+	cached, _ := compile("int main(){}", "binary-a")
+	other, workDir := compile("int main(){}", "")
+	if cached != other {
+		t.Fatalf("Same source produced different keys: %s, %s", cached, other)
+	}
+
+	// 展开后的编译命令（如 {memory_mb}）或编译限制不同时不能复用编译产物
+	base := key(workDir, "gcc main.c -o main", limiter)
+	if key(workDir, "gcc main.c -o main -DMEMORY_MB=512", limiter) == base {
+		t.Errorf("Different compile commands produced the same key")
+	}
+	if key(workDir, "gcc main.c -o main", model.Limiter{CpuTime: 10, Memory: 524288}) == base {
+		t.Errorf("Different compile limits produced the same key")
+	}
+
+	res, ok := cache.Get(cached, workDir)
+	if !ok || !res.Success {
+		t.Fatalf("Expected cache hit, got %v, %+v", ok, res)
+	}
+	if data, err := os.ReadFile(filepath.Join(workDir, "main")); err != nil || string(data) != "binary-a" {
+		t.Errorf("Cached artifact was not restored: %q, %v", data, err)
+	}
+
+	// 写入超出容量的新条目后，最久未使用的条目应被淘汰
+	compile("int main(){return 1;}", strings.Repeat("b", 900))
+	if _, ok := cache.Get(cached, t.TempDir()); ok {
+		t.Errorf("Expected least recently used entry to be evicted")
+	}
+}
+
+func TestJudgeSubtasks(t *testing.T) {
+	subtasks := []model.Subtask{{ID: 1, Score: 30}, {ID: 2, Score: 70}}
+	testcases := []model.TestcaseReq{{Subtask: 1}, {Subtask: 1}, {Subtask: 2}, {}}
+	results := []model.TestResult{
+		{Status: model.StatusAC.GetStatus()},
+		{Status: model.StatusAC.GetStatus()},
+		{Status: model.StatusWA.GetStatus()},
+		{Status: model.StatusTLE.GetStatus()},
+	}
+	got, score := executor.JudgeSubtasks(subtasks, testcases, results)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 subtask results, got %+v", got)
+	}
+	if got[0].Status.Id != model.StatusAC || got[0].Score != 30 {
+		t.Errorf("subtask 1 = %+v, want AC with score 30", got[0])
+	}
+	if got[1].Status.Id != model.StatusWA || got[1].Score != 0 {
+		t.Errorf("subtask 2 = %+v, want WA with score 0", got[1])
+	}
+	if score != 30 {
+		t.Errorf("Expected total score 30, got %v", score)
+	}
+}
+
+func TestJudgeSubtasksSeverity(t *testing.T) {
+	// 状态ID大于内部错误的状态不应覆盖内部错误，Finished 不应覆盖任何错误
+	tests := []struct {
+		statuses []model.StatusId
+		want     model.StatusId
+	}{
+		{[]model.StatusId{model.StatusIE, model.StatusPLE}, model.StatusIE},
+		{[]model.StatusId{model.StatusRESIGSYS, model.StatusIE}, model.StatusIE},
+		{[]model.StatusId{model.StatusIE, model.StatusFinished}, model.StatusIE},
+		{[]model.StatusId{model.StatusFinished, model.StatusWA}, model.StatusWA},
+		{[]model.StatusId{model.StatusPLE, model.StatusRESIGSYS}, model.StatusRESIGSYS},
+		{[]model.StatusId{model.StatusAC, model.StatusFinished}, model.StatusFinished},
+	}
+	subtasks := []model.Subtask{{ID: 1, Score: 100}}
+	for _, tt := range tests {
+		testcases := make([]model.TestcaseReq, len(tt.statuses))
+		results := make([]model.TestResult, len(tt.statuses))
+		for i, status := range tt.statuses {
+			testcases[i].Subtask = 1
+			results[i].Status = status.GetStatus()
+		}
+		got, score := executor.JudgeSubtasks(subtasks, testcases, results)
+		if got[0].Status.Id != tt.want || score != 0 {
+			t.Errorf("JudgeSubtasks(%v) = %v with score %v, want %v with score 0", tt.statuses, got[0].Status.Id, score, tt.want)
+		}
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	if !executor.CheckOutput(model.CheckerDefault, "1 2\n", "1 2") {
+		t.Errorf("Default checker should ignore the final newline")
+	}
+	if executor.CheckOutput(model.CheckerExact, "1 2\n", "1 2") {
+		t.Errorf("Exact checker should not ignore the final newline")
+	}
+	if !executor.CheckOutput(model.CheckerTokens, "1  2\n3", "1 2 3\n") {
+		t.Errorf("Tokens checker should ignore whitespace")
+	}
+}
+
+func TestRequestValidate(t *testing.T) {
+	for _, stack := range []int{0, 8192, model.StackLimitUnlimited, model.StackLimitMemory} {
+		req := model.SubmitRequest{StackLimit: stack}
+		if err := req.Validate(); err != nil {
+			t.Errorf("stack_limit %d: unexpected error %v", stack, err)
+		}
+	}
+	// 小于-2的值会被执行器当作不限制
+	if err := (&model.SubmitRequest{StackLimit: -3}).Validate(); !errors.Is(err, model.ErrInvalidLimit) {
+		t.Errorf("Expected ErrInvalidLimit for stack_limit -3, got %v", err)
+	}
+	if err := (&model.RunRequest{StackLimit: -100}).Validate(); !errors.Is(err, model.ErrInvalidLimit) {
+		t.Errorf("Expected ErrInvalidLimit for stack_limit -100, got %v", err)
+	}
+	if err := (&model.RunRequest{CpuTimeLimit: -1}).Validate(); !errors.Is(err, model.ErrInvalidLimit) {
+		t.Errorf("Expected ErrInvalidLimit for negative cpu_time_limit, got %v", err)
+	}
+}
+
+func TestPinFileVersions(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := storage.NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer se.Close()
+	for _, content := range []string{"1", "2"} {
+		if err := se.WriteFile("1.in", []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := se.WriteFile("1.out", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []model.TestcaseReq{
+		{Stdin: "1.in", ExpectedOutput: "1.out"},
+		{Stdin: "1.in", StdinVersion: 1},
+		{Stdin: "missing.in"},
+	}
+	executor.PinFileVersions(se, testcases)
+	want := []model.TestcaseReq{
+		{Stdin: "1.in", ExpectedOutput: "1.out", StdinVersion: 2, ExpectedOutputVersion: 1},
+		{Stdin: "1.in", StdinVersion: 1},
+		{Stdin: "missing.in"},
+	}
+	for i := range want {
+		if testcases[i] != want[i] {
+			t.Errorf("testcases[%d] = %+v, want %+v", i, testcases[i], want[i])
+		}
+	}
+}
+
+func TestGradersFromProblem(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := storage.InitStorageEngine(&storage.Config{
+		StoreDir: filepath.Join(tempDir, "files"),
+		DBPath:   filepath.Join(tempDir, "test.db"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.CloseStorageEngine() })
+	se := storage.GetStorageEngineInstance()
+	for name, content := range map[string]string{
+		"p1/grader.c":    "int main(){}",
+		"p1/1.in":        "1",
+		"p1/1.out":       "1",
+		"graders/stub.c": "int main(){}",
+	} {
+		if err := se.WriteFile(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	problemGraders := map[int][]model.GraderFile{1: {{Path: "grader.c", File: "p1/grader.c"}}}
+	problem := &storage.Problem{
+		Title:     "A+B",
+		Graders:   problemGraders,
+		Testcases: []storage.ProblemTestcase{{Input: "p1/1.in", Output: "p1/1.out"}},
+	}
+	if err := se.CreateProblem(problem); err != nil {
+		t.Fatal(err)
+	}
+
+	// 提交中的评测程序不能替换题目的评测程序，也不能借此把其他文件复制到工作目录
+	req := model.SubmitRequest{
+		LanguageID: 1,
+		ProblemID:  problem.ID,
+		Graders:    map[int][]model.GraderFile{1: {{Path: "grader.c", File: "p1/1.out"}}},
+	}
+	if err := executor.ResolveProblem(&req); err != nil {
+		t.Fatal(err)
+	}
+	graders, err := executor.SelectGraders(model.Language{ID: 1, Name: "C"}, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graders) != 1 || graders[0] != problemGraders[1][0] {
+		t.Errorf("Expected the problem's grader, got %+v", graders)
+	}
+
+	// 未指定题目的提交只能使用评测程序目录下的文件
+	conf.Conf.Executor.GraderDir = "graders"
+	lang := model.Language{ID: 1, Name: "C"}
+	adhoc := model.SubmitRequest{LanguageID: 1, Graders: map[int][]model.GraderFile{1: {{Path: "stub.c", File: "graders/stub.c"}}}}
+	if _, err := executor.SelectGraders(lang, adhoc); err != nil {
+		t.Errorf("Grader in the grader directory was rejected: %v", err)
+	}
+	for _, file := range []string{"p1/1.out", "graders/../p1/1.out", "graders"} {
+		adhoc.Graders[1][0].File = file
+		if _, err := executor.SelectGraders(lang, adhoc); err == nil {
+			t.Errorf("Grader %q outside the grader directory was accepted", file)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+//go:build linux
+// +build linux
+
+package executor_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"nightcord-server/internal/conf"
+	"nightcord-server/internal/model"
+	"nightcord-server/internal/service/executor"
+	"nightcord-server/internal/service/language"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseReport(t *testing.T) {
+	syscallName := func(nr int) string { return fmt.Sprintf("nr%d", nr) }
+	tests := []struct {
+		report string
+		want   model.ExecutorResult
+	}{
+		{"", model.ExecutorResult{}},
+		{"syscall 57", model.ExecutorResult{IllegalSyscall: "nr57"}},
+		{"syscall x", model.ExecutorResult{}},
+		{"nproc 8", model.ExecutorResult{ProcessExceeded: true}},
+		{"setup setrlimit(RLIMIT_STACK)", model.ExecutorResult{SetupError: "setrlimit(RLIMIT_STACK)"}},
+		{"unknown 1", model.ExecutorResult{}},
+	}
+	for _, tt := range tests {
+		var got model.ExecutorResult
+		executor.ParseReport(tt.report, &got, syscallName)
+		if got != tt.want {
+			t.Errorf("ParseReport(%q) = %+v, want %+v", tt.report, got, tt.want)
+		}
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	limiter := model.Limiter{CpuTime: 1, Memory: 1024, Process: 8}
+	tests := []struct {
+		name   string
+		report string
+		res    model.ExecutorResult
+		quota  bool
+		want   model.StatusId
+	}{
+		{"accepted", "", model.ExecutorResult{}, false, model.StatusAC},
+		{"illegal syscall", "syscall 57", model.ExecutorResult{ExitCode: 0}, false, model.StatusRESIGSYS},
+		{"process limit", "nproc 8", model.ExecutorResult{}, false, model.StatusPLE},
+		{"setup failure", "setup setrlimit(RLIMIT_FSIZE)", model.ExecutorResult{}, false, model.StatusIE},
+		{"quota", "", model.ExecutorResult{}, true, model.StatusRESIGXFSZ},
+		{"file size", "", model.ExecutorResult{Signal: syscall.SIGXFSZ}, false, model.StatusRESIGXFSZ},
+		{"nzec", "", model.ExecutorResult{ExitCode: 3}, false, model.StatusRENZEC},
+		{"tle", "", model.ExecutorResult{Time: 1}, false, model.StatusTLE},
+	}
+	for _, tt := range tests {
+		res := tt.res
+		executor.ParseReport(tt.report, &res, func(nr int) string { return fmt.Sprintf("nr%d", nr) })
+		status, message := executor.RunStatus(res, limiter, true, tt.quota)
+		if status.Id != tt.want {
+			t.Errorf("%s: RunStatus() = %v (%s), want %v", tt.name, status.Id, message, tt.want)
+		}
+	}
+	// 违规信息优先于退出码
+	status, _ := executor.RunStatus(model.ExecutorResult{IllegalSyscall: "fork", ExitCode: 1}, limiter, true, false)
+	if status.Id != model.StatusRESIGSYS {
+		t.Errorf("Expected illegal syscall to take precedence, got %v", status.Id)
+	}
+}
+
+// sandboxProgram 编译C程序，返回工作目录；没有gcc或沙箱不可用（缺少libseccomp、无法ptrace等）时跳过
+func sandboxProgram(t *testing.T, source string, gccArgs ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	conf.Conf.Executor.Default()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.c"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := append([]string{"-O0", "-o", filepath.Join(dir, "main"), filepath.Join(dir, "main.c")}, gccArgs...)
+	if out, err := exec.Command("gcc", args...).CombinedOutput(); err != nil {
+		t.Fatalf("gcc failed: %v\n%s", err, out)
+	}
+
+	probe := executor.GetRunExecutor("/bin/true", model.Limiter{CpuTime: 1, Memory: 65536}, model.Sandbox{}, dir, true)
+	if res := probe(context.Background()); res.Status.Id != model.StatusAC {
+		t.Skipf("sandbox unavailable: %s %s", res.Status.Description, res.Message)
+	}
+	return dir
+}
+
+// runSandboxed 在沙箱中运行编译好的程序
+func runSandboxed(dir string, limiter model.Limiter, profile string) model.RunResult {
+	runExe := executor.GetRunExecutor("./main", limiter, model.Sandbox{Profile: profile}, dir, true)
+	return runExe(context.Background())
+}
+
+func TestSandboxIllegalSyscall(t *testing.T) {
+	dir := sandboxProgram(t, `#include <unistd.h>
+int main(void) {
+	for (;;) fork();
+}
+`)
+	res := runSandboxed(dir, model.Limiter{CpuTime: 2, Memory: 65536}, model.SandboxProfileStrict)
+	if res.Status.Id != model.StatusRESIGSYS || !strings.HasPrefix(res.Message, "Illegal syscall: ") {
+		t.Fatalf("Expected illegal syscall, got %+v", res)
+	}
+}
+
+func TestSandboxProcessLimit(t *testing.T) {
+	dir := sandboxProgram(t, `#include <pthread.h>
+#include <unistd.h>
+static void *idle(void *arg) {
+	sleep(10);
+	return arg;
+}
+int main(void) {
+	// 线程栈默认与栈限制相同，缩小后内存限制不会先于进程数限制触发
+	pthread_attr_t attr;
+	pthread_attr_init(&attr);
+	pthread_attr_setstacksize(&attr, 65536);
+	for (int i = 0; i < 64; i++) {
+		pthread_t tid;
+		if (pthread_create(&tid, &attr, idle, NULL) != 0) return 1;
+	}
+	sleep(10);
+	return 0;
+}
+`, "-pthread")
+	res := runSandboxed(dir, model.Limiter{CpuTime: 2, Memory: 262144, Process: 4}, model.SandboxProfileRuntime)
+	if res.Status.Id != model.StatusPLE {
+		t.Fatalf("Expected process limit exceeded, got %+v", res)
+	}
+}
+
+func TestSandboxStackLimit(t *testing.T) {
+	// 递归约使用64MB栈空间
+	dir := sandboxProgram(t, `#include <string.h>
+static int recurse(int depth) {
+	volatile char buf[1024];
+	memset((char *)buf, depth, sizeof(buf));
+	return depth == 0 ? buf[0] : recurse(depth - 1) + buf[1];
+}
+int main(void) {
+	return recurse(64 * 1024) == 0 ? 2 : 0;
+}
+`)
+	tests := []struct {
+		stack int
+		want  model.StatusId
+	}{
+		{8192, model.StatusRESIGSEGV},
+		{model.StackLimitUnlimited, model.StatusAC},
+		{model.StackLimitMemory, model.StatusAC},
+		{131072, model.StatusAC},
+	}
+	for _, tt := range tests {
+		res := runSandboxed(dir, model.Limiter{CpuTime: 2, Memory: 262144, Stack: tt.stack}, model.SandboxProfileStrict)
+		if res.Status.Id != tt.want {
+			t.Errorf("stack %d: got %+v, want %v", tt.stack, res, tt.want)
+		}
+	}
+}
+
+func TestSandboxFileSizeLimit(t *testing.T) {
+	// 向工作目录写入2MB
+	dir := sandboxProgram(t, `#include <stdio.h>
+#include <string.h>
+int main(void) {
+	static char buf[1024];
+	memset(buf, 'x', sizeof(buf));
+	FILE *f = fopen("out.txt", "w");
+	if (f == NULL) return 1;
+	for (int i = 0; i < 2048; i++) {
+		if (fwrite(buf, 1, sizeof(buf), f) != sizeof(buf)) return 1;
+	}
+	return fclose(f) == 0 ? 0 : 1;
+}
+`)
+	res := runSandboxed(dir, model.Limiter{CpuTime: 2, Memory: 65536, FileSize: 1024}, model.SandboxProfileStrict)
+	if res.Status.Id != model.StatusRESIGXFSZ {
+		t.Errorf("Expected file size limit exceeded, got %+v", res)
+	}
+
+	// 工作目录配额限制单个文件不超过剩余配额
+	os.Remove(filepath.Join(dir, "out.txt"))
+	conf.Conf.Executor.WorkDirQuota = 1024
+	defer func() { conf.Conf.Executor.WorkDirQuota = 0 }()
+	res = runSandboxed(dir, model.Limiter{CpuTime: 2, Memory: 65536}, model.SandboxProfileStrict)
+	if res.Status.Id != model.StatusRESIGXFSZ {
+		t.Errorf("Expected work directory quota exceeded, got %+v", res)
+	}
+}
+
+func TestRunLimiterOutput(t *testing.T) {
+	conf.Conf.Executor.Default()
+	req := model.SubmitRequest{}
+	limiter := executor.ResolveLimiter(executor.GetRunLimiter(model.Language{}, req), true)
+	if limiter.Output != conf.Conf.Executor.OutputLimit {
+		t.Errorf("Judge run output limit = %d, want %d", limiter.Output, conf.Conf.Executor.OutputLimit)
+	}
+	req.Playground = true
+	limiter = executor.ResolveLimiter(executor.GetRunLimiter(model.Language{}, req), true)
+	if limiter.Output != conf.Conf.Executor.PlaygroundOutputLimit {
+		t.Errorf("Playground output limit = %d, want %d", limiter.Output, conf.Conf.Executor.PlaygroundOutputLimit)
+	}
+}
+
+// blockingReader 第一次读取时通知started，然后阻塞到release关闭
+type blockingReader struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.started:
+	default:
+		close(r.started)
+	}
+	<-r.release
+	return 0, io.EOF
+}
+
+func TestArtifactStoreFull(t *testing.T) {
+	t.Chdir(t.TempDir())
+	conf.Conf.Executor.Default()
+	langs := `[{"id": 1, "name": "Shell", "source_file": "main.sh", "run_cmd": "sh main.sh", "sandbox_profile": "runtime"}]`
+	if err := os.WriteFile("lang.json", []byte(langs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := language.ReloadLanguages(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := executor.NewArtifactStore(time.Minute, 1)
+	req := model.SubmitRequest{LanguageID: 1, SourceCode: "cat"}
+	resp, err := store.Compile(context.Background(), req)
+	if err != nil || resp.Handle == "" {
+		t.Fatalf("Compile() = %+v, %v", resp, err)
+	}
+
+	// 唯一的编译产物正在运行时不能淘汰，编译应返回错误而不是超出上限
+	stdin := &blockingReader{started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan model.RunResult, 1)
+	go func() {
+		res, _ := store.RunStream(context.Background(), model.RunRequest{Handle: resp.Handle, CpuTimeLimit: 5}, stdin)
+		done <- res
+	}()
+	select {
+	case <-stdin.started:
+	case res := <-done:
+		t.Skipf("sandbox unavailable: %s %s", res.Status.Description, res.Message)
+	}
+	if _, err := store.Compile(context.Background(), req); !errors.Is(err, executor.ErrArtifactStoreFull) {
+		t.Errorf("Expected ErrArtifactStoreFull while the only artifact is running, got %v", err)
+	}
+	close(stdin.release)
+	<-done
+
+	// 运行结束后可以淘汰旧的编译产物
+	if resp, err := store.Compile(context.Background(), req); err != nil || resp.Handle == "" {
+		t.Errorf("Compile() after the run finished = %+v, %v", resp, err)
+	}
+}
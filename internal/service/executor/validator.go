@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"nightcord-server/internal/model"
+	"nightcord-server/internal/service/storage"
+	"nightcord-server/utils"
+	"strings"
+	"sync"
+)
+
+// validatorMessageLimit 校验信息保留的最大字节数
+const validatorMessageLimit = 1024
+
+// validatorArtifact 校验程序的编译产物，hash为编译时源文件内容的哈希
+type validatorArtifact struct {
+	hash   string
+	handle string
+}
+
+var (
+	validatorMu        sync.Mutex
+	validatorArtifacts = make(map[string]validatorArtifact) // 键见 validatorKey
+)
+
+// validatorKey 返回校验程序编译产物的键，源文件、语言与编译选项相同的校验程序共用编译产物
+func validatorKey(v model.Validator) string {
+	return fmt.Sprintf("%d\x00%s\x00%s", v.LanguageID, v.Source, strings.Join(v.CompileOptions, "\x00"))
+}
+
+// ValidateInput 编译校验程序并在沙箱中以input为标准输入运行，实现 storage.ValidateFunc
+// 退出码为0表示输入有效，非0表示无效；编译失败、超时或被信号终止等视为校验程序出错
+// 编译产物保存在 ArtifactStore 中，源文件内容不变时不重新编译，产物过期或被淘汰后再次编译
+func ValidateInput(ctx context.Context, v model.Validator, input io.Reader) (bool, string, error) {
+	store := GetArtifactStoreInstance()
+	req := model.RunRequest{CpuTimeLimit: v.CpuTimeLimit, MemoryLimit: v.MemoryLimit}
+	var res model.RunResult
+	for attempt := 0; ; attempt++ {
+		handle, err := compileValidator(ctx, v)
+		if err != nil {
+			return false, "", err
+		}
+		req.Handle = handle
+		res, err = store.RunStream(ctx, req, input)
+		if err == nil {
+			break
+		}
+		// 句柄不存在时尚未读取输入，可以重新编译后再运行
+		if !errors.Is(err, ErrArtifactNotFound) || attempt > 0 {
+			return false, "", fmt.Errorf("failed to run validator: %v", err)
+		}
+		forgetValidator(v, handle)
+	}
+
+	message := strings.TrimSpace(res.Stderr)
+	if message == "" {
+		message = strings.TrimSpace(res.Stdout)
+	}
+	message, _ = utils.TruncateString(message, validatorMessageLimit)
+
+	switch res.Status.Id {
+	case model.StatusAC:
+		return true, message, nil
+	case model.StatusRENZEC:
+		if message == "" {
+			message = res.Message
+		}
+		return false, message, nil
+	default:
+		if res.Message != "" {
+			return false, "", fmt.Errorf("validator %s: %s", res.Status.Description, res.Message)
+		}
+		return false, "", fmt.Errorf("validator %s", res.Status.Description)
+	}
+}
+
+// compileValidator 返回校验程序当前源文件的编译产物句柄，没有可用的产物时编译
+func compileValidator(ctx context.Context, v model.Validator) (string, error) {
+	se := storage.GetStorageEngineInstance()
+	metadata, err := se.GetFileMetadata(v.Source)
+	if err != nil {
+		return "", fmt.Errorf("failed to read validator %s: %v", v.Source, err)
+	}
+	key := validatorKey(v)
+	validatorMu.Lock()
+	cached, ok := validatorArtifacts[key]
+	validatorMu.Unlock()
+	if ok && cached.hash == metadata.Hash {
+		return cached.handle, nil
+	}
+
+	// 读取与元数据相同的版本，保证产物与记录的哈希对应
+	src, err := se.ReadFileVersion(v.Source, metadata.Version)
+	if err != nil {
+		return "", fmt.Errorf("failed to read validator %s: %v", v.Source, err)
+	}
+	source, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to read validator %s: %v", v.Source, err)
+	}
+
+	resp, err := GetArtifactStoreInstance().Compile(ctx, model.SubmitRequest{
+		SourceCode:     string(source),
+		LanguageID:     v.LanguageID,
+		CompileOptions: v.CompileOptions,
+	})
+	if err != nil {
+		return "", fmt.Errorf("validator compilation failed: %v", err)
+	}
+	if resp.Handle == "" {
+		if resp.Status.Id == model.StatusCE {
+			return "", fmt.Errorf("validator compilation failed: %s", resp.Message)
+		}
+		return "", fmt.Errorf("validator environment preparation failed: %s", resp.Message)
+	}
+
+	// 并发编译同一个校验程序时保留先完成的产物，源文件更新后删除旧版本的产物
+	validatorMu.Lock()
+	defer validatorMu.Unlock()
+	if current, ok := validatorArtifacts[key]; ok {
+		if current.hash == metadata.Hash {
+			GetArtifactStoreInstance().Delete(resp.Handle)
+			return current.handle, nil
+		}
+		GetArtifactStoreInstance().Delete(current.handle)
+	}
+	validatorArtifacts[key] = validatorArtifact{hash: metadata.Hash, handle: resp.Handle}
+	return resp.Handle, nil
+}
+
+// forgetValidator 移除已失效的编译产物句柄
+func forgetValidator(v model.Validator, handle string) {
+	key := validatorKey(v)
+	validatorMu.Lock()
+	defer validatorMu.Unlock()
+	if validatorArtifacts[key].handle == handle {
+		delete(validatorArtifacts, key)
+	}
+}
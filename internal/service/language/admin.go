@@ -0,0 +1,99 @@
+package language
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nightcord-server/internal/model"
+	"os"
+)
+
+var ErrLanguageNotFound = errors.New("language not found")
+
+// AddLanguage 新增语言并写入 lang.json，ID为0时自动分配为当前最大ID加一
+func AddLanguage(lang model.Language) (model.Language, error) {
+	langLock.Lock()
+	defer langLock.Unlock()
+	if lang.ID == 0 {
+		for _, l := range languages {
+			lang.ID = max(lang.ID, l.ID)
+		}
+		lang.ID++
+	}
+	langs := append(append([]model.Language(nil), languages...), lang)
+	if err := commitLanguages(langs); err != nil {
+		return model.Language{}, err
+	}
+	return lang, nil
+}
+
+// UpdateLanguage 替换指定ID的语言配置并写入 lang.json
+func UpdateLanguage(id int, lang model.Language) (model.Language, error) {
+	langLock.Lock()
+	defer langLock.Unlock()
+	idx := indexOfLanguage(id)
+	if idx < 0 {
+		return model.Language{}, ErrLanguageNotFound
+	}
+	lang.ID = id
+	langs := append([]model.Language(nil), languages...)
+	langs[idx] = lang
+	if err := commitLanguages(langs); err != nil {
+		return model.Language{}, err
+	}
+	return lang, nil
+}
+
+// SetLanguageDisabled 启用或停用指定ID的语言并写入 lang.json
+func SetLanguageDisabled(id int, disabled bool) (model.Language, error) {
+	langLock.Lock()
+	defer langLock.Unlock()
+	idx := indexOfLanguage(id)
+	if idx < 0 {
+		return model.Language{}, ErrLanguageNotFound
+	}
+	langs := append([]model.Language(nil), languages...)
+	langs[idx].Disabled = disabled
+	if err := commitLanguages(langs); err != nil {
+		return model.Language{}, err
+	}
+	return langs[idx], nil
+}
+
+// indexOfLanguage 返回指定ID的语言下标，调用者需持有锁
+func indexOfLanguage(id int) int {
+	for i, lang := range languages {
+		if lang.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// commitLanguages 校验并保存新的语言配置，成功后替换当前配置，调用者需持有写锁
+func commitLanguages(langs []model.Language) error {
+	if err := ValidateLanguages(langs); err != nil {
+		return err
+	}
+	if err := writeLanguages(langs); err != nil {
+		return err
+	}
+	languages = langs
+	return nil
+}
+
+// writeLanguages 将语言配置原子地写入 lang.json
+func writeLanguages(langs []model.Language) error {
+	data, err := json.MarshalIndent(langs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := langFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("写入%s错误: %v", langFile, err)
+	}
+	if err := os.Rename(tmpFile, langFile); err != nil {
+		return fmt.Errorf("写入%s错误: %v", langFile, err)
+	}
+	return nil
+}
@@ -2,33 +2,88 @@ package language
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"nightcord-server/internal/model"
 	"os"
 )
 
-func init() {
-	languages = LoadLanguages()
+// InitLanguages 读取并校验 lang.json，作为当前生效的语言配置
+func InitLanguages() error {
+	return ReloadLanguages()
 }
 
-// LoadLanguages 读取并解析 lang.json，并为每种语言分配自增ID
-func LoadLanguages() []model.Language {
-	data, err := os.ReadFile("lang.json")
+// LoadLanguages 读取并解析 lang.json
+// 未指定ID的语言按其在文件中的位置分配ID（与旧版本一致），位置对应的ID已被占用时使用最大ID之后的值
+func LoadLanguages() ([]model.Language, error) {
+	langs, _, err := loadLanguages()
+	return langs, err
+}
+
+// loadLanguages 读取并解析 lang.json，同时返回是否为语言分配了ID
+func loadLanguages() ([]model.Language, bool, error) {
+	data, err := os.ReadFile(langFile)
 	if err != nil {
-		log.Fatalf("读取lang.json错误: %v", err)
+		return nil, false, fmt.Errorf("读取%s错误: %v", langFile, err)
 	}
 	var langs []model.Language
 	if err := json.Unmarshal(data, &langs); err != nil {
-		log.Fatalf("解析lang.json错误: %v", err)
+		return nil, false, fmt.Errorf("解析%s错误: %v", langFile, err)
 	}
+	assigned := assignLanguageIDs(langs)
+	if err := ValidateLanguages(langs); err != nil {
+		return nil, false, err
+	}
+	return langs, assigned, nil
+}
+
+// assignLanguageIDs 为未指定ID的语言分配ID，返回是否有语言被分配
+func assignLanguageIDs(langs []model.Language) bool {
+	used := make(map[int]bool, len(langs))
+	maxID := 0
+	for _, lang := range langs {
+		used[lang.ID] = true
+		maxID = max(maxID, lang.ID)
+	}
+	assigned := false
 	for i := range langs {
-		langs[i].ID = i + 1
+		if langs[i].ID != 0 {
+			continue
+		}
+		id := i + 1
+		if used[id] {
+			maxID++
+			id = maxID
+		}
+		langs[i].ID = id
+		used[id] = true
+		maxID = max(maxID, id)
+		assigned = true
 	}
-	return langs
+	return assigned
+}
+
+// ReloadLanguages 重新加载 lang.json，校验失败时保留原有配置
+// 已经取得 model.Language 的任务不受影响；分配了ID时写回 lang.json，保证ID在之后的加载中保持不变
+// 读取与管理接口的写入持有同一个锁，不会用较旧的文件内容覆盖刚写入的配置
+func ReloadLanguages() error {
+	langLock.Lock()
+	defer langLock.Unlock()
+	langs, assigned, err := loadLanguages()
+	if err != nil {
+		return err
+	}
+	if assigned {
+		if err := writeLanguages(langs); err != nil {
+			log.Printf("Failed to save assigned language ids: %v", err)
+		}
+	}
+	languages = langs
+	return nil
 }
 
 func GetLanguageByID(id int) model.Language {
-	for _, lang := range languages {
+	for _, lang := range GetLanguages() {
 		if lang.ID == id {
 			return lang
 		}
@@ -37,7 +92,7 @@ func GetLanguageByID(id int) model.Language {
 }
 
 func GetLanguageByName(name string) model.Language {
-	for _, lang := range languages {
+	for _, lang := range GetLanguages() {
 		if lang.Name == name {
 			return lang
 		}
@@ -45,6 +100,36 @@ func GetLanguageByName(name string) model.Language {
 	return model.Language{}
 }
 
+// GetLanguages 返回所有启用的语言
 func GetLanguages() []model.Language {
-	return languages
+	langLock.RLock()
+	defer langLock.RUnlock()
+	langs := make([]model.Language, 0, len(languages))
+	for _, lang := range languages {
+		if !lang.Disabled {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// GetAllLanguages 返回包括已停用语言在内的所有语言
+func GetAllLanguages() []model.Language {
+	langLock.RLock()
+	defer langLock.RUnlock()
+	return append([]model.Language(nil), languages...)
+}
+
+// SetLanguageVersion 记录检测到的语言版本信息
+func SetLanguageVersion(id int, version string) {
+	versionLock.Lock()
+	defer versionLock.Unlock()
+	versions[id] = version
+}
+
+// GetLanguageVersion 返回检测到的语言版本信息，未检测时返回空字符串
+func GetLanguageVersion(id int) string {
+	versionLock.RLock()
+	defer versionLock.RUnlock()
+	return versions[id]
 }
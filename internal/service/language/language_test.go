@@ -0,0 +1,120 @@
+package language
+
+import (
+	"errors"
+	"fmt"
+	"nightcord-server/internal/model"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidateLanguages(t *testing.T) {
+	valid := []model.Language{
+		{ID: 1, Name: "C", SourceFile: "main.c", CompileCmd: "gcc main.c -o main", RunCmd: "./main"},
+		{ID: 2, Name: "Python", SourceFile: "main.py", RunCmd: "python3 main.py", SandboxProfile: model.SandboxProfileRuntime},
+	}
+	if err := ValidateLanguages(valid); err != nil {
+		t.Fatalf("Valid languages were rejected: %v", err)
+	}
+
+	invalid := []model.Language{
+		{ID: 1, Name: "C", SourceFile: "main.c", RunCmd: "./main"},
+		{ID: 1, Name: "C", SourceFile: "../main.c", SandboxProfile: "unknown"},
+		{Name: "Go", SourceFile: "main.go", RunCmd: "./main"},
+	}
+	err := ValidateLanguages(invalid)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected ValidationError, got: %v", err)
+	}
+
+	// languages[1]: source_file、run_cmd、sandbox_profile、重复的id与name；languages[2]: 缺少id
+	if len(verr.Errors) != 6 {
+		t.Errorf("Expected 6 validation errors, got %d: %v", len(verr.Errors), verr.Errors)
+	}
+}
+
+func TestLoadLanguagesAssignsIDs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := `[
+  {"name": "C", "source_file": "main.c", "run_cmd": "./main"},
+  {"id": 1, "name": "C++", "source_file": "main.cpp", "run_cmd": "./main"},
+  {"name": "Python", "source_file": "main.py", "run_cmd": "python3 main.py"}
+]`
+	if err := os.WriteFile(langFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadLanguages(); err != nil {
+		t.Fatalf("Failed to load languages without ids: %v", err)
+	}
+	// 位置对应的ID已被占用时使用最大ID之后的值
+	want := map[string]int{"C": 2, "C++": 1, "Python": 3}
+	for _, lang := range GetAllLanguages() {
+		if lang.ID != want[lang.Name] {
+			t.Errorf("%s: got id %d, want %d", lang.Name, lang.ID, want[lang.Name])
+		}
+	}
+
+	// 分配的ID写回文件
+	langs, err := LoadLanguages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(langFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(saved), `"id": 3`) {
+		t.Errorf("Assigned ids were not persisted: %s", saved)
+	}
+	for _, lang := range langs {
+		if lang.ID != want[lang.Name] {
+			t.Errorf("%s: got persisted id %d, want %d", lang.Name, lang.ID, want[lang.Name])
+		}
+	}
+}
+
+func TestReloadLanguagesConcurrentWithAdmin(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := `[{"id": 1, "name": "C", "source_file": "main.c", "run_cmd": "./main"}]`
+	if err := os.WriteFile(langFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadLanguages(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载不能用较旧的文件内容覆盖管理接口刚写入的语言，否则之后的写入会丢失这些语言
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			lang := model.Language{Name: fmt.Sprintf("L%d", i), SourceFile: "main.c", RunCmd: "./main"}
+			if _, err := AddLanguage(lang); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if err := ReloadLanguages(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(GetAllLanguages()); got != n+1 {
+		t.Errorf("Expected %d languages in memory, got %d", n+1, got)
+	}
+	langs, err := LoadLanguages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(langs) != n+1 {
+		t.Errorf("Expected %d languages in %s, got %d", n+1, langFile, len(langs))
+	}
+}
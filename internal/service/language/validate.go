@@ -0,0 +1,107 @@
+package language
+
+import (
+	"fmt"
+	"nightcord-server/internal/model"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ValidationError 表示语言配置校验失败，Errors 为逐项的错误描述
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid language config: " + strings.Join(e.Errors, "; ")
+}
+
+// ValidateLanguages 校验语言配置列表，所有问题汇总在返回的 ValidationError 中
+func ValidateLanguages(langs []model.Language) error {
+	var errs []string
+	ids := make(map[int]int)
+	names := make(map[string]int)
+	for i, lang := range langs {
+		prefix := fmt.Sprintf("languages[%d]", i)
+		if lang.Name != "" {
+			prefix += fmt.Sprintf(" (%s)", lang.Name)
+		}
+		for _, msg := range validateLanguage(lang) {
+			errs = append(errs, prefix+": "+msg)
+		}
+		if lang.ID > 0 {
+			if j, ok := ids[lang.ID]; ok {
+				errs = append(errs, fmt.Sprintf("%s: id %d duplicates languages[%d]", prefix, lang.ID, j))
+			} else {
+				ids[lang.ID] = i
+			}
+		}
+		if lang.Name != "" {
+			if j, ok := names[lang.Name]; ok {
+				errs = append(errs, fmt.Sprintf("%s: name duplicates languages[%d]", prefix, j))
+			} else {
+				names[lang.Name] = i
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validateLanguage 校验单个语言配置的字段
+func validateLanguage(lang model.Language) []string {
+	var errs []string
+	if lang.ID <= 0 {
+		errs = append(errs, "id must be a positive integer")
+	}
+	if strings.TrimSpace(lang.Name) == "" {
+		errs = append(errs, "name is required")
+	}
+	if lang.SourceFile == "" {
+		errs = append(errs, "source_file is required")
+	} else if lang.SourceFile != filepath.Base(lang.SourceFile) || lang.SourceFile == "." || lang.SourceFile == ".." {
+		errs = append(errs, fmt.Sprintf("source_file %q must be a plain file name", lang.SourceFile))
+	}
+	if lang.BinaryFile != "" && (lang.BinaryFile != filepath.Base(lang.BinaryFile) || lang.BinaryFile == "." || lang.BinaryFile == "..") {
+		errs = append(errs, fmt.Sprintf("binary_file %q must be a plain file name", lang.BinaryFile))
+	}
+	if strings.TrimSpace(lang.RunCmd) == "" {
+		errs = append(errs, "run_cmd is required")
+	}
+	if lang.TimeMultiplier < 0 {
+		errs = append(errs, "time_multiplier must not be negative")
+	}
+	if lang.CompileTimeout < 0 {
+		errs = append(errs, "compile_timeout must not be negative")
+	}
+	if lang.StackLimit < model.StackLimitMemory {
+		errs = append(errs, "stack_limit must be positive, -1 (unlimited) or -2 (same as memory)")
+	}
+	if lang.ProcessLimit < 0 {
+		errs = append(errs, "process_limit must not be negative")
+	}
+	if lang.SandboxProfile != "" && !slices.Contains(model.SandboxProfiles, lang.SandboxProfile) {
+		errs = append(errs, fmt.Sprintf("unknown sandbox_profile %q, expected one of %v", lang.SandboxProfile, model.SandboxProfiles))
+	}
+	for i, opt := range lang.CompileOptions {
+		if strings.TrimSpace(opt) == "" || strings.ContainsRune(opt, 0) {
+			errs = append(errs, fmt.Sprintf("invalid compile option %q", opt))
+		} else if slices.Contains(lang.CompileOptions[:i], opt) {
+			errs = append(errs, fmt.Sprintf("duplicate compile option %q", opt))
+		}
+	}
+	for _, opt := range lang.DefaultOptions {
+		if !slices.Contains(lang.CompileOptions, opt) {
+			errs = append(errs, fmt.Sprintf("default option %q is not in compile_options", opt))
+		}
+	}
+	for name := range lang.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			errs = append(errs, fmt.Sprintf("invalid env name %q", name))
+		}
+	}
+	return errs
+}
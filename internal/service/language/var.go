@@ -1,5 +1,16 @@
 package language
 
-import "nightcord-server/internal/model"
+import (
+	"nightcord-server/internal/model"
+	"sync"
+)
 
-var languages []model.Language
+const langFile = "lang.json" // 语言配置文件路径
+
+var (
+	languages []model.Language // 当前生效的语言配置，只整体替换不原地修改
+	langLock  sync.RWMutex     // 保护languages与lang.json的读写
+
+	versions    = make(map[int]string) // 语言ID到检测到的版本信息
+	versionLock sync.RWMutex
+)
@@ -0,0 +1,159 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrObjectNotFound 后端中不存在指定的对象
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrPreconditionFailed 条件写入的条件不成立，对象已被其他节点修改或创建
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Backend 存放blob内容的后端，键为使用/分隔的相对路径
+// 存储引擎写入的blob以内容哈希为键，同一个键的内容不会改变；共用元数据时另有 metadataKey 一个对象，只通过 conditionalBackend 读写
+type Backend interface {
+	// Put 写入对象，size为内容的字节数
+	Put(key string, r io.Reader, size int64) error
+	// Get 读取对象，不存在时返回 ErrObjectNotFound
+	Get(key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(key string) error
+	// List 列出键以prefix开头的对象
+	List(prefix string) ([]ObjectInfo, error)
+	// Stat 获取对象信息，不存在时返回 ErrObjectNotFound
+	Stat(key string) (*ObjectInfo, error)
+}
+
+// ObjectInfo 后端中对象的信息
+type ObjectInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// filePutter 可以直接接管本地临时文件的后端，避免再复制一次内容
+type filePutter interface {
+	PutFile(key, path string) error
+}
+
+// conditionalBackend 支持按ETag条件读写的后端，多个节点通过它共用元数据
+type conditionalBackend interface {
+	// GetIfChanged 读取对象及其ETag，对象的ETag与etag相同时返回nil的内容，不存在时返回 ErrObjectNotFound
+	GetIfChanged(key, etag string) (io.ReadCloser, string, error)
+	// PutIfMatch 仅当对象当前的ETag为etag时写入并返回新的ETag，etag为空表示对象必须不存在
+	// 条件不成立时返回 ErrPreconditionFailed
+	PutIfMatch(key string, r io.Reader, size int64, etag string) (string, error)
+}
+
+// backendTmpPrefix 后端目录中正在写入的临时文件前缀
+const backendTmpPrefix = ".tmp-"
+
+// LocalBackend 将对象存放在本地目录中的后端
+type LocalBackend struct {
+	root string
+}
+
+// NewLocalBackend 创建以root为根目录的本地后端
+func NewLocalBackend(root string) (*LocalBackend, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	return &LocalBackend{root: root}, nil
+}
+
+func (b *LocalBackend) path(key string) string {
+	return filepath.Join(b.root, filepath.FromSlash(key))
+}
+
+// Put 先写入临时文件再重命名，避免读取到写了一半的内容
+func (b *LocalBackend) Put(key string, r io.Reader, size int64) error {
+	path := b.path(key)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(dir, backendTmpPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // 重命名成功后为空操作
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PutFile 将同一文件系统上的文件重命名为对象
+func (b *LocalBackend) PutFile(key, path string) error {
+	target := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	return os.Rename(path, target)
+}
+
+func (b *LocalBackend) Get(key string) (io.ReadCloser, error) {
+	file, err := os.Open(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+func (b *LocalBackend) Delete(key string) error {
+	if err := os.Remove(b.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *LocalBackend) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || strings.HasPrefix(d.Name(), backendTmpPrefix) {
+			return err
+		}
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (b *LocalBackend) Stat(key string) (*ObjectInfo, error) {
+	info, err := os.Stat(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
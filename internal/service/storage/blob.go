@@ -0,0 +1,258 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+const (
+	blobDir    = "blobs"    // 本地后端在存储目录下存放blob的子目录
+	stagingDir = ".staging" // 存储目录下暂存正在上传的内容的子目录
+)
+
+// ErrBlobCorrupted 文件内容与记录的SHA-256不一致
+var ErrBlobCorrupted = errors.New("file content does not match its hash")
+
+// initBlobTables 初始化blob引用计数表
+func (se *StorageEngine) initBlobTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS blobs (
+		hash TEXT PRIMARY KEY,
+		size INTEGER NOT NULL,
+		refcount INTEGER NOT NULL
+	);
+	`
+	_, err := se.db.Exec(query)
+	return err
+}
+
+// hashContent 计算内容的SHA-256，以十六进制表示
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// blobKey 返回哈希在后端中的键，按哈希前两位分目录避免单个目录过大
+func blobKey(hash string) string {
+	return hash[:2] + "/" + hash
+}
+
+// stagedBlob 已写入临时文件、尚未重命名为blob的内容
+type stagedBlob struct {
+	path string
+	hash string
+	size int64
+}
+
+// stageBlob 将内容流式写入暂存目录下的临时文件，同时计算SHA-256
+// maxSize大于0时超过该字节数返回 ErrFileTooLarge；text为true时内容必须是有效的UTF-8
+func (se *StorageEngine) stageBlob(r io.Reader, maxSize int64, text bool) (*stagedBlob, error) {
+	tmp, err := os.CreateTemp(filepath.Join(se.storeDir, stagingDir), backendTmpPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write file: %v", err)
+	}
+	staged := &stagedBlob{path: tmp.Name()}
+	fail := func(err error) (*stagedBlob, error) {
+		tmp.Close()
+		os.Remove(staged.path)
+		return nil, err
+	}
+
+	h := sha256.New()
+	checker := &utf8Checker{}
+	w := io.MultiWriter(tmp, h)
+	if text {
+		w = io.MultiWriter(tmp, h, checker)
+	}
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	if staged.size, err = io.Copy(w, r); err != nil {
+		return fail(fmt.Errorf("failed to write file: %w", err))
+	}
+	if maxSize > 0 && staged.size > maxSize {
+		return fail(ErrFileTooLarge)
+	}
+	if text && !checker.valid() {
+		return fail(ErrNotTestcaseFile)
+	}
+	if err := tmp.Close(); err != nil {
+		return fail(fmt.Errorf("failed to write file: %v", err))
+	}
+	staged.hash = hex.EncodeToString(h.Sum(nil))
+	return staged, nil
+}
+
+// commitBlob 将临时文件写入后端，内容相同的blob已存在时丢弃临时文件
+// 共用后端中已存在的blob可能正要被其他节点回收，总是重新上传以刷新其上传时间，见 GCGracePeriod
+func (se *StorageEngine) commitBlob(staged *stagedBlob) error {
+	key := blobKey(staged.hash)
+	if !se.shared() {
+		if _, err := se.backend.Stat(key); err == nil {
+			return os.Remove(staged.path)
+		} else if !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+	}
+	if p, ok := se.backend.(filePutter); ok {
+		return p.PutFile(key, staged.path)
+	}
+
+	file, err := os.Open(staged.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return se.backend.Put(key, file, staged.size)
+}
+
+// utf8Checker 分块校验写入的内容是否为有效的UTF-8，跨块的多字节字符会暂存到下一次写入
+type utf8Checker struct {
+	pending []byte
+	invalid bool
+}
+
+func (c *utf8Checker) Write(p []byte) (int, error) {
+	if c.invalid {
+		return len(p), nil
+	}
+	buf := append(c.pending, p...)
+	// 末尾不完整的字符留到下一块，最多回退 utf8.UTFMax-1 个字节
+	cut := len(buf)
+	for i := len(buf) - 1; i >= 0 && i > len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(buf[:cut]) {
+		c.invalid = true
+	}
+	c.pending = append(c.pending[:0], buf[cut:]...)
+	return len(p), nil
+}
+
+// valid 返回全部写入的内容是否为有效的UTF-8
+func (c *utf8Checker) valid() bool {
+	return !c.invalid && len(c.pending) == 0
+}
+
+// refBlob 增加blob的引用计数，首次引用时插入记录
+func refBlob(tx *sql.Tx, hash string, size int64) error {
+	_, err := tx.Exec(
+		"INSERT INTO blobs (hash, size, refcount) VALUES (?, ?, 1) ON CONFLICT(hash) DO UPDATE SET refcount = refcount + 1",
+		hash, size,
+	)
+	return err
+}
+
+// unrefBlob 减少blob的引用计数，返回引用计数是否归零；归零时删除记录，文件由调用者在提交后删除
+func unrefBlob(tx *sql.Tx, hash string) (bool, error) {
+	if _, err := tx.Exec("UPDATE blobs SET refcount = refcount - 1 WHERE hash = ?", hash); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec("DELETE FROM blobs WHERE hash = ? AND refcount <= 0", hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// removeBlob 从后端删除不再被引用的blob；共用后端中其他节点可能刚上传了相同的内容而尚未发布引用，由 CollectGarbage 按上传时间回收
+func (se *StorageEngine) removeBlob(hash string) {
+	if se.shared() {
+		return
+	}
+	if err := se.backend.Delete(blobKey(hash)); err != nil {
+		log.Printf("Failed to remove blob %s: %v", hash, err)
+	}
+}
+
+// migrateLegacyFiles 将按文件名直接存放在存储目录下的旧文件移入blob存储
+func (se *StorageEngine) migrateLegacyFiles() error {
+	rows, err := se.db.Query("SELECT path FROM file_metadata WHERE hash = ''")
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return err
+		}
+		paths = append(paths, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("Failed to migrate %s into blob storage: %v", path, err)
+			continue
+		}
+		staged, err := se.stageBlob(file, 0, false)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if err := se.commitBlob(staged); err != nil {
+			return err
+		}
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE file_metadata SET hash = ?, size = ? WHERE path = ?", staged.hash, staged.size, path); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := refBlob(tx, staged.hash, staged.size); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		os.Remove(path)
+	}
+	return nil
+}
+
+// verifyingReader 在读取到末尾时校验内容的SHA-256，不一致时返回 ErrBlobCorrupted 而非 io.EOF
+type verifyingReader struct {
+	file io.ReadCloser
+	hash hash.Hash
+	want string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.want {
+		return n, ErrBlobCorrupted
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,93 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobDeduplication(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	content := []byte("1 2\n")
+	if err := se.WriteFile("a.in", content); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.WriteFile("b.in", content); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := se.GetFileMetadata("a.in")
+	b, _ := se.GetFileMetadata("b.in")
+	if a.Hash != hashContent(content) || a.Hash != b.Hash {
+		t.Fatalf("Hash mismatch: %q %q", a.Hash, b.Hash)
+	}
+	var refcount int
+	se.db.QueryRow("SELECT refcount FROM blobs WHERE hash = ?", a.Hash).Scan(&refcount)
+	if refcount != 2 {
+		t.Errorf("Expected refcount 2, got %d", refcount)
+	}
+
+	// 删除其中一个文件后blob仍被另一个文件引用
+	if err := se.DeleteFile("a.in"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := se.backend.Stat(blobKey(a.Hash)); err != nil {
+		t.Errorf("Blob removed while still referenced: %v", err)
+	}
+
+	// 覆盖写入后旧内容仍被历史版本引用，删除文件后不再被引用
+	if err := se.WriteFile("b.in", []byte("3 4\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := se.backend.Stat(blobKey(a.Hash)); err != nil {
+		t.Errorf("Blob removed while still referenced by a version: %v", err)
+	}
+	if err := se.DeleteFile("b.in"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := se.backend.Stat(blobKey(a.Hash)); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected unreferenced blob to be removed, got %v", err)
+	}
+	var count int
+	se.db.QueryRow("SELECT COUNT(*) FROM blobs").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no blobs left, got %d", count)
+	}
+}
+
+func TestBlobCorruption(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	if err := se.WriteFile("a.in", []byte("1 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	metadata, _ := se.GetFileMetadata("a.in")
+	if err := se.backend.Put(blobKey(metadata.Hash), strings.NewReader("9 9\n"), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := se.ReadFile("a.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrBlobCorrupted) {
+		t.Errorf("Expected ErrBlobCorrupted, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"nightcord-server/utils"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CachedBackend 在远端后端前加一层本地磁盘缓存的后端，读取时未命中才从远端获取
+// blob按内容寻址，同一个键的内容不会改变，因此缓存无需失效，超出容量时按最近最少使用淘汰
+// 最近使用时间记录在缓存文件的修改时间上，重启后据此恢复淘汰顺序
+type CachedBackend struct {
+	Backend                // 远端后端，List与Stat直接转发
+	dir     string         // 缓存目录
+	mu      sync.Mutex     // 保护lru
+	lru     *utils.DiskLRU // 文件名为编码后的键，键中的/被编码，所有缓存文件位于同一目录下
+}
+
+// NewCachedBackend 创建带读缓存的后端，加载目录中已有的缓存文件并清理未写完的文件
+func NewCachedBackend(remote Backend, dir string, maxSize int64) (*CachedBackend, error) {
+	lru, err := utils.NewDiskLRU(dir, maxSize, url.PathEscape, func(d fs.DirEntry) (string, bool) {
+		key, err := url.PathUnescape(d.Name())
+		return key, err == nil && d.Type().IsRegular() && !strings.HasPrefix(d.Name(), backendTmpPrefix)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return &CachedBackend{Backend: remote, dir: dir, lru: lru}, nil
+}
+
+// Get 优先从缓存读取，未命中时从远端获取并写入缓存
+func (b *CachedBackend) Get(key string) (io.ReadCloser, error) {
+	if file := b.open(key); file != nil {
+		return file, nil
+	}
+
+	rc, err := b.Backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	tmp, err := os.CreateTemp(b.dir, backendTmpPrefix)
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(tmp, rc)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return b.insert(key, tmp.Name(), size)
+}
+
+// Put 写入远端
+func (b *CachedBackend) Put(key string, r io.Reader, size int64) error {
+	return b.Backend.Put(key, r, size)
+}
+
+// PutFile 将本地文件写入远端，并将其移入缓存，刚上传的测试数据评测时无需再从远端获取
+func (b *CachedBackend) PutFile(key, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err == nil {
+		err = b.Backend.Put(key, file, info.Size())
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	cached, err := b.insert(key, path, info.Size())
+	if err != nil {
+		return nil // 已写入远端，缓存失败不影响结果
+	}
+	return cached.Close()
+}
+
+// Delete 从远端与缓存中删除
+func (b *CachedBackend) Delete(key string) error {
+	b.mu.Lock()
+	b.lru.Remove(key)
+	b.mu.Unlock()
+	return b.Backend.Delete(key)
+}
+
+// open 打开已缓存的文件并更新最近使用时间，未命中时返回nil
+func (b *CachedBackend) open(key string) *os.File {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.lru.Contains(key) {
+		return nil
+	}
+	file, err := os.Open(b.lru.Path(key))
+	if err != nil {
+		// 缓存文件被外部删除，视为未命中
+		b.lru.Remove(key)
+		return nil
+	}
+	b.lru.Touch(key)
+	return file
+}
+
+// insert 将临时文件移入缓存并打开
+// 在持有锁时打开，避免返回前被并发的写入淘汰；超出容量的文件不缓存，打开后直接删除
+func (b *CachedBackend) insert(key, tmpPath string, size int64) (*os.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size > b.lru.MaxSize() {
+		file, err := os.Open(tmpPath)
+		os.Remove(tmpPath)
+		return file, err
+	}
+
+	// 并发的读取已写入缓存时直接覆盖，内容相同
+	path := b.lru.Path(key)
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	b.lru.Add(key, size)
+	return os.Open(path)
+}
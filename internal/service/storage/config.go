@@ -8,12 +8,29 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+)
+
+// 后端类型
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
 )
 
 // Config 存储引擎配置
 type Config struct {
-	StoreDir string `yaml:"store_dir"` // 存储目录
-	DBPath   string `yaml:"db_path"`   // 数据库文件路径
+	StoreDir  string   `yaml:"store_dir"`  // 存储目录
+	DBPath    string   `yaml:"db_path"`    // 数据库文件路径
+	Backend   string   `yaml:"backend"`    // 后端类型，为空时使用本地后端
+	S3        S3Config `yaml:"s3"`         // S3后端的连接配置
+	CacheDir  string   `yaml:"cache_dir"`  // 远端后端的本地读缓存目录
+	CacheSize int64    `yaml:"cache_size"` // 远端后端的本地读缓存容量（字节），0表示不缓存
+	// S3后端时多个节点共用同一个存储桶与前缀中的内容与元数据，见 ShareMetadata
+	SyncInterval time.Duration `yaml:"sync_interval"` // 同步其他节点写入的元数据的周期，0表示只在写入与评测前同步
+	GCInterval   time.Duration `yaml:"gc_interval"`   // 清理过期版本并回收共用后端中无引用blob的周期，0表示本节点不清理
+	// 历史版本的保留策略，见 VersionRetention
+	KeepVersions  int           `yaml:"keep_versions"`   // 每个文件最多保留的版本数，0表示不限制
+	VersionMaxAge time.Duration `yaml:"version_max_age"` // 历史版本的最长保留时间，0表示不限制
 }
 
 // DefaultConfig 默认配置
@@ -32,17 +49,52 @@ func (c *Config) Validate() error {
 	if c.DBPath == "" {
 		return fmt.Errorf("db_path cannot be empty")
 	}
+	switch c.Backend {
+	case "", BackendLocal, BackendS3:
+	default:
+		return fmt.Errorf("unknown storage backend %q", c.Backend)
+	}
+	if c.SyncInterval < 0 {
+		return fmt.Errorf("sync_interval cannot be negative")
+	}
+	if c.GCInterval < 0 {
+		return fmt.Errorf("gc_interval cannot be negative")
+	}
+	if c.KeepVersions < 0 {
+		return fmt.Errorf("keep_versions cannot be negative")
+	}
+	if c.VersionMaxAge < 0 {
+		return fmt.Errorf("version_max_age cannot be negative")
+	}
 	return nil
 }
 
+// newBackend 根据配置创建后端，远端后端按配置加上本地读缓存
+func (c *Config) newBackend() (Backend, error) {
+	if c.Backend != BackendS3 {
+		return NewLocalBackend(filepath.Join(c.StoreDir, blobDir))
+	}
+	backend, err := NewS3Backend(c.S3)
+	if err != nil {
+		return nil, err
+	}
+	if c.CacheSize <= 0 || c.CacheDir == "" {
+		return backend, nil
+	}
+	return NewCachedBackend(backend, c.CacheDir, c.CacheSize)
+}
+
 var (
 	globalStorageEngine *StorageEngine
 	onceStorageEngine   sync.Once
 )
 
-// GetStorageEngineInstance 获取存储引擎单例实例
+// GetStorageEngineInstance 获取存储引擎单例实例，未调用 InitStorageEngine 时使用默认配置创建
 func GetStorageEngineInstance() *StorageEngine {
 	onceStorageEngine.Do(func() {
+		if globalStorageEngine != nil {
+			return
+		}
 		config := DefaultConfig()
 		var err error
 		globalStorageEngine, err = NewStorageEngine(config.StoreDir, config.DBPath)
@@ -67,9 +119,29 @@ func InitStorageEngine(config *Config) error {
 		}
 	}
 
-	var err error
-	globalStorageEngine, err = NewStorageEngine(config.StoreDir, config.DBPath)
-	return err
+	backend, err := config.newBackend()
+	if err != nil {
+		return err
+	}
+	se, err := NewStorageEngineWithBackend(config.StoreDir, config.DBPath, backend)
+	if err != nil {
+		return err
+	}
+	if config.Backend == BackendS3 {
+		if err := se.ShareMetadata(); err != nil {
+			se.Close()
+			return fmt.Errorf("failed to share metadata: %v", err)
+		}
+		if config.SyncInterval > 0 {
+			go se.syncLoop(config.SyncInterval)
+		}
+	}
+	se.SetVersionRetention(VersionRetention{Keep: config.KeepVersions, MaxAge: config.VersionMaxAge})
+	if config.GCInterval > 0 {
+		go se.gcLoop(config.GCInterval)
+	}
+	globalStorageEngine = se
+	return nil
 }
 
 // CloseStorageEngine 关闭存储引擎
@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// GCGracePeriod 垃圾回收不删除在这段时间内上传过的blob
+// 节点先上传blob再发布引用它的元数据，上传与发布之间的blob没有引用，由上传时间保护
+const GCGracePeriod = 24 * time.Hour
+
+// CollectGarbage 同步共用的元数据，然后删除共用后端中没有被任何文件版本引用、且超过grace没有上传过的blob，返回删除的数量
+// 后端不与其他节点共用时blob随引用释放而删除，无需回收
+func (se *StorageEngine) CollectGarbage(grace time.Duration) (int, error) {
+	if !se.shared() {
+		return 0, nil
+	}
+	remote := se.remoteBackend()
+	objects, err := remote.List("")
+	if err != nil {
+		return 0, err
+	}
+	if err := se.Sync(); err != nil {
+		return 0, err
+	}
+	referenced := make(map[string]bool)
+	rows, err := se.db.Query("SELECT hash FROM blobs")
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		referenced[hash] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	deadline := time.Now().Add(-grace)
+	deleted := 0
+	for _, obj := range objects {
+		hash, ok := blobHash(obj.Key)
+		if !ok || referenced[hash] || obj.ModTime.After(deadline) {
+			continue
+		}
+		// 列出之后其他节点可能重新上传了相同的内容并即将引用它，删除前再次检查上传时间
+		if info, err := remote.Stat(obj.Key); err != nil || info.ModTime.After(deadline) {
+			continue
+		}
+		// 通过缓存删除，同时清除本地缓存的副本
+		if err := se.backend.Delete(obj.Key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// blobHash 从 blobKey 生成的键中解析哈希，不是blob的键返回false
+func blobHash(key string) (string, bool) {
+	dir, hash, ok := strings.Cut(key, "/")
+	if !ok || len(hash) != 64 || !strings.HasPrefix(hash, dir) || len(dir) != 2 {
+		return "", false
+	}
+	return hash, true
+}
+
+// gcLoop 定期按保留策略清理历史版本，然后回收共用后端中的blob
+// 清理在回收之前进行，被清理的版本释放的blob在同一轮即可回收
+func (se *StorageEngine) gcLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := se.PruneVersions(); err != nil {
+			log.Printf("Failed to prune file versions: %v", err)
+		} else if n > 0 {
+			log.Printf("Pruned %d file versions", n)
+		}
+		if n, err := se.CollectGarbage(GCGracePeriod); err != nil {
+			log.Printf("Failed to collect unreferenced blobs: %v", err)
+		} else if n > 0 {
+			log.Printf("Removed %d unreferenced blobs", n)
+		}
+	}
+}
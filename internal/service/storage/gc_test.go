@@ -0,0 +1,88 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSharedBackendGC(t *testing.T) {
+	fake, server := newFakeS3(t)
+	tempDir := t.TempDir()
+	a := newSharedNode(t, server, filepath.Join(tempDir, "a"))
+	b := newSharedNode(t, server, filepath.Join(tempDir, "b"))
+
+	write := func(se *StorageEngine, name, content string) string {
+		t.Helper()
+		if err := se.WriteFile(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		metadata, err := se.GetFileMetadata(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return metadata.Hash
+	}
+	exists := func(hash string) bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		_, ok := fake.objects["nc/"+blobKey(hash)]
+		return ok
+	}
+	// 模拟较早上传的对象，使其超出宽限期
+	age := func() {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		for key := range fake.modified {
+			fake.modified[key] = time.Now().Add(-2 * time.Hour)
+		}
+	}
+
+	write(a, "x/1.in", "shared")
+	shared := write(b, "y/1.in", "shared")
+	own := write(a, "x/2.in", "a only")
+	orphan := write(a, "tmp.in", "orphan")
+
+	// 引用归零时不立即删除共用后端中的blob
+	if err := b.DeleteFile("tmp.in"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteFile("x/1.in"); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(orphan) || !exists(shared) {
+		t.Fatal("Blobs in a shared backend should not be removed when released")
+	}
+	if got := readString(t, a, "y/1.in"); got != "shared" {
+		t.Errorf("Unexpected content %q", got)
+	}
+
+	// 宽限期内上传的blob不回收
+	if n, err := b.CollectGarbage(time.Hour); err != nil || n != 0 {
+		t.Fatalf("CollectGarbage within grace period = %d, %v, want 0", n, err)
+	}
+	age()
+	if n, err := b.CollectGarbage(time.Hour); err != nil || n != 1 {
+		t.Fatalf("CollectGarbage = %d, %v, want 1", n, err)
+	}
+	if exists(orphan) || !exists(shared) || !exists(own) {
+		t.Errorf("Only the unreferenced blob should be removed")
+	}
+
+	// 最后一个引用在其他节点释放后，回收前同步即可看到
+	if err := b.DeleteFile("y/1.in"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := a.CollectGarbage(time.Hour); err != nil || n != 1 {
+		t.Fatalf("CollectGarbage = %d, %v, want 1", n, err)
+	}
+	if exists(shared) || !exists(own) {
+		t.Errorf("Expected the released shared blob to be removed")
+	}
+	if _, ok := fake.objects["nc/"+metadataKey]; !ok {
+		t.Error("Metadata must not be collected")
+	}
+}
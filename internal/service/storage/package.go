@@ -0,0 +1,289 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"nightcord-server/utils"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPackage 测试数据包无法读取或存在校验错误
+var ErrInvalidPackage = errors.New("invalid testcase package")
+
+// testcaseNamePatterns 测试数据文件的命名约定，第一个分组为前缀，第二个分组为编号
+var testcaseNamePatterns = []struct {
+	re    *regexp.Regexp
+	input bool
+}{
+	{regexp.MustCompile(`^(.*?)(\d+)\.in$`), true},
+	{regexp.MustCompile(`^(.*?)(\d+)\.(?:out|ans)$`), false},
+	{regexp.MustCompile(`(?i)^(.*?)input(\d+)\.txt$`), true},
+	{regexp.MustCompile(`(?i)^(.*?)output(\d+)\.txt$`), false},
+}
+
+// ImportOptions 导入测试数据包的选项
+type ImportOptions struct {
+	Prefix    string             // 写入存储时添加在文件名前的前缀
+	ProblemID int64              // 非0时用配对结果替换该题目的测试点
+	Limit     utils.ArchiveLimit // 解压限制
+}
+
+// ImportEntryError 压缩包中单个条目的校验错误
+type ImportEntryError struct {
+	Entry string `json:"entry"`
+	Error string `json:"error"`
+}
+
+// ImportResult 测试数据包的导入结果
+type ImportResult struct {
+	Files     []string           `json:"files"`            // 写入存储的文件名
+	Testcases []ProblemTestcase  `json:"testcases"`        // 按编号排序的输入输出配对
+	Errors    []ImportEntryError `json:"errors,omitempty"` // 存在错误时不写入任何文件
+}
+
+// ExportEntry 导出压缩包中的一个条目
+type ExportEntry struct {
+	Name string // 压缩包中的文件名
+	File string // 存储引擎中的文件名
+	Size int64
+}
+
+// importPair 导入时按前缀与编号配对的输入输出文件
+type importPair struct {
+	dir, prefix             string
+	number                  int
+	input, output           string // 写入存储的文件名
+	inputEntry, outputEntry string // 压缩包中的条目名，用于报告错误
+}
+
+// ImportPackage 导入zip或tar.gz格式的测试数据包
+// 按命名约定将同一目录下的1.in与1.out、input1.txt与output1.txt等配对，文件名保留包内的目录
+// 全部条目校验通过后才写入存储，写入失败时撤销已写入的文件
+func (se *StorageEngine) ImportPackage(data []byte, opts ImportOptions) (*ImportResult, error) {
+	var problem *Problem
+	if opts.ProblemID != 0 {
+		var err error
+		if problem, err = se.getProblem(opts.ProblemID); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := utils.ReadArchive(data, opts.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+	}
+
+	result := &ImportResult{}
+	pairs := make(map[string]*importPair)
+	contents := make(map[string][]byte)
+	addError := func(entry, format string, args ...any) {
+		result.Errors = append(result.Errors, ImportEntryError{Entry: entry, Error: fmt.Sprintf(format, args...)})
+	}
+	for _, entry := range entries {
+		dir, base := path.Split(entry.Name)
+		if base == "" || strings.HasPrefix(base, ".") || strings.HasPrefix(entry.Name, "__MACOSX/") {
+			continue // 忽略隐藏文件与压缩工具生成的元数据
+		}
+		prefix, number, input, ok := matchTestcaseName(base)
+		if !ok {
+			addError(entry.Name, "file name does not follow the 1.in/1.out or input1.txt/output1.txt convention")
+			continue
+		}
+		filename := opts.Prefix + entry.Name
+		if !IsValidFilename(filename) {
+			addError(entry.Name, "invalid filename %q", filename)
+			continue
+		}
+		if _, exists := contents[filename]; exists {
+			addError(entry.Name, "duplicate filename %q", filename)
+			continue
+		}
+		if !se.isTestcaseFile(se.GetContentType(filename), entry.Data) {
+			addError(entry.Name, "only testcase files are allowed")
+			continue
+		}
+		contents[filename] = entry.Data
+
+		key := dir + "\x00" + prefix + "\x00" + strconv.Itoa(number)
+		pair, ok := pairs[key]
+		if !ok {
+			pair = &importPair{dir: dir, prefix: prefix, number: number}
+			pairs[key] = pair
+		}
+		// 1.in与01.in编号相同，不能确定哪一个与输出文件配对
+		if input && pair.input != "" {
+			addError(entry.Name, "testcase %d already has input file %q", number, pair.inputEntry)
+		} else if !input && pair.output != "" {
+			addError(entry.Name, "testcase %d already has output file %q", number, pair.outputEntry)
+		} else if input {
+			pair.input, pair.inputEntry = filename, entry.Name
+		} else {
+			pair.output, pair.outputEntry = filename, entry.Name
+		}
+	}
+
+	sorted := make([]*importPair, 0, len(pairs))
+	for _, pair := range pairs {
+		sorted = append(sorted, pair)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.dir != b.dir {
+			return a.dir < b.dir
+		}
+		if a.prefix != b.prefix {
+			return a.prefix < b.prefix
+		}
+		return a.number < b.number
+	})
+	for _, pair := range sorted {
+		switch {
+		case pair.input == "":
+			addError(pair.outputEntry, "missing input file for testcase %d", pair.number)
+		case pair.output == "":
+			addError(pair.inputEntry, "missing output file for testcase %d", pair.number)
+		default:
+			result.Testcases = append(result.Testcases, ProblemTestcase{
+				Index:  len(result.Testcases) + 1,
+				Input:  pair.input,
+				Output: pair.output,
+			})
+		}
+	}
+	if len(result.Errors) == 0 && len(result.Testcases) == 0 {
+		addError("", "no testcases found in package")
+	}
+	if len(result.Errors) > 0 {
+		return result, ErrInvalidPackage
+	}
+
+	for _, tc := range result.Testcases {
+		result.Files = append(result.Files, tc.Input, tc.Output)
+	}
+	undo, err := se.writeImportFiles(result.Files, contents)
+	if err != nil {
+		return nil, err
+	}
+	if problem != nil {
+		if err := se.SetProblemTestcases(problem.ID, result.Testcases); err != nil {
+			undo()
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// writeImportFiles 依次写入导入的文件，任一文件写入失败时撤销已写入的文件并返回错误
+// 成功时返回的undo用于在后续步骤失败时撤销：新建的文件被删除，已有的文件恢复为导入前的版本
+func (se *StorageEngine) writeImportFiles(names []string, contents map[string][]byte) (undo func(), err error) {
+	previous := make(map[string]int64, len(names))
+	var written []string
+	undo = func() {
+		for i := len(written) - 1; i >= 0; i-- {
+			name := written[i]
+			var err error
+			if version, ok := previous[name]; ok {
+				_, err = se.restoreVersion(context.Background(), name, version, false)
+			} else {
+				err = se.DeleteFile(name)
+			}
+			if err != nil {
+				log.Printf("Failed to roll back imported file %s: %v", name, err)
+			}
+		}
+	}
+	for _, name := range names {
+		if metadata, err := se.GetFileMetadata(name); err == nil {
+			previous[name] = metadata.Version
+		}
+		if err := se.WriteFile(name, contents[name]); err != nil {
+			undo()
+			return nil, err
+		}
+		written = append(written, name)
+	}
+	return undo, nil
+}
+
+// ExportEntries 返回导出的条目；指定题目时按测试点顺序命名为<序号>.in与<序号>.out，否则导出全部文件
+func (se *StorageEngine) ExportEntries(problemID int64) ([]ExportEntry, error) {
+	if problemID == 0 {
+		list, err := se.ListFiles(ListOptions{Recursive: true})
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]ExportEntry, len(list.Files))
+		for i, f := range list.Files {
+			entries[i] = ExportEntry{Name: f.Filename, File: f.Filename, Size: f.Size}
+		}
+		return entries, nil
+	}
+
+	testcases, err := se.GetProblemTestcases(problemID)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ExportEntry
+	for _, tc := range testcases {
+		for _, f := range []struct{ file, ext string }{{tc.Input, ".in"}, {tc.Output, ".out"}} {
+			if f.file == "" {
+				continue
+			}
+			metadata, err := se.GetFileMetadata(f.file)
+			if err != nil {
+				return nil, fmt.Errorf("testcase %d: %v", tc.Index, err)
+			}
+			entries = append(entries, ExportEntry{Name: strconv.Itoa(tc.Index) + f.ext, File: f.file, Size: metadata.Size})
+		}
+	}
+	return entries, nil
+}
+
+// WritePackage 将条目以流的方式写成压缩包，format取值为 utils.ArchiveZip 或 utils.ArchiveTarGz
+func (se *StorageEngine) WritePackage(w io.Writer, format string, entries []ExportEntry) error {
+	aw, err := utils.NewArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := se.writePackageEntry(aw, entry); err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+func (se *StorageEngine) writePackageEntry(aw *utils.ArchiveWriter, entry ExportEntry) error {
+	reader, err := se.ReadFile(entry.File)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return aw.Add(entry.Name, entry.Size, reader)
+}
+
+// matchTestcaseName 按命名约定解析测试数据文件名，返回前缀、编号与是否为输入文件
+func matchTestcaseName(name string) (string, int, bool, bool) {
+	for _, pattern := range testcaseNamePatterns {
+		m := pattern.re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		number, err := strconv.Atoi(m[2])
+		if err != nil {
+			return "", 0, false, false
+		}
+		return m[1], number, pattern.input, true
+	}
+	return "", 0, false, false
+}
@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"nightcord-server/utils"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportPackage(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	// 缺少输出文件与不符合命名约定的条目逐条报告，且不写入任何文件
+	result, err := se.ImportPackage(buildZip(t, map[string]string{
+		"1.in":      "1 1",
+		"1.out":     "2",
+		"2.in":      "2 2",
+		"notes.md":  "x",
+		".DS_Store": "x",
+	}), ImportOptions{})
+	if !errors.Is(err, ErrInvalidPackage) || result == nil || len(result.Errors) != 2 {
+		t.Fatalf("Expected 2 entry errors, got %+v, %v", result, err)
+	}
+	if files, _ := se.ListFiles(ListOptions{Recursive: true}); files.Total != 0 {
+		t.Errorf("Files should not be written when validation fails, got %d", files.Total)
+	}
+
+	problem := &Problem{Title: "A+B"}
+	if err := se.CreateProblem(problem); err != nil {
+		t.Fatal(err)
+	}
+	result, err = se.ImportPackage(buildZip(t, map[string]string{
+		"data/10.in":       "10 10",
+		"data/10.out":      "20",
+		"data/2.in":        "2 2",
+		"data/2.ans":       "4",
+		"data/input1.txt":  "1 1",
+		"data/output1.txt": "2",
+	}), ImportOptions{Prefix: "ab/", ProblemID: problem.ID})
+	if err != nil {
+		t.Fatalf("Failed to import package: %v, %+v", err, result)
+	}
+	// 按编号数值排序，而非字典序
+	want := []ProblemTestcase{
+		{Index: 1, Input: "ab/data/input1.txt", Output: "ab/data/output1.txt"},
+		{Index: 2, Input: "ab/data/2.in", Output: "ab/data/2.ans"},
+		{Index: 3, Input: "ab/data/10.in", Output: "ab/data/10.out"},
+	}
+	testcases, err := se.GetProblemTestcases(problem.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(testcases) != len(want) {
+		t.Fatalf("Expected %d testcases, got %+v", len(want), testcases)
+	}
+	for i := range want {
+		if testcases[i] != want[i] {
+			t.Errorf("testcases[%d] = %+v, want %+v", i, testcases[i], want[i])
+		}
+	}
+
+	// 导出的题目数据按测试点顺序命名，可以重新导入
+	entries, err := se.ExportEntries(problem.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := se.WritePackage(&buf, utils.ArchiveTarGz, entries); err != nil {
+		t.Fatal(err)
+	}
+	files, err := utils.ReadArchive(buf.Bytes(), utils.ArchiveLimit{})
+	if err != nil || len(files) != 6 || files[2].Name != "2.in" || string(files[2].Data) != "2 2" {
+		t.Errorf("Unexpected exported package: %+v, %v", files, err)
+	}
+}
+
+func TestImportPackageDirs(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	// 不同目录下的同名文件分别配对
+	result, err := se.ImportPackage(buildZip(t, map[string]string{
+		"subtask1/1.in":  "1",
+		"subtask1/1.out": "1",
+		"subtask2/1.in":  "2",
+		"subtask2/1.out": "2",
+	}), ImportOptions{Prefix: "p/"})
+	if err != nil {
+		t.Fatalf("Failed to import package: %v, %+v", err, result)
+	}
+	if len(result.Testcases) != 2 || result.Testcases[1].Input != "p/subtask2/1.in" {
+		t.Fatalf("Unexpected testcases: %+v", result.Testcases)
+	}
+
+	// 编号相同的文件报告错误而不是互相覆盖
+	result, err = se.ImportPackage(buildZip(t, map[string]string{
+		"1.in":  "1",
+		"01.in": "1",
+		"1.out": "1",
+	}), ImportOptions{Prefix: "q/"})
+	if !errors.Is(err, ErrInvalidPackage) || result == nil || len(result.Errors) != 1 {
+		t.Fatalf("Expected a duplicate testcase error, got %+v, %v", result, err)
+	}
+
+	// 写入失败时撤销已写入的文件：新建的文件被删除，已有的文件恢复原内容
+	if err := se.WriteFile("r/1.in", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.WriteFile("r/x", []byte("file")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.ImportPackage(buildZip(t, map[string]string{
+		"1.in":    "new",
+		"1.out":   "new",
+		"x/1.in":  "1",
+		"x/1.out": "1",
+	}), ImportOptions{Prefix: "r/"})
+	if !errors.Is(err, ErrInvalidFilename) {
+		t.Fatalf("Expected ErrInvalidFilename for a path conflict, got %v", err)
+	}
+	if _, err := se.GetFileMetadata("r/1.out"); err == nil {
+		t.Error("Created file should be removed after a failed import")
+	}
+	reader, err := se.ReadFile("r/1.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if content, _ := io.ReadAll(reader); string(content) != "old" {
+		t.Errorf("Existing file should be restored, got %q", content)
+	}
+}
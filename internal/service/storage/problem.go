@@ -0,0 +1,552 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nightcord-server/internal/model"
+	"time"
+)
+
+var (
+	// ErrProblemNotFound 题目不存在
+	ErrProblemNotFound = errors.New("problem not found")
+	// ErrInvalidProblem 题目或测试点的设置无效，具体原因包装在错误信息中
+	ErrInvalidProblem = errors.New("invalid problem")
+	// ErrFileInUse 文件被题目的测试点引用，不能删除
+	ErrFileInUse = errors.New("file is used by a problem")
+)
+
+// Problem 题目，包含有序的测试点、默认限制、输出比较方式与子任务
+type Problem struct {
+	ID           int64                      `json:"id"`
+	Title        string                     `json:"title"`
+	CpuTimeLimit float64                    `json:"cpu_time_limit,omitempty"` // 0表示使用语言的默认值，下同
+	MemoryLimit  uint                       `json:"memory_limit,omitempty"`
+	StackLimit   int                        `json:"stack_limit,omitempty"` // KB，取值见 model.StackLimitUnlimited 与 model.StackLimitMemory
+	Checker      string                     `json:"checker,omitempty"`     // 取值见 model.CheckerDefault 等常量
+	Subtasks     []model.Subtask            `json:"subtasks,omitempty"`
+	Graders      map[int][]model.GraderFile `json:"graders,omitempty"`   // 按语言ID指定的评测程序文件
+	Validator    *model.Validator           `json:"validator,omitempty"` // 校验作为测试点输入上传的文件
+	Source       string                     `json:"source,omitempty"`    // 导入来源，取值见 PackagePolygon 等常量，手动创建时为空
+	Statements   []Statement                `json:"statements,omitempty"`
+	Testcases    []ProblemTestcase          `json:"testcases,omitempty"` // 仅在获取单个题目时返回；创建或更新时非nil则替换全部测试点
+	CreatedAt    time.Time                  `json:"created_at"`
+	UpdatedAt    time.Time                  `json:"updated_at"`
+}
+
+// Statement 题面文件，按语言区分
+type Statement struct {
+	Language string `json:"language"`
+	Title    string `json:"title,omitempty"`
+	File     string `json:"file"` // 存储引擎中的文件名
+}
+
+// ProblemTestcase 题目的一组测试点，输入输出均为存储引擎中的文件名
+type ProblemTestcase struct {
+	Index   int    `json:"index"`             // 从1开始的顺序，写入时按列表顺序重新编号
+	Input   string `json:"input"`             // 为空表示无输入
+	Output  string `json:"output"`            // 为空表示不比较输出
+	Subtask int    `json:"subtask,omitempty"` // 所属子任务ID，0表示不属于任何子任务
+}
+
+// initProblemTables 初始化题目相关的数据库表
+func (se *StorageEngine) initProblemTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS problems (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL,
+		cpu_time_limit REAL NOT NULL DEFAULT 0,
+		memory_limit INTEGER NOT NULL DEFAULT 0,
+		stack_limit INTEGER NOT NULL DEFAULT 0,
+		checker TEXT NOT NULL DEFAULT '',
+		subtasks TEXT NOT NULL DEFAULT '[]',
+		graders TEXT NOT NULL DEFAULT '{}',
+		source TEXT NOT NULL DEFAULT '',
+		statements TEXT NOT NULL DEFAULT '[]',
+		validator TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS problem_testcases (
+		problem_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		input TEXT NOT NULL,
+		output TEXT NOT NULL,
+		subtask INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (problem_id, position)
+	);
+	`
+	_, err := se.db.Exec(query)
+	return err
+}
+
+// validate 检查题目的设置，不检查测试点
+func (p *Problem) validate() error {
+	if p.Title == "" {
+		return fmt.Errorf("%w: title is required", ErrInvalidProblem)
+	}
+	if p.CpuTimeLimit < 0 {
+		return fmt.Errorf("%w: cpu_time_limit cannot be negative", ErrInvalidProblem)
+	}
+	if p.StackLimit < model.StackLimitMemory {
+		return fmt.Errorf("%w: invalid stack_limit %d", ErrInvalidProblem, p.StackLimit)
+	}
+	if !model.IsValidChecker(p.Checker) {
+		return fmt.Errorf("%w: unknown checker %q", ErrInvalidProblem, p.Checker)
+	}
+	seen := make(map[int]bool)
+	for _, subtask := range p.Subtasks {
+		if subtask.ID <= 0 {
+			return fmt.Errorf("%w: subtask id must be positive", ErrInvalidProblem)
+		}
+		if seen[subtask.ID] {
+			return fmt.Errorf("%w: duplicate subtask id %d", ErrInvalidProblem, subtask.ID)
+		}
+		if subtask.Score < 0 {
+			return fmt.Errorf("%w: subtask %d has a negative score", ErrInvalidProblem, subtask.ID)
+		}
+		seen[subtask.ID] = true
+	}
+	if p.Validator != nil {
+		if err := checkValidator(p.Validator); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProblem, err)
+		}
+	}
+	return nil
+}
+
+// validateTestcases 检查测试点引用的文件存在、子任务已定义，并将文件名规范化
+// 保存规范化的文件名，删除文件时才能按文件名找到引用它的测试点（如 ./1.in 与 1.in）
+func (se *StorageEngine) validateTestcases(p *Problem, testcases []ProblemTestcase) error {
+	subtasks := make(map[int]bool)
+	for _, subtask := range p.Subtasks {
+		subtasks[subtask.ID] = true
+	}
+	for i := range testcases {
+		tc := &testcases[i]
+		if tc.Input == "" && tc.Output == "" {
+			return fmt.Errorf("%w: testcase %d has neither input nor output", ErrInvalidProblem, i+1)
+		}
+		for _, filename := range []*string{&tc.Input, &tc.Output} {
+			if *filename == "" {
+				continue
+			}
+			clean, err := CleanFilename(*filename)
+			if err != nil {
+				return fmt.Errorf("%w: testcase %d references invalid file %q", ErrInvalidProblem, i+1, *filename)
+			}
+			if _, err := se.GetFileMetadata(clean); err != nil {
+				return fmt.Errorf("%w: testcase %d references missing file %q", ErrInvalidProblem, i+1, *filename)
+			}
+			*filename = clean
+		}
+		if tc.Subtask != 0 && !subtasks[tc.Subtask] {
+			return fmt.Errorf("%w: testcase %d references undefined subtask %d", ErrInvalidProblem, i+1, tc.Subtask)
+		}
+	}
+	return nil
+}
+
+// CreateProblem 创建题目，成功后回填ID；Testcases非nil时一并写入测试点
+// 检查引用的文件与写入在同一次加锁中完成，检查之后文件不会被删除
+func (se *StorageEngine) CreateProblem(p *Problem) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	var id int64
+	err := se.update(func() error {
+		if err := se.validateTestcases(p, p.Testcases); err != nil {
+			return err
+		}
+		if err := se.checkValidatorSource(p.Validator); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProblem, err)
+		}
+		fields, err := marshalProblemFields(p)
+		if err != nil {
+			return err
+		}
+
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(
+			"INSERT INTO problems (title, cpu_time_limit, memory_limit, stack_limit, checker, subtasks, graders, source, statements, validator) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			p.Title, p.CpuTimeLimit, p.MemoryLimit, p.StackLimit, p.Checker, fields.subtasks, fields.graders, p.Source, fields.statements, fields.validator,
+		)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := replaceTestcases(tx, id, p.Testcases); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	return nil
+}
+
+// UpdateProblem 更新题目的设置；Testcases非nil时替换全部测试点
+func (se *StorageEngine) UpdateProblem(p *Problem) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.update(func() error {
+		testcases := p.Testcases
+		if testcases == nil {
+			// 子任务可能被修改，已有的测试点同样需要满足引用约束
+			var err error
+			testcases, err = se.GetProblemTestcases(p.ID)
+			if err != nil {
+				return err
+			}
+		}
+		if err := se.validateTestcases(p, testcases); err != nil {
+			return err
+		}
+		if err := se.checkValidatorSource(p.Validator); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProblem, err)
+		}
+		fields, err := marshalProblemFields(p)
+		if err != nil {
+			return err
+		}
+
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(
+			"UPDATE problems SET title = ?, cpu_time_limit = ?, memory_limit = ?, stack_limit = ?, checker = ?, subtasks = ?, graders = ?, source = ?, statements = ?, validator = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+			p.Title, p.CpuTimeLimit, p.MemoryLimit, p.StackLimit, p.Checker, fields.subtasks, fields.graders, p.Source, fields.statements, fields.validator, p.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrProblemNotFound
+		}
+		if p.Testcases != nil {
+			if err := replaceTestcases(tx, p.ID, p.Testcases); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// SetProblemTestcases 按给定顺序替换题目的全部测试点
+func (se *StorageEngine) SetProblemTestcases(id int64, testcases []ProblemTestcase) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.update(func() error {
+		p, err := se.getProblem(id)
+		if err != nil {
+			return err
+		}
+		if err := se.validateTestcases(p, testcases); err != nil {
+			return err
+		}
+
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := replaceTestcases(tx, id, testcases); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE problems SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// AddProblemTestcase 在题目末尾追加一组测试点，返回其序号
+func (se *StorageEngine) AddProblemTestcase(id int64, tc ProblemTestcase) (int, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	var index int
+	err := se.update(func() error {
+		p, err := se.getProblem(id)
+		if err != nil {
+			return err
+		}
+		testcases := []ProblemTestcase{tc}
+		if err := se.validateTestcases(p, testcases); err != nil {
+			return err
+		}
+		tc := testcases[0]
+
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := tx.QueryRow("SELECT COALESCE(MAX(position), 0) + 1 FROM problem_testcases WHERE problem_id = ?", id).Scan(&index); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO problem_testcases (problem_id, position, input, output, subtask) VALUES (?, ?, ?, ?, ?)",
+			id, index, tc.Input, tc.Output, tc.Subtask,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE problems SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// GetProblem 获取题目及其全部测试点
+func (se *StorageEngine) GetProblem(id int64) (*Problem, error) {
+	p, err := se.getProblem(id)
+	if err != nil {
+		return nil, err
+	}
+	p.Testcases, err = se.GetProblemTestcases(id)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// getProblem 获取题目的设置，不包含测试点
+func (se *StorageEngine) getProblem(id int64) (*Problem, error) {
+	row := se.db.QueryRow(
+		"SELECT id, title, cpu_time_limit, memory_limit, stack_limit, checker, subtasks, graders, source, statements, validator, created_at, updated_at FROM problems WHERE id = ?",
+		id,
+	)
+	p, err := scanProblem(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrProblemNotFound
+	}
+	return p, err
+}
+
+// ListProblems 列出所有题目，不包含测试点
+func (se *StorageEngine) ListProblems() ([]*Problem, error) {
+	rows, err := se.db.Query(
+		"SELECT id, title, cpu_time_limit, memory_limit, stack_limit, checker, subtasks, graders, source, statements, validator, created_at, updated_at FROM problems ORDER BY id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []*Problem{}
+	for rows.Next() {
+		p, err := scanProblem(rows)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, p)
+	}
+	return problems, rows.Err()
+}
+
+// GetProblemTestcases 按顺序获取题目的测试点
+func (se *StorageEngine) GetProblemTestcases(id int64) ([]ProblemTestcase, error) {
+	var exists bool
+	if err := se.db.QueryRow("SELECT EXISTS(SELECT 1 FROM problems WHERE id = ?)", id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrProblemNotFound
+	}
+
+	rows, err := se.db.Query(
+		"SELECT position, input, output, subtask FROM problem_testcases WHERE problem_id = ? ORDER BY position",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	testcases := []ProblemTestcase{}
+	for rows.Next() {
+		var tc ProblemTestcase
+		if err := rows.Scan(&tc.Index, &tc.Input, &tc.Output, &tc.Subtask); err != nil {
+			return nil, err
+		}
+		testcases = append(testcases, tc)
+	}
+	return testcases, rows.Err()
+}
+
+// DeleteProblem 删除题目及其测试点，测试点引用的文件保留在存储中
+func (se *StorageEngine) DeleteProblem(id int64) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.update(func() error {
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec("DELETE FROM problems WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrProblemNotFound
+		}
+		if _, err := tx.Exec("DELETE FROM problem_testcases WHERE problem_id = ?", id); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// fileUsedByProblem 返回引用该文件的一个题目ID，未被引用时返回0
+func (se *StorageEngine) fileUsedByProblem(filename string) (int64, error) {
+	var id int64
+	err := se.db.QueryRow(
+		"SELECT problem_id FROM problem_testcases WHERE input = ? OR output = ? LIMIT 1",
+		filename, filename,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// replaceTestcases 删除题目原有的测试点并按列表顺序从1开始编号写入
+func replaceTestcases(tx *sql.Tx, id int64, testcases []ProblemTestcase) error {
+	if _, err := tx.Exec("DELETE FROM problem_testcases WHERE problem_id = ?", id); err != nil {
+		return err
+	}
+	for i, tc := range testcases {
+		if _, err := tx.Exec(
+			"INSERT INTO problem_testcases (problem_id, position, input, output, subtask) VALUES (?, ?, ?, ?, ?)",
+			id, i+1, tc.Input, tc.Output, tc.Subtask,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// problemJSONFields 题目中以JSON保存的列
+type problemJSONFields struct {
+	subtasks, graders, statements, validator string
+}
+
+// marshalProblemFields 将子任务、评测程序、题面与校验程序序列化为JSON列，没有校验程序时为空字符串
+func marshalProblemFields(p *Problem) (problemJSONFields, error) {
+	var fields problemJSONFields
+	subtasks := p.Subtasks
+	if subtasks == nil {
+		subtasks = []model.Subtask{}
+	}
+	data, err := json.Marshal(subtasks)
+	if err != nil {
+		return fields, err
+	}
+	fields.subtasks = string(data)
+	graders := p.Graders
+	if graders == nil {
+		graders = map[int][]model.GraderFile{}
+	}
+	if data, err = json.Marshal(graders); err != nil {
+		return fields, err
+	}
+	fields.graders = string(data)
+	statements := p.Statements
+	if statements == nil {
+		statements = []Statement{}
+	}
+	if data, err = json.Marshal(statements); err != nil {
+		return fields, err
+	}
+	fields.statements = string(data)
+	if p.Validator != nil {
+		if data, err = json.Marshal(p.Validator); err != nil {
+			return fields, err
+		}
+		fields.validator = string(data)
+	}
+	return fields, nil
+}
+
+// scanProblem 从查询结果中读取题目，*sql.Row 与 *sql.Rows 均可使用
+func scanProblem(row interface{ Scan(...any) error }) (*Problem, error) {
+	var p Problem
+	var subtasks, graders, statements, validator string
+	err := row.Scan(
+		&p.ID,
+		&p.Title,
+		&p.CpuTimeLimit,
+		&p.MemoryLimit,
+		&p.StackLimit,
+		&p.Checker,
+		&subtasks,
+		&graders,
+		&p.Source,
+		&statements,
+		&validator,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(subtasks), &p.Subtasks); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(graders), &p.Graders); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(statements), &p.Statements); err != nil {
+		return nil, err
+	}
+	if validator != "" {
+		if err := json.Unmarshal([]byte(validator), &p.Validator); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.Subtasks) == 0 {
+		p.Subtasks = nil
+	}
+	if len(p.Graders) == 0 {
+		p.Graders = nil
+	}
+	if len(p.Statements) == 0 {
+		p.Statements = nil
+	}
+	return &p, nil
+}
@@ -0,0 +1,472 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"nightcord-server/internal/model"
+	"nightcord-server/utils"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 题目包格式，同时作为导入题目的 Problem.Source
+const (
+	PackagePolygon = "polygon" // Codeforces Polygon 导出的题目包（problem.xml）
+	PackageKattis  = "kattis"  // Kattis problemarchive 格式（problem.yaml）
+)
+
+// ErrUnsupportedPackage 无法识别题目包格式
+var ErrUnsupportedPackage = errors.New("unsupported problem package format")
+
+// unsafeNameChars 匹配不能出现在存储文件名中的字符
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// ProblemImportResult 题目包的导入结果
+type ProblemImportResult struct {
+	Problem  *Problem `json:"problem"`
+	Files    []string `json:"files"`              // 写入存储的文件名
+	Warnings []string `json:"warnings,omitempty"` // 无法完整转换的设置，如自定义checker
+}
+
+// problemPackage 从题目包中解析出的题目与待写入存储的文件
+type problemPackage struct {
+	problem  Problem
+	files    map[string][]byte // 存储文件名到内容
+	order    []string          // 文件的写入顺序
+	warnings []string
+}
+
+func (pkg *problemPackage) addFile(name string, data []byte) error {
+	if !IsValidFilename(name) {
+		return fmt.Errorf("%w: invalid filename %q", ErrInvalidPackage, name)
+	}
+	if _, exists := pkg.files[name]; exists {
+		return fmt.Errorf("%w: duplicate filename %q", ErrInvalidPackage, name)
+	}
+	pkg.files[name] = data
+	pkg.order = append(pkg.order, name)
+	return nil
+}
+
+func (pkg *problemPackage) warnf(format string, args ...any) {
+	pkg.warnings = append(pkg.warnings, fmt.Sprintf(format, args...))
+}
+
+// ImportProblemPackage 导入 Polygon 或 Kattis 格式的题目包，写入测试数据与题面并创建题目
+// format为空时根据包中的problem.xml或problem.yaml判断；opts.ProblemID非0时更新该题目而非新建
+// 未指定opts.Prefix时使用题目的短名称作为文件名前缀；写入文件或保存题目失败时撤销已写入的文件
+func (se *StorageEngine) ImportProblemPackage(data []byte, format string, opts ImportOptions) (*ProblemImportResult, error) {
+	entries, err := utils.ReadArchive(data, opts.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+	}
+
+	if format == "" {
+		if format = detectPackageFormat(entries); format == "" {
+			return nil, ErrUnsupportedPackage
+		}
+	}
+	var marker string
+	switch format {
+	case PackagePolygon:
+		marker = "problem.xml"
+	case PackageKattis:
+		marker = "problem.yaml"
+	default:
+		return nil, ErrUnsupportedPackage
+	}
+	files, ok := packageRoot(entries, marker)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalidPackage, marker)
+	}
+
+	var pkg *problemPackage
+	if format == PackagePolygon {
+		pkg, err = parsePolygonPackage(files, opts.Prefix)
+	} else {
+		pkg, err = parseKattisPackage(files, opts.Prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(pkg.problem.Testcases) == 0 {
+		return nil, fmt.Errorf("%w: no testcases found", ErrInvalidPackage)
+	}
+	pkg.problem.Source = format
+	if err := pkg.problem.validate(); err != nil {
+		return nil, err
+	}
+	if opts.ProblemID != 0 {
+		if _, err := se.getProblem(opts.ProblemID); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range pkg.order {
+		if !se.isTestcaseFile(se.GetContentType(name), pkg.files[name]) {
+			return nil, fmt.Errorf("%w: %s is not a text file", ErrInvalidPackage, name)
+		}
+	}
+
+	result := &ProblemImportResult{Warnings: pkg.warnings, Files: pkg.order}
+	undo, err := se.writeImportFiles(pkg.order, pkg.files)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ProblemID != 0 {
+		pkg.problem.ID = opts.ProblemID
+		err = se.UpdateProblem(&pkg.problem)
+	} else {
+		err = se.CreateProblem(&pkg.problem)
+	}
+	if err != nil {
+		undo()
+		return nil, err
+	}
+	if result.Problem, err = se.GetProblem(pkg.problem.ID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// detectPackageFormat 根据标志文件判断题目包格式，无法识别时返回空字符串
+func detectPackageFormat(entries []utils.ArchiveFile) string {
+	if _, ok := packageRoot(entries, "problem.xml"); ok {
+		return PackagePolygon
+	}
+	if _, ok := packageRoot(entries, "problem.yaml"); ok {
+		return PackageKattis
+	}
+	return ""
+}
+
+// packageRoot 以层级最浅的标志文件所在目录为题目包根目录，返回根目录下的文件（路径相对根目录）
+func packageRoot(entries []utils.ArchiveFile, marker string) (map[string][]byte, bool) {
+	root, found := "", false
+	for _, entry := range entries {
+		dir, base := path.Split(entry.Name)
+		if base != marker || strings.HasPrefix(entry.Name, "__MACOSX/") {
+			continue
+		}
+		if !found || strings.Count(dir, "/") < strings.Count(root, "/") {
+			root, found = dir, true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		if rel, ok := strings.CutPrefix(entry.Name, root); ok {
+			files[rel] = entry.Data
+		}
+	}
+	return files, true
+}
+
+// filenamePrefix 返回存储文件名前缀，未指定时由题目短名称生成
+func filenamePrefix(prefix, shortName string) string {
+	if prefix != "" {
+		return prefix
+	}
+	name := strings.Trim(unsafeNameChars.ReplaceAllString(shortName, "_"), "_.")
+	if name == "" {
+		return ""
+	}
+	return name + "_"
+}
+
+// polygonProblem 对应 Polygon 题目包中 problem.xml 的结构
+type polygonProblem struct {
+	ShortName string `xml:"short-name,attr"`
+	Names     []struct {
+		Language string `xml:"language,attr"`
+		Value    string `xml:"value,attr"`
+	} `xml:"names>name"`
+	Statements []struct {
+		Language string `xml:"language,attr"`
+		Path     string `xml:"path,attr"`
+		Type     string `xml:"type,attr"`
+	} `xml:"statements>statement"`
+	Testsets []struct {
+		Name          string `xml:"name,attr"`
+		TimeLimit     int    `xml:"time-limit"`   // 毫秒
+		MemoryLimit   int64  `xml:"memory-limit"` // 字节
+		TestCount     int    `xml:"test-count"`
+		InputPattern  string `xml:"input-path-pattern"`
+		AnswerPattern string `xml:"answer-path-pattern"`
+		Tests         []struct {
+			Group  string  `xml:"group,attr"`
+			Points float64 `xml:"points,attr"`
+		} `xml:"tests>test"`
+		Groups []struct {
+			Name         string  `xml:"name,attr"`
+			Points       float64 `xml:"points,attr"`
+			PointsPolicy string  `xml:"points-policy,attr"`
+		} `xml:"groups>group"`
+	} `xml:"judging>testset"`
+	Checker struct {
+		Name string `xml:"name,attr"`
+	} `xml:"assets>checker"`
+	Interactor *struct{} `xml:"assets>interactor"`
+}
+
+// polygonCheckers testlib 标准checker到输出比较方式的对应关系
+var polygonCheckers = map[string]string{
+	"std::wcmp.cpp":  model.CheckerTokens,
+	"std::ncmp.cpp":  model.CheckerTokens,
+	"std::lcmp.cpp":  model.CheckerTokens,
+	"std::hcmp.cpp":  model.CheckerTokens,
+	"std::uncmp.cpp": model.CheckerTokens,
+	"std::fcmp.cpp":  model.CheckerDefault,
+}
+
+// parsePolygonPackage 解析 Polygon 题目包，使用名为tests的测试集
+func parsePolygonPackage(files map[string][]byte, prefix string) (*problemPackage, error) {
+	var desc polygonProblem
+	if err := xml.Unmarshal(files["problem.xml"], &desc); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse problem.xml: %v", ErrInvalidPackage, err)
+	}
+	pkg := &problemPackage{files: make(map[string][]byte)}
+	prefix = filenamePrefix(prefix, desc.ShortName)
+	p := &pkg.problem
+
+	p.Title = desc.ShortName
+	titles := make(map[string]string)
+	for _, name := range desc.Names {
+		titles[name.Language] = name.Value
+	}
+	if title, ok := titles["english"]; ok {
+		p.Title = title
+	} else if len(desc.Names) > 0 {
+		p.Title = desc.Names[0].Value
+	}
+
+	checker, ok := polygonCheckers[desc.Checker.Name]
+	if !ok {
+		checker = model.CheckerTokens
+		if desc.Checker.Name != "" {
+			pkg.warnf("checker %s is not supported, using token comparison", desc.Checker.Name)
+		}
+	}
+	p.Checker = checker
+	if desc.Interactor != nil {
+		pkg.warnf("interactive problems are not supported, the interactor is ignored")
+	}
+
+	// 题面仅导入文本格式，PDF等二进制文件跳过
+	for _, st := range desc.Statements {
+		if st.Type != "text/html" && st.Type != "application/x-tex" {
+			continue
+		}
+		data, ok := files[st.Path]
+		if !ok {
+			pkg.warnf("statement %s not found in package", st.Path)
+			continue
+		}
+		name := prefix + "statement_" + unsafeNameChars.ReplaceAllString(st.Language, "_") + path.Ext(st.Path)
+		if _, exists := pkg.files[name]; exists {
+			continue // 同一语言同时有html与tex时保留第一个
+		}
+		if err := pkg.addFile(name, data); err != nil {
+			return nil, err
+		}
+		p.Statements = append(p.Statements, Statement{Language: st.Language, Title: titles[st.Language], File: name})
+	}
+
+	testsetIdx := -1
+	for i, ts := range desc.Testsets {
+		if ts.Name == "tests" {
+			testsetIdx = i
+			break
+		}
+	}
+	if testsetIdx < 0 {
+		return nil, fmt.Errorf("%w: testset \"tests\" not found in problem.xml", ErrInvalidPackage)
+	}
+	ts := desc.Testsets[testsetIdx]
+	p.CpuTimeLimit = float64(ts.TimeLimit) / 1000
+	p.MemoryLimit = uint(ts.MemoryLimit / 1024)
+
+	// 按组在problem.xml中的顺序编号为子任务，每组全部通过才得分
+	groupIDs := make(map[string]int)
+	for _, group := range ts.Groups {
+		id := len(p.Subtasks) + 1
+		groupIDs[group.Name] = id
+		score := group.Points
+		if group.PointsPolicy == "each-test" {
+			score = 0
+			for _, test := range ts.Tests {
+				if test.Group == group.Name {
+					score += test.Points
+				}
+			}
+			pkg.warnf("group %s scores each test, imported as a subtask worth %g points in total", group.Name, score)
+		}
+		p.Subtasks = append(p.Subtasks, model.Subtask{ID: id, Score: score})
+	}
+
+	count := ts.TestCount
+	if count == 0 {
+		count = len(ts.Tests)
+	}
+	p.Testcases = []ProblemTestcase{}
+	for i := 1; i <= count; i++ {
+		inputPath := fmt.Sprintf(ts.InputPattern, i)
+		answerPath := fmt.Sprintf(ts.AnswerPattern, i)
+		input, ok := files[inputPath]
+		if !ok {
+			return nil, fmt.Errorf("%w: test %d input %s not found, generated tests must be included in the package", ErrInvalidPackage, i, inputPath)
+		}
+		answer, ok := files[answerPath]
+		if !ok {
+			return nil, fmt.Errorf("%w: test %d answer %s not found", ErrInvalidPackage, i, answerPath)
+		}
+		tc := ProblemTestcase{
+			Index:  i,
+			Input:  fmt.Sprintf("%s%02d.in", prefix, i),
+			Output: fmt.Sprintf("%s%02d.out", prefix, i),
+		}
+		if i <= len(ts.Tests) {
+			tc.Subtask = groupIDs[ts.Tests[i-1].Group]
+		}
+		if err := pkg.addFile(tc.Input, input); err != nil {
+			return nil, err
+		}
+		if err := pkg.addFile(tc.Output, answer); err != nil {
+			return nil, err
+		}
+		p.Testcases = append(p.Testcases, tc)
+	}
+	return pkg, nil
+}
+
+// kattisProblem 对应 Kattis 题目包中 problem.yaml 的结构
+type kattisProblem struct {
+	Name       yaml.Node `yaml:"name"` // 字符串，或语言到名称的映射
+	Type       string    `yaml:"type"`
+	Validation string    `yaml:"validation"`
+	Limits     struct {
+		TimeLimit float64 `yaml:"time_limit"` // 秒
+		Memory    uint    `yaml:"memory"`     // MB
+	} `yaml:"limits"`
+}
+
+// kattisStatement 匹配 problem_statement 目录下的题面文件，分组为语言
+var kattisStatement = regexp.MustCompile(`^problem_statement/problem(?:\.([A-Za-z-]+))?\.(tex|md)$`)
+
+// parseKattisPackage 解析 Kattis problemarchive 题目包，依次使用data/sample与data/secret中的测试数据
+func parseKattisPackage(files map[string][]byte, prefix string) (*problemPackage, error) {
+	var desc kattisProblem
+	if err := yaml.Unmarshal(files["problem.yaml"], &desc); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse problem.yaml: %v", ErrInvalidPackage, err)
+	}
+	pkg := &problemPackage{files: make(map[string][]byte)}
+	p := &pkg.problem
+
+	titles := make(map[string]string)
+	switch desc.Name.Kind {
+	case yaml.ScalarNode:
+		titles["en"] = desc.Name.Value
+	case yaml.MappingNode:
+		if err := desc.Name.Decode(&titles); err != nil {
+			return nil, fmt.Errorf("%w: invalid name in problem.yaml: %v", ErrInvalidPackage, err)
+		}
+	}
+	p.Title = titles["en"]
+	if keys := sortedKeys(titles); p.Title == "" && len(keys) > 0 {
+		p.Title = titles[keys[0]]
+	}
+
+	// Kattis 包本身不含短名称，使用英文标题生成文件名前缀
+	prefix = filenamePrefix(prefix, strings.ToLower(p.Title))
+	if p.Title == "" {
+		p.Title = "Untitled"
+	}
+
+	p.Checker = model.CheckerTokens
+	if desc.Validation != "" && desc.Validation != "default" {
+		pkg.warnf("validation %q is not supported, using token comparison", desc.Validation)
+	}
+	if desc.Type == "scoring" {
+		pkg.warnf("scoring problems are imported without subtasks")
+	}
+
+	p.CpuTimeLimit = desc.Limits.TimeLimit
+	if p.CpuTimeLimit == 0 {
+		// problemtools 将计算出的时限写在.timelimit中
+		if data, ok := files[".timelimit"]; ok {
+			p.CpuTimeLimit, _ = strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		}
+	}
+	if p.CpuTimeLimit == 0 {
+		pkg.warnf("time limit is not specified in the package, the language default applies")
+	}
+	p.MemoryLimit = desc.Limits.Memory * 1024
+
+	for _, name := range sortedKeys(files) {
+		m := kattisStatement.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		lang := m[1]
+		if lang == "" {
+			lang = "en"
+		}
+		filename := prefix + "statement_" + lang + "." + m[2]
+		if _, exists := pkg.files[filename]; exists {
+			continue
+		}
+		if err := pkg.addFile(filename, files[name]); err != nil {
+			return nil, err
+		}
+		p.Statements = append(p.Statements, Statement{Language: lang, Title: titles[lang], File: filename})
+	}
+
+	// 测试数据可以位于data/sample与data/secret的多级子目录中，按路径排序
+	p.Testcases = []ProblemTestcase{}
+	for _, group := range []string{"data/sample/", "data/secret/"} {
+		for _, name := range sortedKeys(files) {
+			rel, ok := strings.CutPrefix(name, group)
+			if !ok || !strings.HasSuffix(rel, ".in") {
+				continue
+			}
+			stem := strings.TrimSuffix(name, ".in")
+			answer, ok := files[stem+".ans"]
+			if !ok {
+				return nil, fmt.Errorf("%w: answer file for %s not found", ErrInvalidPackage, name)
+			}
+			flat := strings.TrimSuffix(strings.ReplaceAll(strings.TrimPrefix(name, "data/"), "/", "_"), ".in")
+			tc := ProblemTestcase{
+				Index:  len(p.Testcases) + 1,
+				Input:  prefix + flat + ".in",
+				Output: prefix + flat + ".ans",
+			}
+			if err := pkg.addFile(tc.Input, files[name]); err != nil {
+				return nil, err
+			}
+			if err := pkg.addFile(tc.Output, answer); err != nil {
+				return nil, err
+			}
+			p.Testcases = append(p.Testcases, tc)
+		}
+	}
+	return pkg, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,142 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"nightcord-server/internal/model"
+	"path/filepath"
+	"testing"
+)
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestImportPolygonPackage(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	problemXML := `<?xml version="1.0" encoding="utf-8"?>
+<problem revision="3" short-name="a-plus-b">
+  <names><name language="english" value="A + B"/></names>
+  <statements>
+    <statement charset="UTF-8" language="english" path="statements/.html/english/problem.html" type="text/html"/>
+    <statement language="english" path="statements/.pdf/english/problem.pdf" type="application/pdf"/>
+  </statements>
+  <judging>
+    <testset name="tests">
+      <time-limit>2000</time-limit>
+      <memory-limit>268435456</memory-limit>
+      <test-count>3</test-count>
+      <input-path-pattern>tests/%02d</input-path-pattern>
+      <answer-path-pattern>tests/%02d.a</answer-path-pattern>
+      <tests>
+        <test method="manual" group="samples" sample="true"/>
+        <test method="generated" group="main"/>
+        <test method="generated" group="main"/>
+      </tests>
+      <groups>
+        <group name="samples" points="0" points-policy="complete-group"/>
+        <group name="main" points="100" points-policy="complete-group"/>
+      </groups>
+    </testset>
+  </judging>
+  <assets><checker name="std::wcmp.cpp" type="testlib"/></assets>
+</problem>`
+	data := buildZip(t, map[string]string{
+		"a-plus-b-3$linux/problem.xml":                           problemXML,
+		"a-plus-b-3$linux/statements/.html/english/problem.html": "<p>Sum</p>",
+		"a-plus-b-3$linux/tests/01":                              "1 2\n",
+		"a-plus-b-3$linux/tests/01.a":                            "3\n",
+		"a-plus-b-3$linux/tests/02":                              "2 2\n",
+		"a-plus-b-3$linux/tests/02.a":                            "4\n",
+		"a-plus-b-3$linux/tests/03":                              "5 5\n",
+		"a-plus-b-3$linux/tests/03.a":                            "10\n",
+	})
+
+	result, err := se.ImportProblemPackage(data, "", ImportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to import Polygon package: %v", err)
+	}
+	p, err := se.GetProblem(result.Problem.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "A + B" || p.Source != PackagePolygon || p.Checker != model.CheckerTokens {
+		t.Errorf("Problem mismatch: %+v", p)
+	}
+	if p.CpuTimeLimit != 2 || p.MemoryLimit != 262144 {
+		t.Errorf("Limits mismatch: %v s, %v KB", p.CpuTimeLimit, p.MemoryLimit)
+	}
+	if len(p.Subtasks) != 2 || p.Subtasks[1].Score != 100 {
+		t.Errorf("Subtasks mismatch: %+v", p.Subtasks)
+	}
+	want := ProblemTestcase{Index: 2, Input: "a-plus-b_02.in", Output: "a-plus-b_02.out", Subtask: 2}
+	if len(p.Testcases) != 3 || p.Testcases[1] != want {
+		t.Errorf("Testcases mismatch: %+v", p.Testcases)
+	}
+	if len(p.Statements) != 1 || p.Statements[0].File != "a-plus-b_statement_english.html" {
+		t.Errorf("Statements mismatch: %+v", p.Statements)
+	}
+}
+
+func TestImportKattisPackage(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	data := buildZip(t, map[string]string{
+		"hello/problem.yaml":                  "name: Hello World\nvalidation: custom\nlimits:\n  memory: 512\n",
+		"hello/.timelimit":                    "1.5\n",
+		"hello/problem_statement/problem.tex": "\\problemname{Hello}",
+		"hello/data/sample/1.in":              "",
+		"hello/data/sample/1.ans":             "Hello World!\n",
+		"hello/data/secret/g1/1.in":           "",
+		"hello/data/secret/g1/1.ans":          "Hello World!\n",
+	})
+
+	result, err := se.ImportProblemPackage(data, PackageKattis, ImportOptions{Prefix: "hw_"})
+	if err != nil {
+		t.Fatalf("Failed to import Kattis package: %v", err)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("Expected a warning for the custom validator, got %v", result.Warnings)
+	}
+	p, err := se.GetProblem(result.Problem.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "Hello World" || p.Source != PackageKattis || p.CpuTimeLimit != 1.5 || p.MemoryLimit != 512*1024 {
+		t.Errorf("Problem mismatch: %+v", p)
+	}
+	if len(p.Testcases) != 2 || p.Testcases[0].Input != "hw_sample_1.in" || p.Testcases[1].Output != "hw_secret_g1_1.ans" {
+		t.Errorf("Testcases mismatch: %+v", p.Testcases)
+	}
+	if len(p.Statements) != 1 || p.Statements[0].Language != "en" {
+		t.Errorf("Statements mismatch: %+v", p.Statements)
+	}
+
+	if _, err := se.ImportProblemPackage(buildZip(t, map[string]string{"readme.txt": "x"}), "", ImportOptions{}); err != ErrUnsupportedPackage {
+		t.Errorf("Expected ErrUnsupportedPackage, got %v", err)
+	}
+}
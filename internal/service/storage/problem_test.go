@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"nightcord-server/internal/model"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestProblem(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	for _, name := range []string{"1.in", "1.out", "2.in", "2.out"} {
+		if err := se.WriteFile(name, []byte(name)); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	problem := &Problem{
+		Title:        "A+B",
+		CpuTimeLimit: 1,
+		Checker:      model.CheckerTokens,
+		Subtasks:     []model.Subtask{{ID: 1, Score: 100}},
+		Testcases: []ProblemTestcase{
+			{Input: "1.in", Output: "1.out", Subtask: 1},
+			{Input: "2.in", Output: "2.out", Subtask: 1},
+		},
+	}
+	if err := se.CreateProblem(problem); err != nil {
+		t.Fatalf("Failed to create problem: %v", err)
+	}
+
+	got, err := se.GetProblem(problem.ID)
+	if err != nil {
+		t.Fatalf("Failed to get problem: %v", err)
+	}
+	if got.Title != "A+B" || got.Checker != model.CheckerTokens || len(got.Subtasks) != 1 {
+		t.Errorf("Problem mismatch: %+v", got)
+	}
+	if len(got.Testcases) != 2 || got.Testcases[0].Index != 1 || got.Testcases[1].Input != "2.in" {
+		t.Errorf("Testcases mismatch: %+v", got.Testcases)
+	}
+
+	// 引用不存在的文件或未定义的子任务应被拒绝
+	err = se.SetProblemTestcases(problem.ID, []ProblemTestcase{{Input: "3.in", Output: "3.out"}})
+	if !errors.Is(err, ErrInvalidProblem) {
+		t.Errorf("Expected ErrInvalidProblem for missing file, got %v", err)
+	}
+	_, err = se.AddProblemTestcase(problem.ID, ProblemTestcase{Input: "1.in", Subtask: 2})
+	if !errors.Is(err, ErrInvalidProblem) {
+		t.Errorf("Expected ErrInvalidProblem for undefined subtask, got %v", err)
+	}
+
+	// 替换后顺序按列表重新编号
+	err = se.SetProblemTestcases(problem.ID, []ProblemTestcase{{Input: "2.in", Output: "2.out"}, {Input: "1.in", Output: "1.out"}})
+	if err != nil {
+		t.Fatalf("Failed to set testcases: %v", err)
+	}
+	testcases, err := se.GetProblemTestcases(problem.ID)
+	if err != nil {
+		t.Fatalf("Failed to get testcases: %v", err)
+	}
+	if len(testcases) != 2 || testcases[0].Input != "2.in" || testcases[1].Index != 2 {
+		t.Errorf("Testcases mismatch after replace: %+v", testcases)
+	}
+
+	// 被测试点引用的文件不能删除
+	if err := se.DeleteFile("1.in"); !errors.Is(err, ErrFileInUse) {
+		t.Errorf("Expected ErrFileInUse, got %v", err)
+	}
+	// 测试点的文件名规范化后保存，不能通过./3.in绕过引用检查
+	if err := se.WriteFile("3.in", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := se.AddProblemTestcase(problem.ID, ProblemTestcase{Input: "./3.in"}); err != nil {
+		t.Fatalf("Failed to add testcase: %v", err)
+	}
+	if err := se.DeleteFile("3.in"); !errors.Is(err, ErrFileInUse) {
+		t.Errorf("Expected ErrFileInUse for a normalized filename, got %v", err)
+	}
+
+	if err := se.DeleteProblem(problem.ID); err != nil {
+		t.Fatalf("Failed to delete problem: %v", err)
+	}
+	if _, err := se.GetProblem(problem.ID); !errors.Is(err, ErrProblemNotFound) {
+		t.Errorf("Expected ErrProblemNotFound, got %v", err)
+	}
+	if err := se.DeleteFile("1.in"); err != nil {
+		t.Errorf("Failed to delete file after problem deletion: %v", err)
+	}
+}
+
+func TestProblemConcurrentWithDelete(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	// 创建题目与删除其引用的文件并发执行时，成功创建的题目引用的文件必须仍然存在
+	for i := 0; i < 30; i++ {
+		in, out := fmt.Sprintf("%d.in", i), fmt.Sprintf("%d.out", i)
+		for _, name := range []string{in, out} {
+			if err := se.WriteFile(name, []byte(name)); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+		problem := &Problem{
+			Title:        "A+B",
+			CpuTimeLimit: 1,
+			Checker:      model.CheckerTokens,
+			Subtasks:     []model.Subtask{{ID: 1, Score: 100}},
+			Testcases:    []ProblemTestcase{{Input: in, Output: out, Subtask: 1}},
+		}
+		var createErr, deleteErr error
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			createErr = se.CreateProblem(problem)
+		}()
+		go func() {
+			defer wg.Done()
+			deleteErr = se.DeleteFile(in)
+		}()
+		wg.Wait()
+
+		if createErr == nil && deleteErr == nil {
+			t.Fatalf("Problem %d references deleted file %s", problem.ID, in)
+		}
+		if createErr == nil && !errors.Is(deleteErr, ErrFileInUse) {
+			t.Errorf("Expected ErrFileInUse, got %v", deleteErr)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config S3兼容对象存储的连接配置
+type S3Config struct {
+	Endpoint  string // 服务地址，如 https://s3.amazonaws.com 或 http://127.0.0.1:9000
+	Region    string // 签名使用的区域，为空时使用 us-east-1
+	Bucket    string
+	AccessKey string // 为空时发送匿名请求
+	SecretKey string
+	Prefix    string // 添加在所有对象键前的前缀，多个部署共用一个存储桶时用于区分
+	PathStyle bool   // 使用 endpoint/bucket/key 形式的地址，MinIO等自建服务通常需要开启
+	// 建立连接与等待响应头的超时，为0时使用 s3DefaultTimeout；传输内容的时间不受限制，大文件的上传与下载不会被中断
+	Timeout time.Duration
+}
+
+// s3DefaultTimeout 未配置超时时建立连接与等待响应头的超时
+const s3DefaultTimeout = 30 * time.Second
+
+// s3UnsignedPayload 不对请求体签名，上传时无需预先计算整个内容的哈希
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Backend 将对象存放在S3兼容对象存储中的后端，请求使用AWS Signature V4签名
+type S3Backend struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Backend 创建S3兼容对象存储后端
+func NewS3Backend(config S3Config) (*S3Backend, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket cannot be empty")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = s3DefaultTimeout
+	}
+	// 服务没有响应时请求不会一直阻塞
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: config.Timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = config.Timeout
+	transport.ResponseHeaderTimeout = config.Timeout
+	return &S3Backend{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Transport: transport},
+	}, nil
+}
+
+// url 返回对象的地址，key为空时返回存储桶的地址
+func (b *S3Backend) url(key string) *url.URL {
+	u := *b.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if b.config.PathStyle {
+		u.Path = base + "/" + b.config.Bucket + "/" + key
+	} else {
+		u.Host = b.config.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	return &u
+}
+
+// errNotModified 条件读取时对象没有变化
+var errNotModified = errors.New("not modified")
+
+// do 发送签名后的请求，header为附加的请求头（不参与签名），可以为nil
+// 非2xx的响应转换为错误：404转换为 ErrObjectNotFound，304转换为 errNotModified，412与409转换为 ErrPreconditionFailed
+func (b *S3Backend) do(method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	b.sign(req, time.Now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrObjectNotFound
+	case http.StatusNotModified:
+		return nil, errNotModified
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// 并发的条件写入可能返回409，同样表示需要重新读取后再写入
+		return nil, ErrPreconditionFailed
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3 %s %s: %s: %s", method, u.Path, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func (b *S3Backend) Put(key string, r io.Reader, size int64) error {
+	resp, err := b.do(http.MethodPut, b.url(b.config.Prefix+key), r, size, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3Backend) Get(key string) (io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, b.url(b.config.Prefix+key), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetIfChanged 以If-None-Match条件读取，对象没有变化时服务返回304
+func (b *S3Backend) GetIfChanged(key, etag string) (io.ReadCloser, string, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	resp, err := b.do(http.MethodGet, b.url(b.config.Prefix+key), nil, 0, header)
+	if err == errNotModified {
+		return nil, etag, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, resp.Header.Get("ETag"), nil
+}
+
+// PutIfMatch 以If-Match或If-None-Match条件写入，服务需要支持S3的条件写入
+func (b *S3Backend) PutIfMatch(key string, r io.Reader, size int64, etag string) (string, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	} else {
+		header.Set("If-None-Match", "*")
+	}
+	resp, err := b.do(http.MethodPut, b.url(b.config.Prefix+key), r, size, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	newETag := resp.Header.Get("ETag")
+	if newETag == "" {
+		return "", fmt.Errorf("s3 PUT %s: response has no ETag", key)
+	}
+	return newETag, nil
+}
+
+func (b *S3Backend) Delete(key string) error {
+	resp, err := b.do(http.MethodDelete, b.url(b.config.Prefix+key), nil, 0, nil)
+	if err == ErrObjectNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3Backend) Stat(key string) (*ObjectInfo, error) {
+	resp, err := b.do(http.MethodHead, b.url(b.config.Prefix+key), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	info := &ObjectInfo{Key: key, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+// s3ListResult ListObjectsV2 的响应
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List 使用 ListObjectsV2 分页列出对象
+func (b *S3Backend) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	token := ""
+	for {
+		u := b.url("")
+		query := url.Values{"list-type": {"2"}, "prefix": {b.config.Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = s3Query(query)
+
+		resp, err := b.do(http.MethodGet, u, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse s3 list response: %v", err)
+		}
+		for _, obj := range result.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     strings.TrimPrefix(obj.Key, b.config.Prefix),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// sign 按AWS Signature V4为请求添加签名头，未配置AccessKey时不签名
+func (b *S3Backend) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	if b.config.AccessKey == "" {
+		return
+	}
+	scope, signature := s3Signature(req.Method, req.URL.EscapedPath(), req.URL.Query(), req.URL.Host, amzDate,
+		b.config.Region, b.config.SecretKey)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.config.AccessKey, scope, s3SignedHeaders, signature,
+	))
+}
+
+// s3SignedHeaders 参与签名的请求头，按字母顺序排列
+const s3SignedHeaders = "host;x-amz-content-sha256;x-amz-date"
+
+// s3Signature 计算签名范围与签名，escapedPath为编码后的请求路径
+func s3Signature(method, escapedPath string, query url.Values, host, amzDate, region, secretKey string) (string, string) {
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapedPath,
+		s3Query(query),
+		"host:" + host + "\n" +
+			"x-amz-content-sha256:" + s3UnsignedPayload + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		s3SignedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	date := amzDate[:8]
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Query 按签名要求编码查询参数：按键排序，空格编码为%20而不是+
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape 按RFC 3986编码，只保留非保留字符；encodeSlash为false时保留路径分隔符/
+func s3Escape(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
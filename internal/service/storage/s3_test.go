@@ -0,0 +1,343 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 进程内的S3兼容服务，只支持测试用到的请求，并按相同的算法校验签名
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time // 对象的上传时间，测试可以修改以模拟较早上传的对象
+	gets     int                  // 读取对象内容的次数
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{objects: make(map[string][]byte), modified: make(map[string]time.Time)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, signature := s3Signature(r.Method, r.URL.EscapedPath(), r.URL.Query(), r.Host, r.Header.Get("X-Amz-Date"), "us-east-1", "secret")
+	if !strings.HasSuffix(r.Header.Get("Authorization"), "Signature="+signature) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, exists := f.objects[key]
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		// 条件写入：If-Match要求ETag一致，If-None-Match: * 要求对象不存在
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || match != fakeETag(data)) ||
+			r.Header.Get("If-None-Match") == "*" && exists {
+			http.Error(w, "PreconditionFailed", http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		f.modified[key] = time.Now()
+		w.Header().Set("ETag", fakeETag(body))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		delete(f.modified, key)
+		w.WriteHeader(http.StatusNoContent)
+	case !exists:
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+	case r.Method == http.MethodHead:
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", fakeETag(data))
+		w.Header().Set("Last-Modified", f.modified[key].UTC().Format(http.TimeFormat))
+	case r.Method == http.MethodGet:
+		w.Header().Set("ETag", fakeETag(data))
+		if r.Header.Get("If-None-Match") == fakeETag(data) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		f.gets++
+		w.Write(data)
+	}
+}
+
+// fakeETag 与S3相同，以内容的MD5作为ETag
+func fakeETag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(data))
+}
+
+// list 每页最多返回两个对象，用于测试分页
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix, after := r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token")
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var result struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []struct {
+			Key          string
+			Size         int64
+			LastModified time.Time
+		}
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}
+	for i, key := range keys {
+		if i == 2 {
+			result.IsTruncated = true
+			result.NextContinuationToken = keys[i-1]
+			break
+		}
+		result.Contents = append(result.Contents, struct {
+			Key          string
+			Size         int64
+			LastModified time.Time
+		}{key, int64(len(f.objects[key])), f.modified[key]})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newTestS3Backend(t *testing.T, server *httptest.Server, secret string) *S3Backend {
+	backend, err := NewS3Backend(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "key",
+		SecretKey: secret,
+		Prefix:    "nc/",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create s3 backend: %v", err)
+	}
+	return backend
+}
+
+func TestS3Backend(t *testing.T) {
+	fake, server := newFakeS3(t)
+	backend := newTestS3Backend(t, server, "secret")
+
+	for _, key := range []string{"ab/1", "ab/2", "ab/3", "cd/a b+c"} {
+		if err := backend.Put(key, strings.NewReader("data-"+key), int64(len("data-"+key))); err != nil {
+			t.Fatalf("Put(%q) failed: %v", key, err)
+		}
+	}
+	if _, ok := fake.objects["nc/cd/a b+c"]; !ok {
+		t.Errorf("Object stored under unexpected keys: %v", fake.objects)
+	}
+
+	rc, err := backend.Get("cd/a b+c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "data-cd/a b+c" {
+		t.Errorf("Get returned %q", data)
+	}
+
+	info, err := backend.Stat("ab/1")
+	if err != nil || info.Size != int64(len("data-ab/1")) {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+
+	// 假服务每页只返回两个对象
+	objects, err := backend.List("ab/")
+	if err != nil || len(objects) != 3 || objects[2].Key != "ab/3" {
+		t.Errorf("List = %+v, %v", objects, err)
+	}
+
+	if err := backend.Delete("ab/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Get("ab/1"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound after delete, got %v", err)
+	}
+	if _, err := backend.Stat("ab/1"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound from Stat, got %v", err)
+	}
+
+	// 条件读写
+	etag, err := backend.PutIfMatch("meta", strings.NewReader("v1"), 2, "")
+	if err != nil || etag != fakeETag([]byte("v1")) {
+		t.Fatalf("PutIfMatch on a new object = %q, %v", etag, err)
+	}
+	if _, err := backend.PutIfMatch("meta", strings.NewReader("v2"), 2, ""); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed creating an existing object, got %v", err)
+	}
+	if _, err := backend.PutIfMatch("meta", strings.NewReader("v2"), 2, `"stale"`); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed with a stale etag, got %v", err)
+	}
+	if rc, got, err := backend.GetIfChanged("meta", etag); err != nil || rc != nil || got != etag {
+		t.Errorf("GetIfChanged on an unchanged object = %v, %q, %v", rc, got, err)
+	}
+	if _, err := backend.PutIfMatch("meta", strings.NewReader("v2"), 2, etag); err != nil {
+		t.Fatal(err)
+	}
+	rc, etag2, err := backend.GetIfChanged("meta", etag)
+	if err != nil || rc == nil {
+		t.Fatalf("GetIfChanged on a changed object = %v, %v", rc, err)
+	}
+	data, _ = io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "v2" || etag2 != fakeETag([]byte("v2")) {
+		t.Errorf("GetIfChanged returned %q with etag %q", data, etag2)
+	}
+	if _, _, err := backend.GetIfChanged("missing", ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound from GetIfChanged, got %v", err)
+	}
+
+	if err := newTestS3Backend(t, server, "wrong").Put("x", strings.NewReader(""), 0); err == nil {
+		t.Error("Expected an error for a wrong secret key")
+	}
+}
+
+func TestStorageEngineWithS3(t *testing.T) {
+	fake, server := newFakeS3(t)
+	tempDir := t.TempDir()
+	cached, err := NewCachedBackend(newTestS3Backend(t, server, "secret"), filepath.Join(tempDir, "cache"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err := NewStorageEngineWithBackend(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"), cached)
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	if err := se.WriteFile("p1/1.in", []byte("1 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	metadata, _ := se.GetFileMetadata("p1/1.in")
+	if _, ok := fake.objects["nc/"+blobKey(metadata.Hash)]; !ok {
+		t.Fatalf("Blob was not uploaded to s3: %v", fake.objects)
+	}
+
+	read := func() string {
+		t.Helper()
+		reader, err := se.ReadFile("p1/1.in")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// 上传时已写入缓存
+	if got := read(); got != "1 2\n" || fake.gets != 0 {
+		t.Errorf("Read %q with %d remote gets, want cached content", got, fake.gets)
+	}
+
+	// 缓存丢失后从远端获取一次，之后命中缓存
+	cached.Delete(blobKey(metadata.Hash))
+	fake.objects["nc/"+blobKey(metadata.Hash)] = []byte("1 2\n")
+	read()
+	read()
+	if fake.gets != 1 {
+		t.Errorf("Expected 1 remote get, got %d", fake.gets)
+	}
+
+	if err := se.DeleteFile("p1/1.in"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("Blob was not removed from s3: %v", fake.objects)
+	}
+}
+
+func TestCachedBackendEviction(t *testing.T) {
+	remote, err := NewLocalBackend(filepath.Join(t.TempDir(), "remote"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "cache")
+	cached, err := NewCachedBackend(remote, dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a/1", "a/2", "a/3"} {
+		remote.Put(key, strings.NewReader("12345"), 5)
+		rc, err := cached.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+	}
+	if cached.lru.Size() != 10 || cached.lru.Contains("a/1") {
+		t.Errorf("Expected a/1 to be evicted, size %d", cached.lru.Size())
+	}
+
+	// 超出容量的对象不缓存
+	remote.Put("a/big", strings.NewReader("0123456789ab"), 12)
+	rc, err := cached.Get("a/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(rc); string(data) != "0123456789ab" {
+		t.Errorf("Get returned %q", data)
+	}
+	rc.Close()
+
+	// 重启后恢复已缓存的条目
+	reloaded, err := NewCachedBackend(remote, dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.lru.Size() != 10 || !reloaded.lru.Contains("a/3") {
+		t.Errorf("Cache entries were not reloaded, size %d", reloaded.lru.Size())
+	}
+}
+
+func TestS3BackendTimeout(t *testing.T) {
+	// 服务接受连接但不响应，直到客户端放弃请求
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+	backend, err := NewS3Backend(S3Config{Endpoint: server.URL, Bucket: "bucket", PathStyle: true, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := backend.Stat("ab/1"); err == nil {
+		t.Fatal("Expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Request took %v, expected it to time out", elapsed)
+	}
+}
@@ -0,0 +1,305 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metadataKey 共用后端中存放元数据数据库快照的对象键，与blob的键（两级哈希目录）不会冲突
+const metadataKey = "meta/metadata.db"
+
+// metadataAttempts 发布元数据时与其他节点的写入冲突后最多尝试的次数
+const metadataAttempts = 5
+
+// ErrMetadataConflict 与其他节点的写入持续冲突，多次重试后仍无法发布元数据
+var ErrMetadataConflict = errors.New("shared metadata was modified concurrently by other nodes")
+
+// sharedTables 节点间共用的元数据表，同步时整表替换；sqlite_sequence 保存自增ID的计数，同样需要一致
+var sharedTables = []string{"file_metadata", "file_versions", "blobs", "problems", "problem_testcases", "dir_validators", "sqlite_sequence"}
+
+// initSharedTables 初始化记录本地数据库与共用元数据同步状态的表，该表不在节点间同步
+func (se *StorageEngine) initSharedTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS shared_state (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	`
+	_, err := se.db.Exec(query)
+	return err
+}
+
+// ShareMetadata 与使用同一后端的其他节点共用元数据（文件、版本、题目与校验程序），后端需要支持条件读写
+// 元数据以数据库快照的形式保存在后端的 metadataKey 中：每次写入前同步其他节点的写入，写入后以条件写入发布，
+// 期间其他节点已发布新的快照时放弃本地的写入并重试，因此所有节点看到同一份元数据
+// 读取只使用本地数据库，其他节点的写入在下一次写入、Sync 或定期同步之后可见
+// 后端中还没有元数据时以本节点的数据库为初始内容；本地已有从未共用过的数据而后端中已有元数据时返回错误，避免覆盖任何一方
+func (se *StorageEngine) ShareMetadata() error {
+	meta, ok := se.remoteBackend().(conditionalBackend)
+	if !ok {
+		return errors.New("storage backend does not support conditional writes required for sharing metadata")
+	}
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	err := se.db.QueryRow("SELECT value FROM shared_state WHERE key = 'etag'").Scan(&se.metaETag)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	se.meta = meta
+
+	if se.metaETag == "" {
+		var exists bool
+		if err := se.db.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM file_metadata) OR EXISTS (SELECT 1 FROM problems) OR EXISTS (SELECT 1 FROM dir_validators)",
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			if _, err := se.remoteBackend().Stat(metadataKey); err == nil {
+				return fmt.Errorf("local metadata was never shared and the storage backend already has metadata from other nodes")
+			} else if !errors.Is(err, ErrObjectNotFound) {
+				return err
+			}
+		}
+	}
+	if err := se.syncLocked(); err != nil {
+		se.meta = nil
+		return err
+	}
+	return nil
+}
+
+// shared 返回元数据与内容是否与其他节点共用
+func (se *StorageEngine) shared() bool {
+	return se.meta != nil
+}
+
+// remoteBackend 返回去掉本地读缓存的后端，共用的元数据会被其他节点更新，不能从缓存读取
+func (se *StorageEngine) remoteBackend() Backend {
+	if cached, ok := se.backend.(*CachedBackend); ok {
+		return cached.Backend
+	}
+	return se.backend
+}
+
+// Sync 同步其他节点发布的元数据，不共用元数据时不做处理
+// 评测前调用，刚在其他节点上传的文件或创建的题目也能评测
+func (se *StorageEngine) Sync() error {
+	if !se.shared() {
+		return nil
+	}
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.syncLocked()
+}
+
+// syncLoop 定期同步其他节点发布的元数据
+func (se *StorageEngine) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := se.Sync(); err != nil {
+			log.Printf("Failed to sync shared metadata: %v", err)
+		}
+	}
+}
+
+// update 执行一次元数据写入，调用者需持有 se.mu
+// 共用元数据时先同步再执行fn，随后发布本地的数据库；发布前其他节点已发布新的元数据时放弃本地的写入，重新同步后再次执行fn，
+// 因此fn需要在内部完成写入前的全部检查，且除数据库以外没有副作用
+func (se *StorageEngine) update(fn func() error) error {
+	if !se.shared() {
+		return fn()
+	}
+	for attempt := 0; attempt < metadataAttempts; attempt++ {
+		if err := se.syncLocked(); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil {
+			err = se.publishLocked()
+		}
+		if err == nil {
+			return nil
+		}
+
+		// 本地的数据库可能已有未发布的写入，重新加载后端中的元数据将其丢弃
+		se.metaETag = ""
+		if !errors.Is(err, ErrPreconditionFailed) {
+			if syncErr := se.syncLocked(); syncErr != nil {
+				log.Printf("Failed to discard unpublished metadata changes: %v", syncErr)
+			}
+			return err
+		}
+	}
+	return ErrMetadataConflict
+}
+
+// syncLocked 后端中的元数据与本地最近一次同步的版本不同时加载到本地数据库，调用者需持有 se.mu
+// 后端中没有元数据时（首个节点或存储桶被清空）发布本地的数据库
+func (se *StorageEngine) syncLocked() error {
+	for attempt := 0; attempt < metadataAttempts; attempt++ {
+		rc, etag, err := se.meta.GetIfChanged(metadataKey, se.metaETag)
+		if errors.Is(err, ErrObjectNotFound) {
+			se.metaETag = ""
+			if err := se.publishLocked(); !errors.Is(err, ErrPreconditionFailed) {
+				return err
+			}
+			continue // 其他节点同时发布了初始内容
+		}
+		if err != nil {
+			return err
+		}
+		if rc == nil {
+			return nil
+		}
+		err = se.loadMetadata(rc, etag)
+		rc.Close()
+		return err
+	}
+	return ErrMetadataConflict
+}
+
+// publishLocked 将本地数据库的快照以条件写入发布到后端，调用者需持有 se.mu
+// 后端中的元数据不是本地最近一次同步的版本时返回 ErrPreconditionFailed
+func (se *StorageEngine) publishLocked() error {
+	dir, err := os.MkdirTemp(filepath.Join(se.storeDir, stagingDir), "metadata-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// VACUUM INTO 生成一致的快照，不受其他连接上进行中的读取影响
+	snapshot := filepath.Join(dir, "metadata.db")
+	if _, err := se.db.Exec("VACUUM INTO ?", snapshot); err != nil {
+		return fmt.Errorf("failed to snapshot metadata: %v", err)
+	}
+	file, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	etag, err := se.meta.PutIfMatch(metadataKey, file, info.Size(), se.metaETag)
+	if err != nil {
+		return err
+	}
+	se.metaETag = etag
+	return saveMetaETag(se.db, etag)
+}
+
+// loadMetadata 用后端中的快照替换本地数据库中的共用表，在同一个事务中完成，读取不会看到一半的内容
+// 按列名复制，本地数据库因迁移而列顺序不同时同样适用
+func (se *StorageEngine) loadMetadata(r io.Reader, etag string) error {
+	dir, err := os.MkdirTemp(filepath.Join(se.storeDir, stagingDir), "metadata-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "metadata.db")
+	file, err := os.Create(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download metadata: %v", err)
+	}
+
+	// ATTACH只对当前连接有效，复制需要在同一个连接上进行
+	ctx := context.Background()
+	conn, err := se.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS snapshot", snapshot); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE snapshot")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range sharedTables {
+		columns, err := tableColumns(tx, table)
+		if err != nil {
+			return err
+		}
+		list := strings.Join(columns, ", ")
+		if _, err := tx.Exec("DELETE FROM main." + table); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO main." + table + " (" + list + ") SELECT " + list + " FROM snapshot." + table); err != nil {
+			return fmt.Errorf("failed to load shared table %s: %v", table, err)
+		}
+	}
+	// path列是文件在发布该快照的节点的存储目录下的路径，各节点的存储目录可以不同，按本节点重新生成
+	prefix := strings.TrimSuffix(filepath.Join(se.storeDir, "_"), "_")
+	if _, err := tx.Exec("UPDATE main.file_metadata SET path = ? || filename WHERE path != ? || filename", prefix, prefix); err != nil {
+		return err
+	}
+	if err := saveMetaETag(tx, etag); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	se.metaETag = etag
+	return nil
+}
+
+// tableColumns 返回本地数据库中表的列名
+func tableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?, 'main')", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+	return columns, nil
+}
+
+// execer *sql.DB 与 *sql.Tx 共有的执行语句的方法
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// saveMetaETag 记录本地数据库对应的后端元数据版本，重启后据此判断本地数据库是否已与其他节点共用
+func saveMetaETag(db execer, etag string) error {
+	_, err := db.Exec("INSERT INTO shared_state (key, value) VALUES ('etag', ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", etag)
+	return err
+}
@@ -0,0 +1,187 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newSharedNode 创建一个节点，各节点有自己的数据库与暂存目录，共用同一个S3存储桶
+func newSharedNode(t *testing.T, server *httptest.Server, dir string) *StorageEngine {
+	t.Helper()
+	se, err := NewStorageEngineWithBackend(filepath.Join(dir, "files"), filepath.Join(dir, "test.db"), newTestS3Backend(t, server, "secret"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	t.Cleanup(func() { se.Close() })
+	if err := se.ShareMetadata(); err != nil {
+		t.Fatalf("ShareMetadata failed: %v", err)
+	}
+	return se
+}
+
+func readString(t *testing.T, se *StorageEngine, filename string) string {
+	t.Helper()
+	reader, err := se.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%q) failed: %v", filename, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSharedMetadata(t *testing.T) {
+	fake, server := newFakeS3(t)
+	tempDir := t.TempDir()
+	a := newSharedNode(t, server, filepath.Join(tempDir, "a"))
+	b := newSharedNode(t, server, filepath.Join(tempDir, "b"))
+
+	// 在A上传文件并创建题目，B同步后可以读取
+	if err := a.WriteFile("p1/1.in", []byte("1 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WriteFile("p1/1.out", []byte("3\n")); err != nil {
+		t.Fatal(err)
+	}
+	p := &Problem{Title: "A+B", Testcases: []ProblemTestcase{{Input: "p1/1.in", Output: "p1/1.out"}}}
+	if err := a.CreateProblem(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["nc/"+metadataKey]; !ok {
+		t.Fatalf("Metadata was not published: %v", fake.objects)
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, b, "p1/1.in"); got != "1 2\n" {
+		t.Errorf("Node b read %q", got)
+	}
+	got, err := b.GetProblem(p.ID)
+	if err != nil || got.Title != "A+B" || len(got.Testcases) != 1 || got.Testcases[0].Output != "p1/1.out" {
+		t.Fatalf("Node b GetProblem = %+v, %v", got, err)
+	}
+
+	// B的写入在写入前同步，不会覆盖A的写入，版本号在各节点间连续
+	if err := b.WriteFile("p1/1.in", []byte("2 3\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := a.GetFileMetadata("p1/1.in")
+	if err != nil || metadata.Version != 2 {
+		t.Fatalf("Node a metadata = %+v, %v, want version 2", metadata, err)
+	}
+	if got := readString(t, a, "p1/1.in"); got != "2 3\n" {
+		t.Errorf("Node a read %q", got)
+	}
+
+	// 被其他节点的题目引用的文件不能删除
+	if err := b.DeleteFile("p1/1.out"); !errors.Is(err, ErrFileInUse) {
+		t.Errorf("Expected ErrFileInUse, got %v", err)
+	}
+	if err := b.DeleteProblem(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteFile("p1/1.out"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetFileMetadata("p1/1.out"); err == nil {
+		t.Error("File deleted on node a is still visible on node b")
+	}
+}
+
+func TestSharedMetadataConflict(t *testing.T) {
+	_, server := newFakeS3(t)
+	tempDir := t.TempDir()
+	a := newSharedNode(t, server, filepath.Join(tempDir, "a"))
+	b := newSharedNode(t, server, filepath.Join(tempDir, "b"))
+
+	// B同步之后、发布之前A发布了新的元数据，B放弃本地的写入，同步后重新执行
+	attempts := 0
+	b.mu.Lock()
+	err := b.update(func() error {
+		attempts++
+		if attempts == 1 {
+			if err := a.WriteFile("a.in", []byte("a")); err != nil {
+				return err
+			}
+		}
+		_, err := b.db.Exec("INSERT INTO dir_validators (dir, validator) VALUES ('b', '{}')")
+		return err
+	})
+	b.mu.Unlock()
+	if err != nil || attempts != 2 {
+		t.Fatalf("update = %v after %d attempts, want success after 2", err, attempts)
+	}
+	if got := readString(t, b, "a.in"); got != "a" {
+		t.Errorf("Node b read %q", got)
+	}
+
+	// 两个节点的写入都保留
+	if err := a.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GetDirValidator("b"); err != nil {
+		t.Errorf("Node a cannot see the write of node b: %v", err)
+	}
+
+	// 写入失败时本地未发布的修改被丢弃
+	b.mu.Lock()
+	err = b.update(func() error {
+		if _, err := b.db.Exec("INSERT INTO dir_validators (dir, validator) VALUES ('c', '{}')"); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	b.mu.Unlock()
+	if err == nil {
+		t.Fatal("Expected the error from fn")
+	}
+	if _, err := b.GetDirValidator("c"); !errors.Is(err, ErrValidatorNotFound) {
+		t.Errorf("Expected the failed write to be discarded, got %v", err)
+	}
+}
+
+func TestShareMetadataExistingData(t *testing.T) {
+	_, server := newFakeS3(t)
+	tempDir := t.TempDir()
+	a := newSharedNode(t, server, filepath.Join(tempDir, "a"))
+	if err := a.WriteFile("a.in", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 本地已有从未共用过的数据，而存储桶中已有其他节点的元数据
+	dir := filepath.Join(tempDir, "c")
+	c, err := NewStorageEngineWithBackend(filepath.Join(dir, "files"), filepath.Join(dir, "test.db"), newTestS3Backend(t, server, "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WriteFile("c.in", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ShareMetadata(); err == nil || !strings.Contains(err.Error(), "never shared") {
+		t.Errorf("Expected an error sharing unshared local data, got %v", err)
+	}
+
+	// 重启后沿用已共用的数据库
+	a.Close()
+	a = newSharedNode(t, server, filepath.Join(tempDir, "a"))
+	if got := readString(t, a, "a.in"); got != "a" {
+		t.Errorf("Read %q after restart", got)
+	}
+}
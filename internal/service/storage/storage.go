@@ -4,12 +4,20 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"nightcord-server/utils"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -20,25 +28,53 @@ import (
 type FileMetadata struct {
 	ID          int64     `json:"id"`
 	Filename    string    `json:"filename"`
-	Path        string    `json:"path"`
+	Path        string    `json:"path"` // 逻辑路径（存储目录与文件名拼接），内容按哈希存放在blobs目录下
 	Size        int64     `json:"size"`
+	Hash        string    `json:"hash"`    // 内容的SHA-256，用作下载时的ETag
+	Version     int64     `json:"version"` // 当前版本号，每次内容或类型变化时加1
 	ContentType string    `json:"content_type"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// 当前内容的校验状态与校验信息，取值见 ValidationValid 等常量，没有适用的校验程序时为空
+	ValidationStatus  string `json:"validation_status,omitempty"`
+	ValidationMessage string `json:"validation_message,omitempty"`
 }
 
 // StorageEngine 存储引擎结构
+// 文件内容按SHA-256去重存放，文件名到哈希的映射与blob的引用计数保存在SQLite中
 type StorageEngine struct {
-	db       *sql.DB
-	storeDir string
+	db        *sql.DB
+	storeDir  string             // 本地存储目录，用于暂存上传中的内容，使用本地后端时blob也存放在这里
+	backend   Backend            // 存放blob内容的后端
+	validate  ValidateFunc       // 运行校验程序，为nil时上传不做校验
+	meta      conditionalBackend // 与其他节点共用元数据时保存元数据的后端，见 ShareMetadata
+	metaETag  string             // 本地数据库对应的后端元数据版本
+	retention VersionRetention   // 历史版本的保留策略，见 PruneVersions
+	mu        sync.Mutex         // 串行化写入与删除，保证引用计数与blob文件一致、题目不会引用已删除的文件
 }
 
-// NewStorageEngine 创建新的存储引擎实例
+// NewStorageEngine 创建新的存储引擎实例，blob存放在存储目录下的本地后端中
 func NewStorageEngine(storeDir, dbPath string) (*StorageEngine, error) {
-	// 确保存储目录存在
+	backend, err := NewLocalBackend(filepath.Join(storeDir, blobDir))
+	if err != nil {
+		return nil, err
+	}
+	return NewStorageEngineWithBackend(storeDir, dbPath, backend)
+}
+
+// NewStorageEngineWithBackend 创建使用指定后端存放blob的存储引擎实例
+func NewStorageEngineWithBackend(storeDir, dbPath string, backend Backend) (*StorageEngine, error) {
+	// 确保存储目录存在，清理上次退出时未完成的上传
 	if err := os.MkdirAll(storeDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create store directory: %v", err)
 	}
+	staging := filepath.Join(storeDir, stagingDir)
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, fmt.Errorf("failed to clean staging directory: %v", err)
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
 
 	// 打开SQLite数据库
 	db, err := sql.Open("sqlite3", dbPath)
@@ -49,6 +85,7 @@ func NewStorageEngine(storeDir, dbPath string) (*StorageEngine, error) {
 	se := &StorageEngine{
 		db:       db,
 		storeDir: storeDir,
+		backend:  backend,
 	}
 
 	// 初始化数据库表
@@ -68,116 +105,353 @@ func (se *StorageEngine) initDB() error {
 		path TEXT NOT NULL UNIQUE,
 		size INTEGER NOT NULL,
 		content_type TEXT NOT NULL,
+		hash TEXT NOT NULL DEFAULT '',
+		version INTEGER NOT NULL DEFAULT 0,
+		validation_status TEXT NOT NULL DEFAULT '',
+		validation_message TEXT NOT NULL DEFAULT '',
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	`
-	_, err := se.db.Exec(query)
-	return err
+	if _, err := se.db.Exec(query); err != nil {
+		return err
+	}
+	if err := se.migrateFileMetadata(); err != nil {
+		return err
+	}
+	// 文件列表按名称前缀、修改时间与大小过滤和排序
+	indexes := `
+	CREATE INDEX IF NOT EXISTS idx_file_metadata_filename ON file_metadata(filename);
+	CREATE INDEX IF NOT EXISTS idx_file_metadata_updated_at ON file_metadata(updated_at);
+	CREATE INDEX IF NOT EXISTS idx_file_metadata_size ON file_metadata(size);
+	`
+	if _, err := se.db.Exec(indexes); err != nil {
+		return err
+	}
+	if err := se.initBlobTables(); err != nil {
+		return err
+	}
+	if err := se.migrateLegacyFiles(); err != nil {
+		return err
+	}
+	if err := se.initVersionTables(); err != nil {
+		return err
+	}
+	if err := se.initProblemTables(); err != nil {
+		return err
+	}
+	if err := se.initValidationTables(); err != nil {
+		return err
+	}
+	return se.initSharedTables()
 }
 
-// isTestcaseFile 检查文件是否为测试用例文件
-func (se *StorageEngine) isTestcaseFile(content []byte) bool {
-	// 对于测试用例文件，只检查是否为有效的UTF-8编码
-	// 不关心控制字符，因为测试用例可能包含各种特殊字符
-	if !utf8.Valid(content) {
-		return false
+// fileMetadataColumns 在最初的 file_metadata 表之后加入的列
+var fileMetadataColumns = []struct{ name, definition string }{
+	{"hash", "TEXT NOT NULL DEFAULT ''"},
+	{"version", "INTEGER NOT NULL DEFAULT 0"},
+	{"validation_status", "TEXT NOT NULL DEFAULT ''"},
+	{"validation_message", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// migrateFileMetadata 为旧版本创建的 file_metadata 表补充缺少的列
+func (se *StorageEngine) migrateFileMetadata() error {
+	rows, err := se.db.Query("SELECT name FROM pragma_table_info('file_metadata')")
+	if err != nil {
+		return err
 	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, column := range fileMetadataColumns {
+		if existing[column.name] {
+			continue
+		}
+		if _, err := se.db.Exec(fmt.Sprintf("ALTER TABLE file_metadata ADD COLUMN %s %s", column.name, column.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return true
+// maxFilenameLength 文件名（含目录）的最大长度，每一级名称另外限制为255
+const maxFilenameLength = 1024
+
+var (
+	// ErrInvalidFilename 文件名不合法，或与已有的文件、目录冲突
+	ErrInvalidFilename = errors.New("invalid filename")
+	// ErrNotTestcaseFile 文本类型的文件内容不是有效的UTF-8
+	ErrNotTestcaseFile = errors.New("only testcase files are allowed")
+	// ErrFileTooLarge 写入的内容超过大小限制
+	ErrFileTooLarge = errors.New("file is too large")
+)
+
+// CleanFilename 校验并规范化文件名，支持以/分隔的多级目录（如 problem/subtask/1.in）
+// 拒绝绝对路径、..以及各级名称中的非法字符，防止路径穿越
+func CleanFilename(filename string) (string, error) {
+	clean, err := utils.CleanRelativePath(filename)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidFilename, err)
+	}
+	if len(clean) > maxFilenameLength {
+		return "", fmt.Errorf("%w: %q is too long", ErrInvalidFilename, filename)
+	}
+	for _, part := range strings.Split(clean, "/") {
+		if len(part) > 255 || strings.ContainsAny(part, ":*?\"<>|") {
+			return "", fmt.Errorf("%w: %q", ErrInvalidFilename, filename)
+		}
+	}
+	return clean, nil
 }
 
-// WriteFile 写入文件（新建或修改）
-func (se *StorageEngine) WriteFile(filename string, content []byte) error {
-	// 检查是否为测试用例文件
-	if !se.isTestcaseFile(content) {
-		return fmt.Errorf("only testcase files are allowed")
+// IsValidFilename 验证文件名是否有效且已经是规范形式
+func IsValidFilename(filename string) bool {
+	clean, err := CleanFilename(filename)
+	return err == nil && clean == filename
+}
+
+// filePath 规范化文件名并返回其逻辑路径
+func (se *StorageEngine) filePath(filename string) (string, string, error) {
+	clean, err := CleanFilename(filename)
+	if err != nil {
+		return "", "", err
 	}
+	return clean, filepath.Join(se.storeDir, clean), nil
+}
+
+// checkPathConflict 检查文件名是否与已有的目录或文件冲突，如存在 a/b 时不能再创建文件 a，反之亦然
+func (se *StorageEngine) checkPathConflict(filename string) error {
+	var existing string
+	cond, args := prefixCondition("filename", filename+"/")
+	err := se.db.QueryRow("SELECT filename FROM file_metadata WHERE "+cond+" LIMIT 1", args...).Scan(&existing)
+	if err == nil {
+		return fmt.Errorf("%w: %q is a directory", ErrInvalidFilename, filename)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	for dir := path.Dir(filename); dir != "."; dir = path.Dir(dir) {
+		err := se.db.QueryRow("SELECT filename FROM file_metadata WHERE filename = ?", dir).Scan(&existing)
+		if err == nil {
+			return fmt.Errorf("%w: %q is a file", ErrInvalidFilename, dir)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixCondition 返回列以prefix开头的查询条件及其参数
+// SQLite的LIKE不区分ASCII字母的大小写，而文件名区分大小写，因此以substr比较前缀
+func prefixCondition(column, prefix string) (string, []any) {
+	return fmt.Sprintf("substr(%s, 1, length(?)) = ?", column), []any{prefix, prefix}
+}
 
+// isTestcaseFile 检查内容是否符合测试用例文件的内容类型
+// 文本类型只检查是否为有效的UTF-8编码，不关心控制字符，因为测试用例可能包含各种特殊字符；二进制类型不做检查
+func (se *StorageEngine) isTestcaseFile(contentType string, content []byte) bool {
+	return !IsTextContentType(contentType) || utf8.Valid(content)
+}
+
+// WriteOptions 写入文件的选项
+type WriteOptions struct {
+	ContentType string // 内容类型，为空时沿用已有文件的类型，新文件根据扩展名推断
+	MaxSize     int64  // 最大字节数，0表示不限制
+}
+
+// WriteFile 写入文件（新建或修改），内容相同的文件共享同一个blob
+func (se *StorageEngine) WriteFile(filename string, content []byte) error {
+	_, err := se.WriteStream(context.Background(), filename, bytes.NewReader(content), WriteOptions{})
+	return err
+}
+
+// WriteStream 将内容流式写入临时文件并计算哈希，校验通过后原子地重命名为blob，不会将整个文件读入内存
+// ctx用于取消校验程序的编译与运行，通常为上传请求的上下文
+func (se *StorageEngine) WriteStream(ctx context.Context, filename string, r io.Reader, opts WriteOptions) (*FileMetadata, error) {
 	// 构建文件路径
-	filePath := filepath.Join(se.storeDir, filename)
+	filename, filePath, err := se.filePath(filename)
+	if err != nil {
+		return nil, err
+	}
+	// 路径冲突的写入必然失败，在运行校验程序之前检查；加锁后再次检查以排除并发的写入
+	if err := se.checkPathConflict(filename); err != nil {
+		return nil, err
+	}
 
-	// 确保目录存在
-	dir := filepath.Dir(filePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+	contentType := opts.ContentType
+	if contentType == "" {
+		if metadata, err := se.GetFileMetadata(filename); err == nil {
+			contentType = metadata.ContentType
+		} else {
+			contentType = se.GetContentType(filename)
+		}
 	}
 
-	// 写入文件
-	if err := os.WriteFile(filePath, content, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
+	// 写入临时文件，文本类型的内容必须是有效的UTF-8
+	staged, err := se.stageBlob(r, opts.MaxSize, IsTextContentType(contentType))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(staged.path) // 重命名成功后为空操作
+
+	// 在加锁前运行校验程序，编译与运行期间不阻塞其他写入
+	validation, err := se.runValidators(ctx, filename, func() (io.ReadCloser, error) {
+		return os.Open(staged.path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if validation != nil && validation.Status == ValidationInvalid && validation.Reject {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTestcase, validation.Message)
+	}
+
+	// 共用后端中的blob不会因引用归零而同步删除，可以在加锁前上传，慢速上传不阻塞其他写入
+	// 本地后端的blob在持有锁时删除，重命名同样需要在锁内进行
+	if se.shared() {
+		if err := se.commitBlob(staged); err != nil {
+			return nil, err
+		}
 	}
 
-	// 更新数据库元数据
-	return se.updateMetadata(filename, filePath, int64(len(content)))
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	err = se.update(func() error {
+		if err := se.checkPathConflict(filename); err != nil {
+			return err
+		}
+
+		// 重命名为blob文件
+		if !se.shared() {
+			if err := se.commitBlob(staged); err != nil {
+				return err
+			}
+		}
+
+		// 更新数据库元数据、版本与引用计数
+		if err := se.updateMetadata(filename, filePath, staged.hash, contentType, staged.size); err != nil {
+			return err
+		}
+		return se.setValidation(filePath, staged.hash, validation)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return se.GetFileMetadata(filename)
 }
 
-// updateMetadata 更新文件元数据
-func (se *StorageEngine) updateMetadata(filename, path string, size int64) error {
-	// 检查文件是否已存在
-	var exists bool
-	err := se.db.QueryRow("SELECT EXISTS(SELECT 1 FROM file_metadata WHERE path = ?)", path).Scan(&exists)
+// updateMetadata 更新文件元数据，内容或类型变化时追加一个新版本并增加blob的引用计数，同时清除原来的校验结果
+// 旧版本仍然引用原来的blob，因此覆盖写入不会释放任何blob
+func (se *StorageEngine) updateMetadata(filename, path, hash, contentType string, size int64) error {
+	tx, err := se.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	// 检查文件是否已存在
+	var id int64
+	var oldHash, oldContentType string
+	err = tx.QueryRow("SELECT id, hash, content_type FROM file_metadata WHERE path = ?", path).Scan(&id, &oldHash, &oldContentType)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if exists && oldHash == hash && oldContentType == contentType {
+		return nil
+	}
 
 	if exists {
 		// 更新现有记录
-		_, err = se.db.Exec(
-			"UPDATE file_metadata SET size = ?, updated_at = CURRENT_TIMESTAMP WHERE path = ?",
-			size, path,
+		_, err = tx.Exec(
+			"UPDATE file_metadata SET size = ?, hash = ?, content_type = ?, version = version + 1, validation_status = '', validation_message = '', updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+			size, hash, contentType, id,
 		)
 	} else {
 		// 插入新记录
-		_, err = se.db.Exec(
-			"INSERT INTO file_metadata (filename, path, size, content_type) VALUES (?, ?, ?, ?)",
-			filename, path, size, "text/plain",
+		var res sql.Result
+		res, err = tx.Exec(
+			"INSERT INTO file_metadata (filename, path, size, content_type, hash, version) VALUES (?, ?, ?, ?, ?, 1)",
+			filename, path, size, contentType, hash,
 		)
+		if err == nil {
+			id, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
 	}
 
-	return err
+	if err := recordVersion(tx, id); err != nil {
+		return err
+	}
+	if err := refBlob(tx, hash, size); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// ReadFile 读取文件并返回Reader接口
+// ReadFile 读取文件并返回Reader接口，读取到末尾时校验内容的哈希，不一致时返回 ErrBlobCorrupted
 func (se *StorageEngine) ReadFile(filename string) (io.ReadCloser, error) {
-	filePath := filepath.Join(se.storeDir, filename)
-
-	// 检查文件是否存在于数据库中
-	var exists bool
-	err := se.db.QueryRow("SELECT EXISTS(SELECT 1 FROM file_metadata WHERE path = ?)", filePath).Scan(&exists)
+	_, filePath, err := se.filePath(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	if !exists {
+	// 从数据库中查找文件内容的哈希
+	var hash string
+	err = se.db.QueryRow("SELECT hash FROM file_metadata WHERE path = ?", filePath).Scan(&hash)
+	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("file not found in storage")
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	// 打开文件
-	file, err := os.Open(filePath)
+	// 从后端读取blob
+	file, err := se.backend.Get(blobKey(hash))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %v", err)
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 
-	return file, nil
+	return &verifyingReader{file: file, hash: sha256.New(), want: hash}, nil
 }
 
 // GetFileMetadata 获取文件元数据
 func (se *StorageEngine) GetFileMetadata(filename string) (*FileMetadata, error) {
-	filePath := filepath.Join(se.storeDir, filename)
+	_, filePath, err := se.filePath(filename)
+	if err != nil {
+		return nil, err
+	}
 
 	var metadata FileMetadata
-	err := se.db.QueryRow(
-		"SELECT id, filename, path, size, content_type, created_at, updated_at FROM file_metadata WHERE path = ?",
+	err = se.db.QueryRow(
+		"SELECT id, filename, path, size, hash, version, content_type, created_at, updated_at, validation_status, validation_message FROM file_metadata WHERE path = ?",
 		filePath,
 	).Scan(
 		&metadata.ID,
 		&metadata.Filename,
 		&metadata.Path,
 		&metadata.Size,
+		&metadata.Hash,
+		&metadata.Version,
 		&metadata.ContentType,
 		&metadata.CreatedAt,
 		&metadata.UpdatedAt,
+		&metadata.ValidationStatus,
+		&metadata.ValidationMessage,
 	)
 
 	if err != nil {
@@ -190,17 +464,104 @@ func (se *StorageEngine) GetFileMetadata(filename string) (*FileMetadata, error)
 	return &metadata, nil
 }
 
-// ListFiles 列出所有文件
-func (se *StorageEngine) ListFiles() ([]*FileMetadata, error) {
+// ErrInvalidListOptions 列出文件时的过滤或排序选项无效
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// listSortColumns 列出文件时允许的排序字段
+var listSortColumns = map[string]string{
+	"":           "filename",
+	"filename":   "filename",
+	"size":       "size",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// ListOptions 列出文件时的过滤、排序与分页选项，零值表示不过滤
+type ListOptions struct {
+	Prefix        string    // 文件名前缀，以/结尾时表示目录，如 "p1/"
+	Recursive     bool      // 为false时只列出前缀下一级的文件，更深的文件归并为目录
+	ContentType   string    // 内容类型
+	MinSize       int64     // 最小字节数
+	MaxSize       int64     // 最大字节数，0表示不限制
+	UpdatedAfter  time.Time // 修改时间不早于该时间
+	UpdatedBefore time.Time // 修改时间早于该时间
+	SortBy        string    // 排序字段：filename（默认）、size、created_at、updated_at
+	Desc          bool      // 是否降序
+	Limit         int       // 最多返回的文件数，0表示不限制
+	Offset        int
+}
+
+// FileList 列出文件的结果
+type FileList struct {
+	Files []*FileMetadata `json:"files"`
+	Dirs  []string        `json:"dirs,omitempty"` // 前缀下一级的目录（以/结尾），仅非递归列出时返回
+	Total int             `json:"total"`          // 分页前匹配的文件总数
+}
+
+// ListFiles 按条件列出文件，过滤、排序与分页均在SQL中完成
+func (se *StorageEngine) ListFiles(opts ListOptions) (*FileList, error) {
+	column, ok := listSortColumns[opts.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidListOptions, opts.SortBy)
+	}
+	if opts.MinSize < 0 || opts.MaxSize < 0 || (opts.MaxSize > 0 && opts.MinSize > opts.MaxSize) {
+		return nil, fmt.Errorf("%w: invalid size range", ErrInvalidListOptions)
+	}
+
+	// 前缀之后的部分不含/即为下一级的文件
+	where, args := prefixCondition("filename", opts.Prefix)
+	offset := utf8.RuneCountInString(opts.Prefix) + 1
+	if !opts.Recursive {
+		where += " AND instr(substr(filename, ?), '/') = 0"
+		args = append(args, offset)
+	}
+	if opts.ContentType != "" {
+		where += " AND content_type = ?"
+		args = append(args, opts.ContentType)
+	}
+	if opts.MinSize > 0 {
+		where += " AND size >= ?"
+		args = append(args, opts.MinSize)
+	}
+	if opts.MaxSize > 0 {
+		where += " AND size <= ?"
+		args = append(args, opts.MaxSize)
+	}
+	// 时间以SQLite CURRENT_TIMESTAMP的格式（UTC）比较
+	if !opts.UpdatedAfter.IsZero() {
+		where += " AND updated_at >= ?"
+		args = append(args, opts.UpdatedAfter.UTC().Format(time.DateTime))
+	}
+	if !opts.UpdatedBefore.IsZero() {
+		where += " AND updated_at < ?"
+		args = append(args, opts.UpdatedBefore.UTC().Format(time.DateTime))
+	}
+	order := column
+	if opts.Desc {
+		order += " DESC, id DESC"
+	} else {
+		order += ", id"
+	}
+
+	result := &FileList{Files: []*FileMetadata{}}
+	if err := se.db.QueryRow("SELECT COUNT(*) FROM file_metadata WHERE "+where, args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = -1
+	}
 	rows, err := se.db.Query(
-		"SELECT id, filename, path, size, content_type, created_at, updated_at FROM file_metadata ORDER BY created_at DESC",
+		"SELECT id, filename, path, size, hash, version, content_type, created_at, updated_at, validation_status, validation_message FROM file_metadata WHERE "+where+
+			" ORDER BY "+order+" LIMIT ? OFFSET ?",
+		append(args, limit, max(opts.Offset, 0))...,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var files []*FileMetadata
 	for rows.Next() {
 		var metadata FileMetadata
 		err := rows.Scan(
@@ -208,37 +569,166 @@ func (se *StorageEngine) ListFiles() ([]*FileMetadata, error) {
 			&metadata.Filename,
 			&metadata.Path,
 			&metadata.Size,
+			&metadata.Hash,
+			&metadata.Version,
 			&metadata.ContentType,
 			&metadata.CreatedAt,
 			&metadata.UpdatedAt,
+			&metadata.ValidationStatus,
+			&metadata.ValidationMessage,
 		)
 		if err != nil {
 			return nil, err
 		}
-		files = append(files, &metadata)
+		result.Files = append(result.Files, &metadata)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return files, nil
+	if !opts.Recursive {
+		if result.Dirs, err = se.listDirs(opts.Prefix, offset); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
 }
 
-// DeleteFile 删除文件
-func (se *StorageEngine) DeleteFile(filename string) error {
-	filePath := filepath.Join(se.storeDir, filename)
+// listDirs 列出前缀下一级的目录
+func (se *StorageEngine) listDirs(prefix string, offset int) ([]string, error) {
+	cond, args := prefixCondition("filename", prefix)
+	args = append([]any{offset, offset}, append(args, offset)...)
+	rows, err := se.db.Query(
+		"SELECT DISTINCT substr(filename, 1, ? + instr(substr(filename, ?), '/') - 1) AS dir FROM file_metadata "+
+			"WHERE "+cond+" AND instr(substr(filename, ?), '/') > 0 ORDER BY dir",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	// 从数据库中删除记录
-	_, err := se.db.Exec("DELETE FROM file_metadata WHERE path = ?", filePath)
+	var dirs []string
+	for rows.Next() {
+		var dir string
+		if err := rows.Scan(&dir); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, rows.Err()
+}
+
+// DeleteFile 删除文件，blob在没有其他文件引用时一并删除
+func (se *StorageEngine) DeleteFile(filename string) error {
+	filename, filePath, err := se.filePath(filename)
 	if err != nil {
 		return err
 	}
 
-	// 删除物理文件
-	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete file: %v", err)
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	var released []string
+	err = se.update(func() error {
+		released = nil
+		// 被题目测试点引用的文件不能删除；题目的写入同样持有锁，检查之后不会有新的引用
+		problemID, err := se.fileUsedByProblem(filename)
+		if err != nil {
+			return err
+		}
+		if problemID != 0 {
+			return fmt.Errorf("%w %d", ErrFileInUse, problemID)
+		}
+
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		var id int64
+		err = tx.QueryRow("SELECT id FROM file_metadata WHERE path = ?", filePath).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// 从数据库中删除记录及全部版本
+		released, err = deleteFiles(tx, "id = ?", id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
 	}
 
+	// 删除不再被引用的blob文件
+	for _, hash := range released {
+		se.removeBlob(hash)
+	}
 	return nil
 }
 
+// DeleteDir 递归删除目录下的所有文件，返回删除的文件数
+// 目录下有文件被题目测试点引用时不删除任何文件
+func (se *StorageEngine) DeleteDir(dir string) (int, error) {
+	dir, _, err := se.filePath(dir)
+	if err != nil {
+		return 0, err
+	}
+	inputCond, inputArgs := prefixCondition("input", dir+"/")
+	outputCond, outputArgs := prefixCondition("output", dir+"/")
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	var count int
+	var released []string
+	err = se.update(func() error {
+		var problemID int64
+		err := se.db.QueryRow(
+			"SELECT problem_id FROM problem_testcases WHERE "+inputCond+" OR "+outputCond+" LIMIT 1",
+			append(inputArgs, outputArgs...)...,
+		).Scan(&problemID)
+		if err == nil {
+			return fmt.Errorf("%w %d", ErrFileInUse, problemID)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		cond, args := prefixCondition("filename", dir+"/")
+		if err := tx.QueryRow("SELECT COUNT(*) FROM file_metadata WHERE "+cond, args...).Scan(&count); err != nil {
+			return err
+		}
+		released, err = deleteFiles(tx, cond, args...)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// 删除不再被引用的blob文件
+	for _, hash := range released {
+		se.removeBlob(hash)
+	}
+	return count, nil
+}
+
 // Close 关闭存储引擎
 func (se *StorageEngine) Close() error {
 	return se.db.Close()
@@ -276,7 +766,32 @@ func (se *StorageEngine) GetContentType(filename string) string {
 		return "text/x-c"
 	case ".cpp", ".cc", ".cxx":
 		return "text/x-c++"
+	case ".bin", ".dat":
+		return "application/octet-stream"
+	case ".gz":
+		return "application/gzip"
+	case ".zip":
+		return "application/zip"
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".pdf":
+		return "application/pdf"
 	default:
 		return "text/plain"
 	}
 }
+
+// IsTextContentType 判断内容类型是否为文本，文本类型的文件内容必须是有效的UTF-8
+func IsTextContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
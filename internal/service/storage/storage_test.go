@@ -4,8 +4,18 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestStorageEngine(t *testing.T) {
@@ -63,13 +73,13 @@ func TestStorageEngine(t *testing.T) {
 	}
 
 	// 测试列出文件
-	files, err := se.ListFiles()
+	files, err := se.ListFiles(ListOptions{Recursive: true})
 	if err != nil {
 		t.Fatalf("Failed to list files: %v", err)
 	}
 
-	if len(files) != 1 {
-		t.Errorf("Expected 1 file, got %d", len(files))
+	if len(files.Files) != 1 {
+		t.Errorf("Expected 1 file, got %d", len(files.Files))
 	}
 
 	// 测试删除文件
@@ -98,22 +108,25 @@ func TestIsTestcaseFile(t *testing.T) {
 
 	// 测试有效的测试用例文件
 	testcaseContent := []byte("This is a valid testcase file\nwith multiple lines.")
-	if !se.isTestcaseFile(testcaseContent) {
+	if !se.isTestcaseFile("text/x-testcase-input", testcaseContent) {
 		t.Error("Valid testcase content was rejected")
 	}
 
 	// 测试二进制文件（模拟）
-	binaryContent := make([]byte, 100)
+	binaryContent := make([]byte, 256)
 	for i := range binaryContent {
 		binaryContent[i] = byte(i % 256)
 	}
-	if se.isTestcaseFile(binaryContent) {
-		t.Error("Binary content was accepted as testcase")
+	if se.isTestcaseFile("text/x-testcase-input", binaryContent) {
+		t.Error("Binary content was accepted as text testcase")
+	}
+	if !se.isTestcaseFile("application/octet-stream", binaryContent) {
+		t.Error("Binary content was rejected for binary content type")
 	}
 
 	// 测试空文件
 	emptyContent := []byte("")
-	if !se.isTestcaseFile(emptyContent) {
+	if !se.isTestcaseFile("text/plain", emptyContent) {
 		t.Error("Empty content was rejected")
 	}
 }
@@ -129,18 +142,281 @@ func TestWriteNonTestcaseFile(t *testing.T) {
 	}
 	defer se.Close()
 
-	// 尝试写入二进制文件
-	binaryContent := make([]byte, 100)
+	// 尝试以文本类型写入二进制文件
+	binaryContent := make([]byte, 256)
 	for i := range binaryContent {
 		binaryContent[i] = byte(i % 256)
 	}
 
-	err = se.WriteFile("binary.bin", binaryContent)
-	if err == nil {
-		t.Error("Expected error when writing binary file")
+	err = se.WriteFile("binary.in", binaryContent)
+	if !errors.Is(err, ErrNotTestcaseFile) {
+		t.Errorf("Expected 'only testcase files are allowed' error, got: %v", err)
 	}
 
-	if err.Error() != "only testcase files are allowed" {
-		t.Errorf("Expected 'only testcase files are allowed' error, got: %v", err)
+	// 二进制类型的文件可以写入，内容原样读出
+	if err := se.WriteFile("binary.bin", binaryContent); err != nil {
+		t.Fatalf("Failed to write binary file: %v", err)
+	}
+	metadata, err := se.GetFileMetadata("binary.bin")
+	if err != nil || metadata.ContentType != "application/octet-stream" {
+		t.Errorf("Unexpected metadata: %+v, %v", metadata, err)
+	}
+	reader, err := se.ReadFile("binary.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if data, err := io.ReadAll(reader); err != nil || !bytes.Equal(data, binaryContent) {
+		t.Errorf("Binary content mismatch: %v", err)
+	}
+
+	// 显式指定二进制类型时.in文件也可以包含任意字节，更新时沿用已有的类型
+	if _, err := se.WriteStream(context.Background(), "raw.in", bytes.NewReader(binaryContent), WriteOptions{ContentType: "application/octet-stream"}); err != nil {
+		t.Fatalf("Failed to write binary input: %v", err)
+	}
+	if err := se.WriteFile("raw.in", binaryContent[:128]); err != nil {
+		t.Errorf("Failed to update binary input: %v", err)
+	}
+}
+
+func TestWriteStream(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	content := strings.Repeat("你好，世界\n", 10000)
+	if _, err := se.WriteStream(context.Background(), "big.in", iotest.OneByteReader(strings.NewReader(content)), WriteOptions{}); err != nil {
+		t.Fatalf("Multi-byte characters split across reads were rejected: %v", err)
+	}
+
+	_, err = se.WriteStream(context.Background(), "big.out", strings.NewReader(content), WriteOptions{MaxSize: int64(len(content)) - 1})
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("Expected ErrFileTooLarge, got %v", err)
+	}
+	if _, err := se.GetFileMetadata("big.out"); err == nil {
+		t.Error("File exceeding the size limit should not be written")
+	}
+
+	// 被拒绝的上传不应留下临时文件
+	entries, _ := os.ReadDir(filepath.Join(se.storeDir, stagingDir))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), backendTmpPrefix) {
+			t.Errorf("Temporary file %s was left behind", entry.Name())
+		}
+	}
+}
+
+func TestStorageDirectories(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	for _, name := range []string{"1.in", "p1/1.in", "p1/1.out", "p1/sub1/2.in", "p2/1.in", "p1_x.in"} {
+		if err := se.WriteFile(name, []byte("1\n")); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	// 路径穿越与文件、目录冲突
+	for _, name := range []string{"../x.in", "/etc/x.in", "p1/../../x.in", "p1", "1.in/2.in", "p1/a:b"} {
+		if err := se.WriteFile(name, []byte("1\n")); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("WriteFile(%q) = %v, want ErrInvalidFilename", name, err)
+		}
+	}
+	if _, err := se.ReadFile("p1/./1.in"); err != nil {
+		t.Errorf("Expected normalized name to be readable, got %v", err)
+	}
+
+	list, err := se.ListFiles(ListOptions{Prefix: "p1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 || list.Files[0].Filename != "p1/1.in" || len(list.Dirs) != 1 || list.Dirs[0] != "p1/sub1/" {
+		t.Errorf("Unexpected listing of p1/: %d files, dirs %v", list.Total, list.Dirs)
+	}
+	if list, _ := se.ListFiles(ListOptions{}); list.Total != 2 || len(list.Dirs) != 2 {
+		t.Errorf("Unexpected root listing: %d files, dirs %v", list.Total, list.Dirs)
+	}
+	// "_"不能被当作通配符
+	if list, _ := se.ListFiles(ListOptions{Prefix: "p1_", Recursive: true}); list.Total != 1 {
+		t.Errorf("Expected 1 file with prefix p1_, got %d", list.Total)
+	}
+	list, _ = se.ListFiles(ListOptions{Recursive: true, Limit: 2, Offset: 4})
+	if list.Total != 6 || len(list.Files) != 2 || list.Files[0].Filename != "p1_x.in" {
+		t.Errorf("Unexpected page: total %d, %d files", list.Total, len(list.Files))
+	}
+
+	count, err := se.DeleteDir("p1")
+	if err != nil || count != 3 {
+		t.Fatalf("DeleteDir = %d, %v, want 3", count, err)
+	}
+	if list, _ := se.ListFiles(ListOptions{Recursive: true}); list.Total != 3 {
+		t.Errorf("Expected 3 files after deleting p1, got %d", list.Total)
+	}
+}
+
+func TestStorageDirectoriesCaseSensitive(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	// 文件名区分大小写，A与a/1.in不冲突
+	for _, name := range []string{"a/1.in", "A", "B/1.in", "b/1.in"} {
+		if err := se.WriteFile(name, []byte("1\n")); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	list, err := se.ListFiles(ListOptions{Prefix: "b/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 1 || list.Files[0].Filename != "b/1.in" {
+		t.Errorf("Unexpected listing of b/: %+v", list.Files)
+	}
+	if list, _ := se.ListFiles(ListOptions{}); list.Total != 1 || !slices.Equal(list.Dirs, []string{"B/", "a/", "b/"}) {
+		t.Errorf("Unexpected root listing: %d files, dirs %v", list.Total, list.Dirs)
+	}
+
+	problem := &Problem{Title: "b", Testcases: []ProblemTestcase{{Input: "b/1.in"}}}
+	if err := se.CreateProblem(problem); err != nil {
+		t.Fatal(err)
+	}
+	count, err := se.DeleteDir("B")
+	if err != nil || count != 1 {
+		t.Fatalf("DeleteDir(B) = %d, %v, want 1", count, err)
+	}
+	if count, err := se.DeleteDir("A"); err != nil || count != 0 {
+		t.Errorf("DeleteDir(A) = %d, %v, want 0", count, err)
+	}
+	if list, _ := se.ListFiles(ListOptions{Recursive: true}); list.Total != 3 {
+		t.Errorf("Expected 3 files left, got %d", list.Total)
+	}
+}
+
+func TestListFilesFilters(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	files := map[string]string{"a.in": "1", "b.in": "1 2 3", "c.in": "1 2 3 4 5 6", "d.out": "12"}
+	for name, content := range files {
+		if err := se.WriteFile(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	se.db.Exec("UPDATE file_metadata SET updated_at = '2024-01-01 00:00:00' WHERE filename IN ('a.in', 'b.in')")
+
+	names := func(list *FileList) []string {
+		var result []string
+		for _, f := range list.Files {
+			result = append(result, f.Filename)
+		}
+		return result
+	}
+	tests := []struct {
+		opts ListOptions
+		want []string
+	}{
+		{ListOptions{Recursive: true, SortBy: "size", Desc: true}, []string{"c.in", "b.in", "d.out", "a.in"}},
+		{ListOptions{Recursive: true, MinSize: 2, MaxSize: 5}, []string{"b.in", "d.out"}},
+		{ListOptions{Recursive: true, UpdatedBefore: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, []string{"a.in", "b.in"}},
+		{ListOptions{Recursive: true, UpdatedAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), SortBy: "filename", Desc: true}, []string{"d.out", "c.in"}},
+		{ListOptions{Recursive: true, ContentType: "application/octet-stream"}, nil},
+	}
+	for _, tt := range tests {
+		list, err := se.ListFiles(tt.opts)
+		if err != nil {
+			t.Fatalf("ListFiles(%+v) failed: %v", tt.opts, err)
+		}
+		if got := names(list); !slices.Equal(got, tt.want) || list.Total != len(tt.want) {
+			t.Errorf("ListFiles(%+v) = %v (total %d), want %v", tt.opts, got, list.Total, tt.want)
+		}
+	}
+
+	if _, err := se.ListFiles(ListOptions{SortBy: "hash; DROP TABLE file_metadata"}); !errors.Is(err, ErrInvalidListOptions) {
+		t.Errorf("Expected ErrInvalidListOptions for unknown sort field, got %v", err)
+	}
+}
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	tempDir := t.TempDir()
+	storeDir := filepath.Join(tempDir, "files")
+	dbPath := filepath.Join(tempDir, "test.db")
+
+	// 按最初的表结构创建数据库，文件直接存放在逻辑路径上
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	legacyPath := filepath.Join(storeDir, "1.in")
+	if err := os.WriteFile(legacyPath, []byte("1 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+	CREATE TABLE file_metadata (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		filename TEXT NOT NULL,
+		path TEXT NOT NULL UNIQUE,
+		size INTEGER NOT NULL,
+		content_type TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	INSERT INTO file_metadata (filename, path, size, content_type) VALUES ('1.in', ?, 4, 'text/plain');
+	`, legacyPath)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	se, err := NewStorageEngine(storeDir, dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open baseline database: %v", err)
+	}
+	defer se.Close()
+	metadata, err := se.GetFileMetadata("1.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Version != 1 || metadata.Hash != hashContent([]byte("1 2\n")) {
+		t.Errorf("Unexpected migrated metadata: %+v", metadata)
+	}
+	reader, err := se.ReadFile("1.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if content, _ := io.ReadAll(reader); string(content) != "1 2\n" {
+		t.Errorf("Unexpected migrated content: %q", content)
+	}
+}
+
+func TestInitStorageEngine(t *testing.T) {
+	tempDir := t.TempDir()
+	config := &Config{StoreDir: filepath.Join(tempDir, "files"), DBPath: filepath.Join(tempDir, "db", "metadata.db")}
+	if err := InitStorageEngine(config); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseStorageEngine() })
+
+	// 初始化之后获取的实例应使用自定义配置，而不是默认配置
+	se := GetStorageEngineInstance()
+	if se.storeDir != config.StoreDir {
+		t.Errorf("Expected store dir %s, got %s", config.StoreDir, se.storeDir)
 	}
 }
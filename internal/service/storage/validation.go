@@ -0,0 +1,272 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"nightcord-server/internal/model"
+	"path"
+)
+
+// 测试输入的校验状态
+const (
+	ValidationValid   = "valid"
+	ValidationInvalid = "invalid"
+	ValidationError   = "error" // 校验程序无法编译或运行，无法判断输入是否有效
+)
+
+var (
+	// ErrInvalidTestcase 上传的测试输入未通过要求拒绝无效输入的校验程序
+	ErrInvalidTestcase = errors.New("testcase rejected by validator")
+	// ErrInvalidValidator 校验程序的设置无效，具体原因包装在错误信息中
+	ErrInvalidValidator = errors.New("invalid validator")
+	// ErrValidatorNotFound 目录没有设置校验程序
+	ErrValidatorNotFound = errors.New("validator not found")
+)
+
+// ValidateFunc 在沙箱中编译并运行校验程序检查输入，返回输入是否有效与校验信息
+// 校验程序无法编译或运行时返回错误；由评测模块提供，存储引擎本身不依赖评测模块
+type ValidateFunc func(ctx context.Context, v model.Validator, input io.Reader) (bool, string, error)
+
+// Validation 一次上传或重新校验的结果
+type Validation struct {
+	Status  string // 取值见 ValidationValid 等常量
+	Message string
+	Reject  bool // 判定输入无效的校验程序中有要求拒绝上传的
+}
+
+// initValidationTables 初始化目录校验程序表
+func (se *StorageEngine) initValidationTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS dir_validators (
+		dir TEXT PRIMARY KEY,
+		validator TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err := se.db.Exec(query)
+	return err
+}
+
+// SetValidateFunc 设置运行校验程序的函数，未设置时上传不做校验
+func (se *StorageEngine) SetValidateFunc(fn ValidateFunc) {
+	se.validate = fn
+}
+
+// checkValidator 检查校验程序的设置，不检查源文件是否存在
+func checkValidator(v *model.Validator) error {
+	if v.Source == "" {
+		return errors.New("validator source is required")
+	}
+	if v.CpuTimeLimit < 0 {
+		return errors.New("validator cpu_time_limit cannot be negative")
+	}
+	return nil
+}
+
+// checkValidatorSource 检查校验程序的源文件存在，v为nil时不做检查
+func (se *StorageEngine) checkValidatorSource(v *model.Validator) error {
+	if v == nil {
+		return nil
+	}
+	if _, err := se.GetFileMetadata(v.Source); err != nil {
+		return fmt.Errorf("validator references missing file %q", v.Source)
+	}
+	return nil
+}
+
+// SetDirValidator 为目录设置校验程序，作用于目录及其子目录下上传的输入文件，子目录的设置优先
+func (se *StorageEngine) SetDirValidator(dir string, v model.Validator) error {
+	dir, _, err := se.filePath(dir)
+	if err != nil {
+		return err
+	}
+	if err := checkValidator(&v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValidator, err)
+	}
+	if err := se.checkValidatorSource(&v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValidator, err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.update(func() error {
+		_, err := se.db.Exec(
+			"INSERT INTO dir_validators (dir, validator) VALUES (?, ?) ON CONFLICT(dir) DO UPDATE SET validator = excluded.validator, updated_at = CURRENT_TIMESTAMP",
+			dir, string(data),
+		)
+		return err
+	})
+}
+
+// GetDirValidator 获取直接设置在目录上的校验程序
+func (se *StorageEngine) GetDirValidator(dir string) (*model.Validator, error) {
+	dir, _, err := se.filePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	var data string
+	err = se.db.QueryRow("SELECT validator FROM dir_validators WHERE dir = ?", dir).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrValidatorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v model.Validator
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// DeleteDirValidator 删除目录的校验程序，已记录的校验结果保持不变
+func (se *StorageEngine) DeleteDirValidator(dir string) error {
+	dir, _, err := se.filePath(dir)
+	if err != nil {
+		return err
+	}
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.update(func() error {
+		res, err := se.db.Exec("DELETE FROM dir_validators WHERE dir = ?", dir)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrValidatorNotFound
+		}
+		return nil
+	})
+}
+
+// validatorsFor 返回适用于文件的校验程序：最近的设置了校验程序的上级目录，以及将文件作为测试点输入的题目
+func (se *StorageEngine) validatorsFor(filename string) ([]model.Validator, error) {
+	var sources []string
+	for dir := path.Dir(filename); dir != "."; dir = path.Dir(dir) {
+		var data string
+		err := se.db.QueryRow("SELECT validator FROM dir_validators WHERE dir = ?", dir).Scan(&data)
+		if err == nil {
+			sources = append(sources, data)
+			break
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	rows, err := se.db.Query(
+		"SELECT DISTINCT p.validator FROM problems p JOIN problem_testcases t ON t.problem_id = p.id WHERE t.input = ? AND p.validator != ''",
+		filename,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		sources = append(sources, data)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	validators := make([]model.Validator, len(sources))
+	for i, data := range sources {
+		if err := json.Unmarshal([]byte(data), &validators[i]); err != nil {
+			return nil, err
+		}
+	}
+	return validators, nil
+}
+
+// runValidators 使用适用的校验程序依次检查输入文件，每个校验程序重新打开一次内容
+// 只校验.in文件，没有适用的校验程序或未设置 ValidateFunc 时返回nil
+// 任一校验程序判定无效时结果为无效，否则任一校验程序出错时结果为出错
+func (se *StorageEngine) runValidators(ctx context.Context, filename string, open func() (io.ReadCloser, error)) (*Validation, error) {
+	if se.validate == nil || path.Ext(filename) != ".in" {
+		return nil, nil
+	}
+	validators, err := se.validatorsFor(filename)
+	if err != nil || len(validators) == 0 {
+		return nil, err
+	}
+
+	result := &Validation{Status: ValidationValid}
+	for _, v := range validators {
+		input, err := open()
+		if err != nil {
+			return nil, err
+		}
+		valid, message, err := se.validate(ctx, v, input)
+		input.Close()
+		switch {
+		case err != nil:
+			if result.Status == ValidationValid {
+				result.Status = ValidationError
+				result.Message = err.Error()
+			}
+		case !valid:
+			if result.Status != ValidationInvalid {
+				result.Status = ValidationInvalid
+				result.Message = message
+			}
+			result.Reject = result.Reject || v.Reject
+		}
+	}
+	return result, nil
+}
+
+// setValidation 记录校验结果，内容已被并发的写入替换时不做处理，v为nil时不做处理
+func (se *StorageEngine) setValidation(filePath, hash string, v *Validation) error {
+	if v == nil {
+		return nil
+	}
+	_, err := se.db.Exec(
+		"UPDATE file_metadata SET validation_status = ?, validation_message = ? WHERE path = ? AND hash = ?",
+		v.Status, v.Message, filePath, hash,
+	)
+	return err
+}
+
+// ValidateFile 使用当前适用的校验程序重新校验文件的当前内容并记录结果，不会因结果无效而删除文件
+// 用于设置校验程序之后检查已上传的文件；没有适用的校验程序时清除原来的校验结果
+func (se *StorageEngine) ValidateFile(ctx context.Context, filename string) (*FileMetadata, error) {
+	metadata, err := se.GetFileMetadata(filename)
+	if err != nil {
+		return nil, err
+	}
+	validation, err := se.runValidators(ctx, metadata.Filename, func() (io.ReadCloser, error) {
+		return se.ReadFileVersion(metadata.Filename, metadata.Version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if validation == nil {
+		validation = &Validation{}
+	}
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	err = se.update(func() error {
+		return se.setValidation(metadata.Path, metadata.Hash, validation)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return se.GetFileMetadata(filename)
+}
@@ -0,0 +1,219 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"nightcord-server/internal/model"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeValidate 内容包含bad时判定无效，校验程序源文件为broken.cpp时模拟编译失败
+func fakeValidate(ctx context.Context, v model.Validator, input io.Reader) (bool, string, error) {
+	if v.Source == "broken.cpp" {
+		return false, "", errors.New("validator compilation failed")
+	}
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return false, "", err
+	}
+	if strings.Contains(string(content), "bad") {
+		return false, "n out of range", nil
+	}
+	return true, "", nil
+}
+
+func TestValidation(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+	se.SetValidateFunc(fakeValidate)
+
+	for _, name := range []string{"val.cpp", "broken.cpp"} {
+		if err := se.WriteFile(name, []byte("int main() {}\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := se.SetDirValidator("p1", model.Validator{LanguageID: 1, Source: "missing.cpp"}); !errors.Is(err, ErrInvalidValidator) {
+		t.Fatalf("Expected ErrInvalidValidator for a missing source, got %v", err)
+	}
+	if err := se.SetDirValidator("p1", model.Validator{LanguageID: 1, Source: "val.cpp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只记录结果的校验程序不拒绝无效输入
+	metadata, err := se.WriteStream(context.Background(), "p1/sub/1.in", strings.NewReader("bad\n"), WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ValidationStatus != ValidationInvalid || metadata.ValidationMessage != "n out of range" {
+		t.Fatalf("Unexpected validation result: %+v", metadata)
+	}
+	// 内容变化后重新校验
+	metadata, err = se.WriteStream(context.Background(), "p1/sub/1.in", strings.NewReader("1\n"), WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ValidationStatus != ValidationValid || metadata.ValidationMessage != "" {
+		t.Fatalf("Unexpected validation result: %+v", metadata)
+	}
+	// 只校验.in文件
+	metadata, err = se.WriteStream(context.Background(), "p1/1.out", strings.NewReader("bad\n"), WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ValidationStatus != "" {
+		t.Fatalf("Expected no validation for output files, got %+v", metadata)
+	}
+
+	// 子目录的设置优先，拒绝无效输入时不写入文件
+	if err := se.SetDirValidator("p1/sub", model.Validator{LanguageID: 1, Source: "val.cpp", Reject: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := se.WriteStream(context.Background(), "p1/sub/2.in", strings.NewReader("bad\n"), WriteOptions{}); !errors.Is(err, ErrInvalidTestcase) {
+		t.Fatalf("Expected ErrInvalidTestcase, got %v", err)
+	}
+	if _, err := se.GetFileMetadata("p1/sub/2.in"); err == nil {
+		t.Fatal("Rejected file should not be stored")
+	}
+	if _, err := se.WriteStream(context.Background(), "p1/2.in", strings.NewReader("bad\n"), WriteOptions{}); err != nil {
+		t.Fatalf("Parent directory validator should not reject: %v", err)
+	}
+
+	// 题目的校验程序作用于其测试点输入，校验程序出错时记录为出错
+	if err := se.WriteFile("q/1.in", []byte("1\n")); err != nil {
+		t.Fatal(err)
+	}
+	problem := &Problem{
+		Title:     "q",
+		Validator: &model.Validator{LanguageID: 1, Source: "broken.cpp"},
+		Testcases: []ProblemTestcase{{Input: "q/1.in"}},
+	}
+	if err := se.CreateProblem(problem); err != nil {
+		t.Fatal(err)
+	}
+	got, err := se.GetProblem(problem.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Validator == nil || got.Validator.Source != "broken.cpp" {
+		t.Fatalf("Unexpected problem validator: %+v", got.Validator)
+	}
+	metadata, err = se.ValidateFile(context.Background(), "q/1.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ValidationStatus != ValidationError || metadata.ValidationMessage != "validator compilation failed" {
+		t.Fatalf("Unexpected validation result: %+v", metadata)
+	}
+
+	// 删除目录的校验程序后重新校验清除原来的结果
+	if err := se.DeleteDirValidator("p1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.DeleteDirValidator("p1"); !errors.Is(err, ErrValidatorNotFound) {
+		t.Fatalf("Expected ErrValidatorNotFound, got %v", err)
+	}
+	metadata, err = se.ValidateFile(context.Background(), "p1/2.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ValidationStatus != "" {
+		t.Fatalf("Expected validation result to be cleared, got %+v", metadata)
+	}
+}
+
+func TestValidationContext(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	type ctxKey struct{}
+	calls := 0
+	se.SetValidateFunc(func(ctx context.Context, v model.Validator, input io.Reader) (bool, string, error) {
+		calls++
+		if ctx.Value(ctxKey{}) != "upload" {
+			t.Error("Validator did not receive the request context")
+		}
+		return true, "", nil
+	})
+	if err := se.WriteFile("val.cpp", []byte("int main() {}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.SetDirValidator("p1", model.Validator{LanguageID: 1, Source: "val.cpp"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.WriteFile("p1/a", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "upload")
+	if _, err := se.WriteStream(ctx, "p1/1.in", strings.NewReader("1\n"), WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected validator to run once, got %d", calls)
+	}
+
+	// 路径冲突的写入在运行校验程序之前失败
+	if _, err := se.WriteStream(ctx, "p1/a/1.in", strings.NewReader("1\n"), WriteOptions{}); !errors.Is(err, ErrInvalidFilename) {
+		t.Fatalf("Expected ErrInvalidFilename, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Validator should not run for a conflicting path, got %d calls", calls)
+	}
+}
+
+func TestRestoreVersionValidation(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+	se.SetValidateFunc(fakeValidate)
+
+	if err := se.WriteFile("val.cpp", []byte("int main() {}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.SetDirValidator("p1", model.Validator{LanguageID: 1, Source: "val.cpp"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"1\n", "bad\n"} {
+		if err := se.WriteFile("p1/1.in", []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 恢复的版本重新校验并记录结果
+	ctx := context.Background()
+	metadata, err := se.RestoreVersion(ctx, "p1/1.in", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Version != 3 || metadata.ValidationStatus != ValidationValid {
+		t.Fatalf("Unexpected metadata after restore: %+v", metadata)
+	}
+
+	// 要求拒绝无效输入时不能恢复无效的版本
+	if err := se.SetDirValidator("p1", model.Validator{LanguageID: 1, Source: "val.cpp", Reject: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := se.RestoreVersion(ctx, "p1/1.in", 2); !errors.Is(err, ErrInvalidTestcase) {
+		t.Fatalf("Expected ErrInvalidTestcase, got %v", err)
+	}
+	if metadata, err := se.GetFileMetadata("p1/1.in"); err != nil || metadata.Version != 3 {
+		t.Fatalf("Rejected restore should not change the file: %+v, %v", metadata, err)
+	}
+}
@@ -0,0 +1,345 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrVersionNotFound 文件不存在指定的版本
+	ErrVersionNotFound = errors.New("file version not found")
+	// ErrCurrentVersion 文件的当前版本不能删除，需要删除整个文件或先写入新的内容
+	ErrCurrentVersion = errors.New("cannot delete the current version")
+)
+
+// versionPruneDelay 被新版本取代不足该时长的版本不会按保留策略清理，正在进行的评测可能仍在读取固定的旧版本
+const versionPruneDelay = 10 * time.Minute
+
+// VersionRetention 历史版本的保留策略，两项限制都设置时超出任一项的版本即被清理，文件的当前版本总是保留
+type VersionRetention struct {
+	Keep   int           // 每个文件最多保留的版本数（含当前版本），0表示不限制
+	MaxAge time.Duration // 创建时间早于该时长之前的历史版本被清理，0表示不限制
+}
+
+// FileVersion 文件的一个不可变版本，每个版本引用一个blob
+type FileVersion struct {
+	Version     int64     `json:"version"`
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// initVersionTables 初始化文件版本表，为还没有版本记录的文件补充版本1
+// blob的引用计数按版本计算，已有文件的引用转移到补充的版本上，计数不变
+func (se *StorageEngine) initVersionTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS file_versions (
+		file_id INTEGER NOT NULL,
+		version INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		content_type TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (file_id, version)
+	);
+	`
+	if _, err := se.db.Exec(query); err != nil {
+		return err
+	}
+
+	tx, err := se.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE file_metadata SET version = 1 WHERE version = 0 AND hash != ''"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO file_versions (file_id, version, hash, size, content_type, created_at)
+		SELECT id, version, hash, size, content_type, updated_at FROM file_metadata
+		WHERE version > 0 AND id NOT IN (SELECT file_id FROM file_versions)`,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordVersion 将文件的当前内容记录为新版本
+func recordVersion(tx *sql.Tx, fileID int64) error {
+	_, err := tx.Exec(
+		"INSERT INTO file_versions (file_id, version, hash, size, content_type) SELECT id, version, hash, size, content_type FROM file_metadata WHERE id = ?",
+		fileID,
+	)
+	return err
+}
+
+// deleteFiles 删除满足条件的文件及其全部版本，返回引用计数归零的blob哈希，文件由调用者在提交后删除
+func deleteFiles(tx *sql.Tx, where string, args ...any) ([]string, error) {
+	_, released, err := deleteVersions(tx, "file_id IN (SELECT id FROM file_metadata WHERE "+where+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM file_metadata WHERE "+where, args...); err != nil {
+		return nil, err
+	}
+	return released, nil
+}
+
+// deleteVersions 删除满足条件的版本并减少其blob的引用计数，返回删除的版本数与引用计数归零的blob哈希
+func deleteVersions(tx *sql.Tx, where string, args ...any) (int, []string, error) {
+	rows, err := tx.Query("SELECT hash FROM file_versions WHERE "+where, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM file_versions WHERE "+where, args...); err != nil {
+		return 0, nil, err
+	}
+	var released []string
+	for _, hash := range hashes {
+		ok, err := unrefBlob(tx, hash)
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok {
+			released = append(released, hash)
+		}
+	}
+	return len(hashes), released, nil
+}
+
+// ListVersions 按版本号从新到旧列出文件的全部版本
+func (se *StorageEngine) ListVersions(filename string) ([]FileVersion, error) {
+	metadata, err := se.GetFileMetadata(filename)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := se.db.Query(
+		"SELECT version, hash, size, content_type, created_at FROM file_versions WHERE file_id = ? ORDER BY version DESC",
+		metadata.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []FileVersion{}
+	for rows.Next() {
+		var v FileVersion
+		if err := rows.Scan(&v.Version, &v.Hash, &v.Size, &v.ContentType, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetFileVersion 获取文件的指定版本，version为0时返回当前版本
+func (se *StorageEngine) GetFileVersion(filename string, version int64) (*FileVersion, error) {
+	metadata, err := se.GetFileMetadata(filename)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		version = metadata.Version
+	}
+
+	v := FileVersion{Version: version}
+	err = se.db.QueryRow(
+		"SELECT hash, size, content_type, created_at FROM file_versions WHERE file_id = ? AND version = ?",
+		metadata.ID, version,
+	).Scan(&v.Hash, &v.Size, &v.ContentType, &v.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s version %d", ErrVersionNotFound, filename, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ReadFileVersion 读取文件的指定版本，version为0时读取当前版本，读取到末尾时校验内容的哈希
+func (se *StorageEngine) ReadFileVersion(filename string, version int64) (io.ReadCloser, error) {
+	if version == 0 {
+		return se.ReadFile(filename)
+	}
+	v, err := se.GetFileVersion(filename, version)
+	if err != nil {
+		return nil, err
+	}
+	file, err := se.backend.Get(blobKey(v.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return &verifyingReader{file: file, hash: sha256.New(), want: v.Hash}, nil
+}
+
+// RestoreVersion 将文件恢复为指定版本的内容，恢复本身作为一个新版本记录，历史版本保持不变
+// 恢复的内容与上传一样经过校验程序检查，被要求拒绝无效输入的校验程序判定无效时返回 ErrInvalidTestcase
+func (se *StorageEngine) RestoreVersion(ctx context.Context, filename string, version int64) (*FileMetadata, error) {
+	return se.restoreVersion(ctx, filename, version, true)
+}
+
+// restoreVersion 恢复文件的指定版本并记录校验结果，reject为false时不因校验结果拒绝恢复
+// 撤销导入时恢复的是导入前已有的内容，不能因之后设置的校验程序而失败
+func (se *StorageEngine) restoreVersion(ctx context.Context, filename string, version int64, reject bool) (*FileMetadata, error) {
+	filename, filePath, err := se.filePath(filename)
+	if err != nil {
+		return nil, err
+	}
+	v, err := se.GetFileVersion(filename, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// 在加锁前运行校验程序，编译与运行期间不阻塞其他写入
+	validation, err := se.runValidators(ctx, filename, func() (io.ReadCloser, error) {
+		return se.ReadFileVersion(filename, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reject && validation != nil && validation.Status == ValidationInvalid && validation.Reject {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTestcase, validation.Message)
+	}
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	err = se.update(func() error {
+		// 加锁前读取的版本可能已被删除，重新读取；版本引用的blob在版本删除前不会被释放，直接引用即可
+		current, err := se.GetFileVersion(filename, version)
+		if err != nil {
+			return err
+		}
+		if current.Hash != v.Hash {
+			return fmt.Errorf("%w: %s version %d", ErrVersionNotFound, filename, version)
+		}
+		if err := se.updateMetadata(filename, filePath, v.Hash, v.ContentType, v.Size); err != nil {
+			return err
+		}
+		return se.setValidation(filePath, v.Hash, validation)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return se.GetFileMetadata(filename)
+}
+
+// DeleteVersion 删除文件的一个历史版本，版本引用的blob在没有其他引用时一并删除
+func (se *StorageEngine) DeleteVersion(filename string, version int64) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	var released []string
+	err := se.update(func() error {
+		// 持有锁时读取当前版本，检查之后不会被并发的写入取代
+		metadata, err := se.GetFileMetadata(filename)
+		if err != nil {
+			return err
+		}
+		if version == metadata.Version {
+			return ErrCurrentVersion
+		}
+
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		var n int
+		n, released, err = deleteVersions(tx, "file_id = ? AND version = ?", metadata.ID, version)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: %s version %d", ErrVersionNotFound, metadata.Filename, version)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	for _, hash := range released {
+		se.removeBlob(hash)
+	}
+	return nil
+}
+
+// SetVersionRetention 设置历史版本的保留策略，由 PruneVersions 定期执行
+func (se *StorageEngine) SetVersionRetention(r VersionRetention) {
+	se.retention = r
+}
+
+// PruneVersions 按保留策略删除全部文件中超出限制的历史版本，返回删除的版本数
+// 释放的blob随即删除；共用后端时由 CollectGarbage 回收
+func (se *StorageEngine) PruneVersions() (int, error) {
+	r := se.retention
+	if r.Keep <= 0 && r.MaxAge <= 0 {
+		return 0, nil
+	}
+
+	// 时间以SQLite CURRENT_TIMESTAMP的格式（UTC）比较
+	now := time.Now().UTC()
+	var limits []string
+	args := []any{now.Add(-versionPruneDelay).Format(time.DateTime)}
+	if r.Keep > 0 {
+		limits = append(limits, "(SELECT COUNT(*) FROM file_versions n WHERE n.file_id = file_versions.file_id AND n.version > file_versions.version) >= ?")
+		args = append(args, r.Keep)
+	}
+	if r.MaxAge > 0 {
+		limits = append(limits, "created_at < ?")
+		args = append(args, now.Add(-r.MaxAge).Format(time.DateTime))
+	}
+	where := "version < (SELECT version FROM file_metadata WHERE id = file_versions.file_id)" +
+		" AND (SELECT MIN(n.created_at) FROM file_versions n WHERE n.file_id = file_versions.file_id AND n.version > file_versions.version) < ?" +
+		" AND (" + strings.Join(limits, " OR ") + ")"
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	var n int
+	var released []string
+	err := se.update(func() error {
+		tx, err := se.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		n, released, err = deleteVersions(tx, where, args...)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, hash := range released {
+		se.removeBlob(hash)
+	}
+	return n, nil
+}
@@ -0,0 +1,156 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileVersions(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	for _, content := range []string{"1\n", "2\n", "2\n", "3\n"} {
+		if err := se.WriteFile("a.out", []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := se.ListVersions("a.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内容未变化的写入不产生新版本
+	if len(versions) != 3 || versions[0].Version != 3 || versions[2].Hash != hashContent([]byte("1\n")) {
+		t.Fatalf("Unexpected versions: %+v", versions)
+	}
+
+	read := func(version int64) string {
+		t.Helper()
+		reader, err := se.ReadFileVersion("a.out", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, _ := io.ReadAll(reader)
+		return string(data)
+	}
+	if got := read(1); got != "1\n" {
+		t.Errorf("Version 1 = %q", got)
+	}
+	if got := read(0); got != "3\n" {
+		t.Errorf("Current version = %q", got)
+	}
+
+	metadata, err := se.RestoreVersion(context.Background(), "a.out", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Version != 4 || read(0) != "1\n" || read(3) != "3\n" {
+		t.Errorf("Unexpected state after restore: %+v", metadata)
+	}
+	if _, err := se.ReadFileVersion("a.out", 9); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+	}
+
+	// 删除文件后重新创建，版本号从1开始
+	if err := se.DeleteFile("a.out"); err != nil {
+		t.Fatal(err)
+	}
+	se.WriteFile("a.out", []byte("5\n"))
+	if versions, _ := se.ListVersions("a.out"); len(versions) != 1 || versions[0].Version != 1 {
+		t.Errorf("Unexpected versions after recreation: %+v", versions)
+	}
+}
+
+func TestVersionRetention(t *testing.T) {
+	tempDir := t.TempDir()
+	se, err := NewStorageEngine(filepath.Join(tempDir, "files"), filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage engine: %v", err)
+	}
+	defer se.Close()
+
+	for _, content := range []string{"1\n", "2\n", "3\n", "4\n"} {
+		if err := se.WriteFile("a.out", []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := se.DeleteVersion("a.out", 4); !errors.Is(err, ErrCurrentVersion) {
+		t.Fatalf("Expected ErrCurrentVersion, got %v", err)
+	}
+	if err := se.DeleteVersion("a.out", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.DeleteVersion("a.out", 1); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("Expected ErrVersionNotFound, got %v", err)
+	}
+	// 不再被引用的blob随版本删除
+	if _, err := se.backend.Stat(blobKey(hashContent([]byte("1\n")))); err == nil {
+		t.Error("Blob of the deleted version was not removed")
+	}
+
+	versionsOf := func(filename string) []int64 {
+		t.Helper()
+		versions, err := se.ListVersions(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, v := range versions {
+			got = append(got, v.Version)
+		}
+		return got
+	}
+	prune := func(want int) {
+		t.Helper()
+		n, err := se.PruneVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("Pruned %d versions, want %d", n, want)
+		}
+	}
+
+	// 刚被取代的版本可能仍在评测中使用，不会被清理
+	se.SetVersionRetention(VersionRetention{Keep: 2})
+	prune(0)
+	if _, err := se.db.Exec("UPDATE file_versions SET created_at = datetime('now', '-1 hour')"); err != nil {
+		t.Fatal(err)
+	}
+	prune(1)
+	if got := versionsOf("a.out"); len(got) != 2 || got[0] != 4 || got[1] != 3 {
+		t.Errorf("Unexpected versions after pruning by count: %v", got)
+	}
+
+	// 按时长清理时当前版本总是保留
+	for _, content := range []string{"1\n", "2\n"} {
+		if err := se.WriteFile("b.out", []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := se.db.Exec("UPDATE file_versions SET created_at = datetime('now', '-2 days') WHERE version = 1 OR file_id IN (SELECT id FROM file_metadata WHERE filename = 'a.out')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := se.db.Exec("UPDATE file_versions SET created_at = datetime('now', '-1 hour') WHERE version = 2 AND file_id IN (SELECT id FROM file_metadata WHERE filename = 'b.out')"); err != nil {
+		t.Fatal(err)
+	}
+	se.SetVersionRetention(VersionRetention{MaxAge: 24 * time.Hour})
+	prune(2)
+	if got := versionsOf("a.out"); len(got) != 1 || got[0] != 4 {
+		t.Errorf("Unexpected versions of a.out after pruning by age: %v", got)
+	}
+	if got := versionsOf("b.out"); len(got) != 1 || got[0] != 2 {
+		t.Errorf("Unexpected versions of b.out after pruning by age: %v", got)
+	}
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"nightcord-server/internal/model"
 	"nightcord-server/internal/service/executor"
@@ -8,13 +9,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// Executor 处理 POST /run 的请求
+// Executor 处理 POST /executor 的请求
 func Executor(c *gin.Context) {
 	var req model.SubmitRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
 		return
 	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	// 将任务加入消息队列中等待协程池执行
 	result := executor.SubmitJob(req)
 	c.JSON(http.StatusOK, result)
@@ -29,3 +34,62 @@ func GetRunManagerStatus(c *gin.Context) {
 	status := executor.GetRunManagerInstance().GetStatus()
 	c.JSON(http.StatusOK, status)
 }
+
+// Compile 处理 POST /compile 的请求，仅编译不运行，成功时返回编译产物句柄
+func Compile(c *gin.Context) {
+	var req model.SubmitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := executor.GetArtifactStoreInstance().Compile(c.Request.Context(), req)
+	if err != nil {
+		writeArtifactError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Run 处理 POST /run 的请求，使用编译产物句柄以给定的输入与限制运行一次
+func Run(c *gin.Context) {
+	var req model.RunRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Handle == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := executor.GetArtifactStoreInstance().Run(c.Request.Context(), req)
+	if err != nil {
+		writeArtifactError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// DeleteArtifact 处理 DELETE /compile/:handle 的请求，提前释放编译产物
+func DeleteArtifact(c *gin.Context) {
+	if err := executor.GetArtifactStoreInstance().Delete(c.Param("handle")); err != nil {
+		writeArtifactError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "artifact deleted successfully"})
+}
+
+func writeArtifactError(c *gin.Context, err error) {
+	if errors.Is(err, executor.ErrArtifactNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, executor.ErrArtifactStoreFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
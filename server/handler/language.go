@@ -1,21 +1,133 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"nightcord-server/internal/model"
+	"nightcord-server/internal/service/executor"
 	"nightcord-server/internal/service/language"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
-// GetLanguages 返回语言列表（仅包含id和name），用于客户端展示
+// GetLanguages 返回启用的语言列表，包含默认限制与版本信息，用于客户端展示
 func GetLanguages(c *gin.Context) {
-	var langs []gin.H
-	languages := language.GetLanguages()
-	for _, lang := range languages {
-		langs = append(langs, gin.H{
-			"id":   lang.ID,
-			"name": lang.Name,
-		})
+	langs := []model.LanguageInfo{}
+	for _, lang := range language.GetLanguages() {
+		langs = append(langs, executor.GetLanguageInfo(lang))
 	}
 	c.JSON(http.StatusOK, langs)
 }
+
+// GetLanguage 返回指定id的启用语言信息
+func GetLanguage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid language id"})
+		return
+	}
+	lang := language.GetLanguageByID(id)
+	if lang.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "language not found"})
+		return
+	}
+	c.JSON(http.StatusOK, executor.GetLanguageInfo(lang))
+}
+
+// GetAllLanguages 返回包括已停用语言在内的完整语言配置
+func GetAllLanguages(c *gin.Context) {
+	c.JSON(http.StatusOK, language.GetAllLanguages())
+}
+
+// AddLanguage 新增语言，未指定id时自动分配
+func AddLanguage(c *gin.Context) {
+	var lang model.Language
+	if err := c.ShouldBindJSON(&lang); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	lang, err := language.AddLanguage(lang)
+	if err != nil {
+		writeLanguageError(c, err)
+		return
+	}
+	go executor.DetectLanguageVersions(context.Background())
+	c.JSON(http.StatusOK, lang)
+}
+
+// UpdateLanguage 替换指定id的语言配置
+func UpdateLanguage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid language id"})
+		return
+	}
+	var lang model.Language
+	if err := c.ShouldBindJSON(&lang); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	lang, err = language.UpdateLanguage(id, lang)
+	if err != nil {
+		writeLanguageError(c, err)
+		return
+	}
+	go executor.DetectLanguageVersions(context.Background())
+	c.JSON(http.StatusOK, lang)
+}
+
+// DisableLanguage 停用指定id的语言，进行中的任务不受影响
+func DisableLanguage(c *gin.Context) {
+	setLanguageDisabled(c, true)
+}
+
+// EnableLanguage 重新启用指定id的语言
+func EnableLanguage(c *gin.Context) {
+	setLanguageDisabled(c, false)
+}
+
+// ReloadLanguages 重新加载 lang.json，校验失败时保留原有配置
+func ReloadLanguages(c *gin.Context) {
+	if err := language.ReloadLanguages(); err != nil {
+		writeLanguageError(c, err)
+		return
+	}
+	// 版本检测需要执行命令，放在后台进行
+	go executor.DetectLanguageVersions(context.Background())
+	c.JSON(http.StatusOK, gin.H{
+		"message": "languages reloaded successfully",
+		"count":   len(language.GetAllLanguages()),
+	})
+}
+
+func setLanguageDisabled(c *gin.Context, disabled bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid language id"})
+		return
+	}
+	lang, err := language.SetLanguageDisabled(id, disabled)
+	if err != nil {
+		writeLanguageError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, lang)
+}
+
+// writeLanguageError 根据错误类型返回对应的状态码，校验错误会附带逐项的错误描述
+func writeLanguageError(c *gin.Context, err error) {
+	var verr *language.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid language config",
+			"details": verr.Errors,
+		})
+	case errors.Is(err, language.ErrLanguageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "language not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,236 @@
+//go:build linux
+// +build linux
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"nightcord-server/internal/service/storage"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemHandler 题目处理器
+type ProblemHandler struct {
+	storageEngine *storage.StorageEngine
+}
+
+// NewProblemHandler 创建新的题目处理器
+func NewProblemHandler() *ProblemHandler {
+	return &ProblemHandler{
+		storageEngine: storage.GetStorageEngineInstance(),
+	}
+}
+
+// ListProblems 列出所有题目
+func (h *ProblemHandler) ListProblems(c *gin.Context) {
+	problems, err := h.storageEngine.ListProblems()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"problems": problems,
+		"count":    len(problems),
+	})
+}
+
+// CreateProblem 创建题目，请求中包含testcases时一并写入测试点
+func (h *ProblemHandler) CreateProblem(c *gin.Context) {
+	var problem storage.Problem
+	if err := c.ShouldBindJSON(&problem); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+
+	if err := h.storageEngine.CreateProblem(&problem); err != nil {
+		writeProblemError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "problem created successfully",
+		"id":      problem.ID,
+	})
+}
+
+// GetProblem 获取题目及其测试点
+func (h *ProblemHandler) GetProblem(c *gin.Context) {
+	id, ok := problemID(c)
+	if !ok {
+		return
+	}
+
+	problem, err := h.storageEngine.GetProblem(id)
+	if err != nil {
+		writeProblemError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, problem)
+}
+
+// UpdateProblem 更新题目设置，请求中包含testcases时替换全部测试点
+func (h *ProblemHandler) UpdateProblem(c *gin.Context) {
+	id, ok := problemID(c)
+	if !ok {
+		return
+	}
+
+	var problem storage.Problem
+	if err := c.ShouldBindJSON(&problem); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+	problem.ID = id
+
+	if err := h.storageEngine.UpdateProblem(&problem); err != nil {
+		writeProblemError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "problem updated successfully",
+		"id":      id,
+	})
+}
+
+// DeleteProblem 删除题目，测试点引用的文件保留
+func (h *ProblemHandler) DeleteProblem(c *gin.Context) {
+	id, ok := problemID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.storageEngine.DeleteProblem(id); err != nil {
+		writeProblemError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "problem deleted successfully",
+		"id":      id,
+	})
+}
+
+// GetProblemTestcases 按顺序列出题目的测试点
+func (h *ProblemHandler) GetProblemTestcases(c *gin.Context) {
+	id, ok := problemID(c)
+	if !ok {
+		return
+	}
+
+	testcases, err := h.storageEngine.GetProblemTestcases(id)
+	if err != nil {
+		writeProblemError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"testcases": testcases,
+		"count":     len(testcases),
+	})
+}
+
+// SetProblemTestcases 按请求中的顺序替换题目的全部测试点
+func (h *ProblemHandler) SetProblemTestcases(c *gin.Context) {
+	id, ok := problemID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Testcases []storage.ProblemTestcase `json:"testcases"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+
+	if err := h.storageEngine.SetProblemTestcases(id, req.Testcases); err != nil {
+		writeProblemError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "testcases updated successfully",
+		"count":   len(req.Testcases),
+	})
+}
+
+// AddProblemTestcase 在题目末尾追加一组测试点
+func (h *ProblemHandler) AddProblemTestcase(c *gin.Context) {
+	id, ok := problemID(c)
+	if !ok {
+		return
+	}
+
+	var tc storage.ProblemTestcase
+	if err := c.ShouldBindJSON(&tc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+
+	index, err := h.storageEngine.AddProblemTestcase(id, tc)
+	if err != nil {
+		writeProblemError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "testcase added successfully",
+		"index":   index,
+	})
+}
+
+// ImportProblem 导入 Polygon 或 Kattis 格式的题目包，一次性创建题目及其测试数据
+func (h *ProblemHandler) ImportProblem(c *gin.Context) {
+	data, opts, ok := readImportRequest(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.storageEngine.ImportProblemPackage(data, c.PostForm("format"), opts)
+	if err != nil {
+		writeProblemError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "problem imported successfully",
+		"id":       result.Problem.ID,
+		"problem":  result.Problem,
+		"files":    result.Files,
+		"warnings": result.Warnings,
+	})
+}
+
+// problemID 解析路径中的题目ID，无效时写入400响应
+func problemID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid problem id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func writeProblemError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrProblemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrInvalidProblem),
+		errors.Is(err, storage.ErrInvalidPackage),
+		errors.Is(err, storage.ErrUnsupportedPackage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrMetadataConflict):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -4,14 +4,24 @@
 package handler
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"nightcord-server/internal/conf"
+	"nightcord-server/internal/model"
 	"nightcord-server/internal/service/storage"
-	"path/filepath"
+	"nightcord-server/utils"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // StorageHandler 存储处理器
@@ -27,8 +37,9 @@ func NewStorageHandler() *StorageHandler {
 }
 
 // UploadFile 上传文件
+// 内容可以通过表单字段testcase、表单文件file或原始请求体（此时文件名放在查询参数filename中）提交
 func (h *StorageHandler) UploadFile(c *gin.Context) {
-	filename := c.PostForm("filename")
+	filename := formValue(c, "filename")
 	if filename == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "filename is required",
@@ -37,67 +48,34 @@ func (h *StorageHandler) UploadFile(c *gin.Context) {
 	}
 
 	// 验证文件名
-	if !isValidFilename(filename) {
+	if !storage.IsValidFilename(filename) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "invalid filename",
 		})
 		return
 	}
 
-	// 从表单获取测试用例内容
-	testcase := c.PostForm("testcase")
-	if testcase == "" {
-		// 尝试从文件上传获取内容
-		file, err := c.FormFile("file")
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "testcase or file is required",
-			})
-			return
-		}
-
-		// 读取上传的文件内容
-		src, err := file.Open()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "failed to open uploaded file",
-			})
-			return
-		}
-		defer src.Close()
-
-		testcaseBytes, err := io.ReadAll(src)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "failed to read uploaded file",
-			})
-			return
-		}
-		testcase = string(testcaseBytes)
+	src, opts, ok := openUpload(c)
+	if !ok {
+		return
 	}
+	defer src.Close()
 
-	// 写入文件
-	err := h.storageEngine.WriteFile(filename, []byte(testcase))
+	// 流式写入文件
+	metadata, err := h.storageEngine.WriteStream(c.Request.Context(), filename, src, opts)
 	if err != nil {
-		if strings.Contains(err.Error(), "only testcase files are allowed") {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "only testcase files are allowed",
-			})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
-		}
+		writeFileError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "file uploaded successfully",
 		"filename": filename,
+		"metadata": metadata,
 	})
 }
 
-// DownloadFile 下载文件
+// DownloadFile 下载文件，查询参数version指定历史版本，默认为当前版本
 func (h *StorageHandler) DownloadFile(c *gin.Context) {
 	filename := c.Param("filename")
 	if filename == "" {
@@ -106,36 +84,35 @@ func (h *StorageHandler) DownloadFile(c *gin.Context) {
 		})
 		return
 	}
+	version, ok := fileVersion(c, c.Query("version"))
+	if !ok {
+		return
+	}
 
-	// 获取测试用例内容
-	reader, err := h.storageEngine.ReadFile(filename)
+	// 获取版本信息
+	v, err := h.storageEngine.GetFileVersion(filename, version)
 	if err != nil {
-		if strings.Contains(err.Error(), "file not found") {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "file not found",
-			})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
-		}
+		writeFileError(c, err)
 		return
 	}
-	defer reader.Close()
 
-	// 获取文件元数据
-	metadata, err := h.storageEngine.GetFileMetadata(filename)
+	// 客户端缓存的内容未变化时不再传输
+	if checkETag(c, v.Hash) {
+		return
+	}
+
+	// 获取测试用例内容
+	reader, err := h.storageEngine.ReadFileVersion(filename, v.Version)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to get file metadata",
-		})
+		writeFileError(c, err)
 		return
 	}
+	defer reader.Close()
 
 	// 设置响应头
-	c.Header("Content-Type", metadata.ContentType)
-	c.Header("Content-Length", strconv.FormatInt(metadata.Size, 10))
-	c.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	c.Header("Content-Type", v.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(v.Size, 10))
+	c.Header("Content-Disposition", "attachment; filename=\""+path.Base(filename)+"\"")
 
 	// 流式传输文件内容
 	_, err = io.Copy(c.Writer, reader)
@@ -148,6 +125,7 @@ func (h *StorageHandler) DownloadFile(c *gin.Context) {
 }
 
 // GetTestcaseContent 获取测试用例内容（以JSON格式返回）
+// 内容以流的方式写入响应而不在内存中缓冲，二进制内容以base64编码
 func (h *StorageHandler) GetTestcaseContent(c *gin.Context) {
 	filename := c.Param("filename")
 	if filename == "" {
@@ -157,62 +135,248 @@ func (h *StorageHandler) GetTestcaseContent(c *gin.Context) {
 		return
 	}
 
-	// 获取测试用例内容
-	reader, err := h.storageEngine.ReadFile(filename)
+	// 获取文件元数据，客户端缓存的内容未变化时不再读取文件
+	metadata, err := h.storageEngine.GetFileMetadata(filename)
 	if err != nil {
-		if strings.Contains(err.Error(), "file not found") {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "file not found",
-			})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
-		}
+		writeFileError(c, err)
+		return
+	}
+	if checkETag(c, metadata.Hash) {
+		return
+	}
+
+	// 读取与元数据一致的版本，避免读取期间文件被覆盖
+	reader, err := h.storageEngine.ReadFileVersion(metadata.Filename, metadata.Version)
+	if err != nil {
+		writeFileError(c, err)
 		return
 	}
 	defer reader.Close()
 
-	// 读取测试用例内容
-	testcase, err := io.ReadAll(reader)
+	if err := writeTestcaseJSON(c, filename, metadata, reader); err != nil {
+		// 响应头已经发送，只能中断响应，客户端会收到不完整的JSON
+		c.Error(err)
+	}
+}
+
+// writeTestcaseJSON 以流的方式写出测试用例内容，字段与 gin.H 序列化的结果一致
+func writeTestcaseJSON(c *gin.Context, filename string, metadata *storage.FileMetadata, content io.Reader) error {
+	name, err := json.Marshal(filename)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to read testcase content",
+		return err
+	}
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	binary := !storage.IsTextContentType(metadata.ContentType)
+
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := c.Writer
+	if binary {
+		io.WriteString(w, `{"encoding":"base64",`)
+	} else {
+		io.WriteString(w, `{`)
+	}
+	fmt.Fprintf(w, `"filename":%s,"metadata":%s,"testcase":"`, name, meta)
+	if binary {
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		if _, err := io.Copy(enc, content); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	} else if _, err := io.Copy(utils.JSONStringWriter{W: w}, content); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, `"}`)
+	return err
+}
+
+// GetFileMetadata 获取文件元数据
+func (h *StorageHandler) GetFileMetadata(c *gin.Context) {
+	filename := c.Param("filename")
+	if filename == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "filename is required",
 		})
 		return
 	}
 
-	// 获取文件元数据
 	metadata, err := h.storageEngine.GetFileMetadata(filename)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to get file metadata",
+		writeFileError(c, err)
+		return
+	}
+
+	// 元数据中包含内容哈希，内容未变化时元数据也不会变化
+	if checkETag(c, metadata.Hash) {
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+// ListVersions 按从新到旧的顺序列出文件的全部版本
+func (h *StorageHandler) ListVersions(c *gin.Context) {
+	filename := c.Param("filename")
+	versions, err := h.storageEngine.ListVersions(filename)
+	if err != nil {
+		writeFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"filename": filename,
+		"versions": versions,
+		"count":    len(versions),
+	})
+}
+
+// RestoreVersion 将文件恢复为指定版本的内容，恢复后产生一个新版本
+func (h *StorageHandler) RestoreVersion(c *gin.Context) {
+	filename := c.Param("filename")
+	version, ok := fileVersion(c, c.Param("version"))
+	if !ok {
+		return
+	}
+	if version == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "version is required",
 		})
 		return
 	}
 
+	metadata, err := h.storageEngine.RestoreVersion(c.Request.Context(), filename, version)
+	if err != nil {
+		writeFileError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
+		"message":  "file restored successfully",
 		"filename": filename,
-		"testcase": string(testcase),
 		"metadata": metadata,
 	})
 }
 
-// GetFileMetadata 获取文件元数据
-func (h *StorageHandler) GetFileMetadata(c *gin.Context) {
+// DeleteVersion 删除文件的一个历史版本，当前版本不能删除
+func (h *StorageHandler) DeleteVersion(c *gin.Context) {
 	filename := c.Param("filename")
-	if filename == "" {
+	version, ok := fileVersion(c, c.Param("version"))
+	if !ok {
+		return
+	}
+	if version == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "filename is required",
+			"error": "version is required",
 		})
 		return
 	}
 
-	metadata, err := h.storageEngine.GetFileMetadata(filename)
+	if err := h.storageEngine.DeleteVersion(filename, version); err != nil {
+		writeFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "file version deleted successfully",
+		"filename": filename,
+		"version":  version,
+	})
+}
+
+// fileVersion 解析版本号，为空时返回0表示当前版本，无效时写入400响应
+func fileVersion(c *gin.Context, s string) (int64, bool) {
+	if s == "" {
+		return 0, true
+	}
+	version, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid version",
+		})
+		return 0, false
+	}
+	return version, true
+}
+
+// ListFiles 列出文件，支持按前缀（目录）、内容类型、大小与修改时间过滤，以及排序与分页
+// recursive=false 时只列出前缀下一级的文件，并在dirs中返回下一级目录
+func (h *StorageHandler) ListFiles(c *gin.Context) {
+	opts := storage.ListOptions{
+		Prefix:      c.Query("prefix"),
+		Recursive:   c.DefaultQuery("recursive", "true") != "false",
+		ContentType: c.Query("content_type"),
+		SortBy:      c.Query("sort"),
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "order must be asc or desc",
+		})
+		return
+	}
+
+	// 数值参数
+	for _, p := range []struct {
+		name  string
+		value *int64
+	}{{"min_size", &opts.MinSize}, {"max_size", &opts.MaxSize}} {
+		if v := c.Query(p.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "invalid " + p.name,
+				})
+				return
+			}
+			*p.value = n
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"limit", &opts.Limit}, {"offset", &opts.Offset}} {
+		if v := c.Query(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "invalid " + p.name,
+				})
+				return
+			}
+			*p.value = n
+		}
+	}
+
+	// 时间参数使用RFC 3339格式，如 2024-01-02T15:04:05Z
+	for _, p := range []struct {
+		name  string
+		value *time.Time
+	}{{"updated_after", &opts.UpdatedAfter}, {"updated_before", &opts.UpdatedBefore}} {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "invalid " + p.name + ", expected RFC 3339 time",
+				})
+				return
+			}
+			*p.value = t
+		}
+	}
+
+	list, err := h.storageEngine.ListFiles(opts)
 	if err != nil {
-		if strings.Contains(err.Error(), "file not found") {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "file not found",
+		if errors.Is(err, storage.ErrInvalidListOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
 			})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -222,22 +386,99 @@ func (h *StorageHandler) GetFileMetadata(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, metadata)
+	c.JSON(http.StatusOK, gin.H{
+		"files": list.Files,
+		"dirs":  list.Dirs,
+		"count": len(list.Files),
+		"total": list.Total,
+	})
 }
 
-// ListFiles 列出所有文件
-func (h *StorageHandler) ListFiles(c *gin.Context) {
-	files, err := h.storageEngine.ListFiles()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
+// DeleteDir 递归删除目录下的所有文件
+func (h *StorageHandler) DeleteDir(c *gin.Context) {
+	dir := c.Param("dir")
+	if dir == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "dir is required",
 		})
 		return
 	}
 
+	count, err := h.storageEngine.DeleteDir(dir)
+	if err != nil {
+		writeFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "directory deleted successfully",
+		"dir":     dir,
+		"count":   count,
+	})
+}
+
+// GetDirValidator 获取目录的校验程序
+func (h *StorageHandler) GetDirValidator(c *gin.Context) {
+	dir := c.Param("dir")
+	validator, err := h.storageEngine.GetDirValidator(dir)
+	if err != nil {
+		writeFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dir":       dir,
+		"validator": validator,
+	})
+}
+
+// SetDirValidator 设置目录的校验程序，之后上传到目录及其子目录下的.in文件均会被校验
+func (h *StorageHandler) SetDirValidator(c *gin.Context) {
+	dir := c.Param("dir")
+	var validator model.Validator
+	if err := c.ShouldBindJSON(&validator); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+
+	if err := h.storageEngine.SetDirValidator(dir, validator); err != nil {
+		writeFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "validator set successfully",
+		"dir":       dir,
+		"validator": validator,
+	})
+}
+
+// DeleteDirValidator 删除目录的校验程序
+func (h *StorageHandler) DeleteDirValidator(c *gin.Context) {
+	dir := c.Param("dir")
+	if err := h.storageEngine.DeleteDirValidator(dir); err != nil {
+		writeFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "validator deleted successfully",
+		"dir":     dir,
+	})
+}
+
+// ValidateFile 使用当前适用的校验程序重新校验文件
+func (h *StorageHandler) ValidateFile(c *gin.Context) {
+	filename := c.Param("filename")
+	metadata, err := h.storageEngine.ValidateFile(c.Request.Context(), filename)
+	if err != nil {
+		writeFileError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"files": files,
-		"count": len(files),
+		"filename": filename,
+		"metadata": metadata,
 	})
 }
 
@@ -253,9 +494,7 @@ func (h *StorageHandler) DeleteFile(c *gin.Context) {
 
 	err := h.storageEngine.DeleteFile(filename)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		writeFileError(c, err)
 		return
 	}
 
@@ -265,7 +504,7 @@ func (h *StorageHandler) DeleteFile(c *gin.Context) {
 	})
 }
 
-// UpdateFile 更新文件内容
+// UpdateFile 更新文件内容，内容的提交方式与 UploadFile 相同
 func (h *StorageHandler) UpdateFile(c *gin.Context) {
 	filename := c.Param("filename")
 	if filename == "" {
@@ -276,53 +515,126 @@ func (h *StorageHandler) UpdateFile(c *gin.Context) {
 	}
 
 	// 验证文件名
-	if !isValidFilename(filename) {
+	if !storage.IsValidFilename(filename) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "invalid filename",
 		})
 		return
 	}
 
-	// 获取新的测试用例内容
-	testcase := c.PostForm("testcase")
-	if testcase == "" {
-		// 尝试从文件上传获取内容
-		file, err := c.FormFile("file")
-		if err != nil {
+	// 检查文件是否存在
+	_, err := h.storageEngine.GetFileMetadata(filename)
+	if err != nil {
+		writeFileError(c, err)
+		return
+	}
+
+	src, opts, ok := openUpload(c)
+	if !ok {
+		return
+	}
+	defer src.Close()
+
+	// 流式更新文件内容
+	metadata, err := h.storageEngine.WriteStream(c.Request.Context(), filename, src, opts)
+	if err != nil {
+		writeFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "file updated successfully",
+		"filename": filename,
+		"metadata": metadata,
+	})
+}
+
+// openUpload 打开上传的文件内容与写入选项，失败时写入错误响应
+// 表单请求读取字段testcase或文件file，其他请求直接流式读取请求体；内容类型由参数content_type显式指定
+func openUpload(c *gin.Context) (io.ReadCloser, storage.WriteOptions, bool) {
+	opts := storage.WriteOptions{
+		ContentType: formValue(c, "content_type"),
+		MaxSize:     int64(conf.Conf.Storage.UploadSizeLimit) * 1024,
+	}
+	if opts.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(opts.ContentType); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "testcase or file is required",
+				"error": "invalid content_type",
 			})
-			return
+			return nil, opts, false
 		}
+	}
 
-		// 读取上传的文件内容
-		src, err := file.Open()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "failed to open uploaded file",
+	switch c.ContentType() {
+	case binding.MIMEMultipartPOSTForm, binding.MIMEPOSTForm:
+	default:
+		if c.Request.Body == nil || c.Request.ContentLength == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "testcase or file is required",
 			})
-			return
+			return nil, opts, false
 		}
-		defer src.Close()
+		return c.Request.Body, opts, true
+	}
 
-		testcaseBytes, err := io.ReadAll(src)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "failed to read uploaded file",
-			})
-			return
-		}
-		testcase = string(testcaseBytes)
+	// 从表单获取测试用例内容
+	if testcase := c.PostForm("testcase"); testcase != "" {
+		return io.NopCloser(strings.NewReader(testcase)), opts, true
 	}
 
-	// 检查文件是否存在
-	_, err := h.storageEngine.GetFileMetadata(filename)
+	// 尝试从文件上传获取内容，较大的文件由multipart解析到临时文件中，不会整个读入内存
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "testcase or file is required",
+		})
+		return nil, opts, false
+	}
+	if opts.MaxSize > 0 && file.Size > opts.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": storage.ErrFileTooLarge.Error(),
+		})
+		return nil, opts, false
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to open uploaded file",
+		})
+		return nil, opts, false
+	}
+	return src, opts, true
+}
+
+// formValue 读取表单字段，不存在时读取同名查询参数
+func formValue(c *gin.Context, key string) string {
+	if v := c.PostForm(key); v != "" {
+		return v
+	}
+	return c.Query(key)
+}
+
+// ImportPackage 导入zip或tar.gz格式的测试数据包，自动配对输入输出文件
+func (h *StorageHandler) ImportPackage(c *gin.Context) {
+	data, opts, ok := readImportRequest(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.storageEngine.ImportPackage(data, opts)
 	if err != nil {
-		if strings.Contains(err.Error(), "file not found") {
+		switch {
+		case errors.Is(err, storage.ErrInvalidPackage):
+			resp := gin.H{"error": err.Error()}
+			if result != nil {
+				resp["errors"] = result.Errors
+			}
+			c.JSON(http.StatusBadRequest, resp)
+		case errors.Is(err, storage.ErrProblemNotFound):
 			c.JSON(http.StatusNotFound, gin.H{
-				"error": "file not found",
+				"error": err.Error(),
 			})
-		} else {
+		default:
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
 			})
@@ -330,12 +642,49 @@ func (h *StorageHandler) UpdateFile(c *gin.Context) {
 		return
 	}
 
-	// 更新测试用例内容
-	err = h.storageEngine.WriteFile(filename, []byte(testcase))
-	if err != nil {
-		if strings.Contains(err.Error(), "only testcase files are allowed") {
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "package imported successfully",
+		"files":     result.Files,
+		"testcases": result.Testcases,
+		"count":     len(result.Testcases),
+	})
+}
+
+// ExportPackage 以压缩包的形式导出文件，指定problem_id时按测试点顺序导出该题目的数据
+func (h *StorageHandler) ExportPackage(c *gin.Context) {
+	format := c.DefaultQuery("format", utils.ArchiveZip)
+	var contentType string
+	switch format {
+	case utils.ArchiveZip:
+		contentType = "application/zip"
+	case utils.ArchiveTarGz:
+		contentType = "application/gzip"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "format must be zip or tar.gz",
+		})
+		return
+	}
+
+	var problemID int64
+	name := "testcases"
+	if id := c.Query("problem_id"); id != "" {
+		var err error
+		problemID, err = strconv.ParseInt(id, 10, 64)
+		if err != nil || problemID <= 0 {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "only testcase files are allowed",
+				"error": "invalid problem id",
+			})
+			return
+		}
+		name = "problem-" + id
+	}
+
+	entries, err := h.storageEngine.ExportEntries(problemID)
+	if err != nil {
+		if errors.Is(err, storage.ErrProblemNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
 			})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -345,35 +694,108 @@ func (h *StorageHandler) UpdateFile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "file updated successfully",
-		"filename": filename,
-	})
-}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename=\""+name+"."+format+"\"")
+	c.Status(http.StatusOK)
 
-// isValidFilename 验证文件名是否有效
-func isValidFilename(filename string) bool {
-	if filename == "" {
-		return false
+	// 响应头已发送，流式传输中的错误只能中断连接
+	if err := h.storageEngine.WritePackage(c.Writer, format, entries); err != nil {
+		c.Error(err)
+		c.Abort()
 	}
+}
 
-	// 检查是否包含非法字符
-	invalidChars := []string{"..", "/", "\\", ":", "*", "?", "\"", "<", ">", "|"}
-	for _, char := range invalidChars {
-		if strings.Contains(filename, char) {
-			return false
+// readImportRequest 读取导入请求中上传的压缩包与导入选项，失败时写入错误响应
+func readImportRequest(c *gin.Context) ([]byte, storage.ImportOptions, bool) {
+	opts := storage.ImportOptions{
+		Prefix: c.PostForm("prefix"),
+		Limit: utils.ArchiveLimit{
+			MaxFiles: conf.Conf.Storage.ImportFileLimit,
+			MaxSize:  int64(conf.Conf.Storage.ImportSizeLimit) * 1024,
+		},
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "file is required",
+		})
+		return nil, opts, false
+	}
+	if problemID := c.PostForm("problem_id"); problemID != "" {
+		opts.ProblemID, err = strconv.ParseInt(problemID, 10, 64)
+		if err != nil || opts.ProblemID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid problem id",
+			})
+			return nil, opts, false
 		}
 	}
+	if opts.Limit.MaxSize > 0 && file.Size > opts.Limit.MaxSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "package is too large",
+		})
+		return nil, opts, false
+	}
 
-	// 检查文件名长度
-	if len(filename) > 255 {
-		return false
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to open uploaded file",
+		})
+		return nil, opts, false
 	}
+	defer src.Close()
 
-	// 检查是否为相对路径
-	if filepath.IsAbs(filename) {
-		return false
+	data, err := io.ReadAll(src)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to read uploaded file",
+		})
+		return nil, opts, false
 	}
+	return data, opts, true
+}
+
+// writeFileError 根据存储引擎返回的错误写入对应状态码的响应
+func writeFileError(c *gin.Context, err error) {
+	switch {
+	case strings.Contains(err.Error(), "file not found"):
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+	case errors.Is(err, storage.ErrVersionNotFound),
+		errors.Is(err, storage.ErrValidatorNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrInvalidFilename),
+		errors.Is(err, storage.ErrNotTestcaseFile),
+		errors.Is(err, storage.ErrInvalidValidator):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrFileInUse),
+		errors.Is(err, storage.ErrCurrentVersion):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrInvalidTestcase):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrMetadataConflict):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
 
-	return true
+// checkETag 以内容哈希作为ETag响应头，客户端缓存的版本与当前一致时返回304
+// 返回true表示已写入响应，调用者无需继续处理
+func checkETag(c *gin.Context, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	etag := `"` + hash + `"`
+	c.Header("ETag", etag)
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
 }
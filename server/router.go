@@ -6,5 +6,6 @@ func InitRoute() error {
 	routes.InitLanguageRoutes(ginServer)
 	routes.InitExecutorRoutes(ginServer)
 	routes.InitStorageRoutes(ginServer)
+	routes.InitProblemRoutes(ginServer)
 	return nil
 }
@@ -8,6 +8,9 @@ import (
 
 func InitExecutorRoutes(router *gin.Engine) {
 	router.POST("/executor", handler.Executor)
+	router.POST("/compile", handler.Compile)
+	router.DELETE("/compile/:handle", handler.DeleteArtifact)
+	router.POST("/run", handler.Run)
 	router.GET("/job/status", handler.GetJobStatus)
 	router.GET("/run/status", handler.GetRunManagerStatus)
 }
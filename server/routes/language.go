@@ -2,10 +2,23 @@ package routes
 
 import (
 	"nightcord-server/server/handler"
+	"nightcord-server/server/middlewares"
 
 	"github.com/gin-gonic/gin"
 )
 
 func InitLanguageRoutes(router *gin.Engine) {
 	router.GET("/languages", handler.GetLanguages)
+	router.GET("/languages/:id", handler.GetLanguage)
+
+	// 语言管理路由组，需要认证
+	adminGroup := router.Group("/admin/languages", middlewares.AuthMiddleware)
+	{
+		adminGroup.GET("", handler.GetAllLanguages)
+		adminGroup.POST("", handler.AddLanguage)
+		adminGroup.POST("/reload", handler.ReloadLanguages)
+		adminGroup.PUT("/:id", handler.UpdateLanguage)
+		adminGroup.POST("/:id/disable", handler.DisableLanguage)
+		adminGroup.POST("/:id/enable", handler.EnableLanguage)
+	}
 }
@@ -0,0 +1,46 @@
+//go:build linux
+// +build linux
+
+package routes
+
+import (
+	"nightcord-server/server/handler"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InitProblemRoutes 设置题目相关路由
+func InitProblemRoutes(router *gin.Engine) {
+	problemHandler := handler.NewProblemHandler()
+
+	// 题目API路由组
+	problemGroup := router.Group("/problems")
+	{
+		// 列出所有题目
+		problemGroup.GET("", problemHandler.ListProblems)
+
+		// 创建题目
+		problemGroup.POST("", problemHandler.CreateProblem)
+
+		// 导入 Polygon 或 Kattis 格式的题目包
+		problemGroup.POST("/import", problemHandler.ImportProblem)
+
+		// 获取题目及其测试点
+		problemGroup.GET("/:id", problemHandler.GetProblem)
+
+		// 更新题目
+		problemGroup.PUT("/:id", problemHandler.UpdateProblem)
+
+		// 删除题目
+		problemGroup.DELETE("/:id", problemHandler.DeleteProblem)
+
+		// 按顺序列出测试点
+		problemGroup.GET("/:id/testcases", problemHandler.GetProblemTestcases)
+
+		// 替换全部测试点
+		problemGroup.PUT("/:id/testcases", problemHandler.SetProblemTestcases)
+
+		// 追加一组测试点
+		problemGroup.POST("/:id/testcases", problemHandler.AddProblemTestcase)
+	}
+}
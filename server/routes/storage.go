@@ -13,7 +13,7 @@ import (
 func InitStorageRoutes(router *gin.Engine) {
 	storageHandler := handler.NewStorageHandler()
 
-	// 存储API路由组
+	// 存储API路由组，文件名中的/需编码为%2F，如 /storage/files/p1%2F1.in
 	storageGroup := router.Group("/storage")
 	{
 		// 文件上传（新建或修改）
@@ -22,19 +22,45 @@ func InitStorageRoutes(router *gin.Engine) {
 		// 获取测试用例内容（JSON格式）
 		storageGroup.GET("/files/:filename", storageHandler.GetTestcaseContent)
 
-		// 下载文件（原始格式）
+		// 下载文件（原始格式），可通过查询参数version下载历史版本
 		storageGroup.GET("/files/:filename/download", storageHandler.DownloadFile)
 
 		// 获取文件元数据
 		storageGroup.GET("/files/:filename/metadata", storageHandler.GetFileMetadata)
 
+		// 列出文件的历史版本
+		storageGroup.GET("/files/:filename/versions", storageHandler.ListVersions)
+
+		// 恢复到指定版本
+		storageGroup.POST("/files/:filename/versions/:version/restore", storageHandler.RestoreVersion)
+
+		// 删除历史版本，当前版本不能删除
+		storageGroup.DELETE("/files/:filename/versions/:version", storageHandler.DeleteVersion)
+
+		// 使用当前适用的校验程序重新校验文件
+		storageGroup.POST("/files/:filename/validate", storageHandler.ValidateFile)
+
 		// 更新文件内容
 		storageGroup.PUT("/files/:filename", storageHandler.UpdateFile)
 
 		// 删除文件
 		storageGroup.DELETE("/files/:filename", storageHandler.DeleteFile)
 
-		// 列出所有文件
+		// 列出文件，支持过滤、排序与分页
 		storageGroup.GET("/files", storageHandler.ListFiles)
+
+		// 递归删除目录（多级目录中的/需编码为%2F）
+		storageGroup.DELETE("/dirs/:dir", storageHandler.DeleteDir)
+
+		// 目录的校验程序，作用于目录及其子目录下上传的.in文件
+		storageGroup.GET("/dirs/:dir/validator", storageHandler.GetDirValidator)
+		storageGroup.PUT("/dirs/:dir/validator", storageHandler.SetDirValidator)
+		storageGroup.DELETE("/dirs/:dir/validator", storageHandler.DeleteDirValidator)
+
+		// 导入测试数据包（zip或tar.gz）
+		storageGroup.POST("/import", storageHandler.ImportPackage)
+
+		// 导出测试数据包
+		storageGroup.GET("/export", storageHandler.ExportPackage)
 	}
 }
@@ -16,6 +16,8 @@ func InitServer() error {
 
 	// 创建gin实例
 	ginServer = gin.Default()
+	// 按编码前的路径匹配路由，使路径参数可以包含编码为%2F的/（如多级目录下的文件名）
+	ginServer.UseRawPath = true
 
 	// 初始化路由
 	err := InitRoute()
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ArchiveFile 表示压缩包中的一个普通文件
+type ArchiveFile struct {
+	Name string // 经过 CleanRelativePath 处理的相对路径，使用/分隔
+	Data []byte
+}
+
+// ArchiveLimit 读取压缩包时的限制，用于防止压缩炸弹
+type ArchiveLimit struct {
+	MaxFiles int   // 最大文件数，0表示不限制
+	MaxSize  int64 // 解压后的最大总字节数，0表示不限制
+}
+
+// CleanRelativePath 校验并规范化相对路径，拒绝绝对路径、包含..的路径和空路径
+// 反斜杠视为路径分隔符，返回使用/分隔的路径
+func CleanRelativePath(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	p := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(p, "/") || (len(p) >= 2 && p[1] == ':') {
+		return "", fmt.Errorf("absolute path %q is not allowed", name)
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path %q escapes the target directory", name)
+		}
+	}
+	p = path.Clean(p)
+	if p == "." {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	return p, nil
+}
+
+// ReadArchive 读取zip、tar或tar.gz格式的压缩包（根据文件头判断格式），返回其中的普通文件
+// 目录条目会被忽略，符号链接等其他类型的条目视为错误
+func ReadArchive(data []byte, limit ArchiveLimit) ([]ArchiveFile, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return readZip(data, limit)
+	case bytes.HasPrefix(data, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readTar(gz, limit)
+	case len(data) > 262 && string(data[257:262]) == "ustar":
+		return readTar(bytes.NewReader(data), limit)
+	default:
+		return nil, errors.New("unsupported archive format, expected zip, tar or tar.gz")
+	}
+}
+
+func readZip(data []byte, limit ArchiveLimit) ([]ArchiveFile, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	acc := archiveAccumulator{limit: limit}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return nil, fmt.Errorf("unsupported entry type for %q", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = acc.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return acc.files, nil
+}
+
+func readTar(r io.Reader, limit ArchiveLimit) ([]ArchiveFile, error) {
+	tr := tar.NewReader(r)
+	acc := archiveAccumulator{limit: limit}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return acc.files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if err := acc.add(hdr.Name, tr); err != nil {
+				return nil, err
+			}
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported entry type for %q", hdr.Name)
+		}
+	}
+}
+
+// archiveAccumulator 收集压缩包中的文件并检查数量与大小限制
+type archiveAccumulator struct {
+	limit ArchiveLimit
+	files []ArchiveFile
+	size  int64
+}
+
+func (a *archiveAccumulator) add(name string, r io.Reader) error {
+	clean, err := CleanRelativePath(name)
+	if err != nil {
+		return err
+	}
+	if a.limit.MaxFiles > 0 && len(a.files) >= a.limit.MaxFiles {
+		return fmt.Errorf("archive contains more than %d files", a.limit.MaxFiles)
+	}
+	// 不信任条目声明的大小，按实际读取的字节数计算
+	if a.limit.MaxSize > 0 {
+		r = io.LimitReader(r, a.limit.MaxSize-a.size+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	a.size += int64(len(data))
+	if a.limit.MaxSize > 0 && a.size > a.limit.MaxSize {
+		return fmt.Errorf("archive exceeds %d bytes when extracted", a.limit.MaxSize)
+	}
+	a.files = append(a.files, ArchiveFile{Name: clean, Data: data})
+	return nil
+}
+
+// 压缩包格式，用于 NewArchiveWriter
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ArchiveWriter 以流的方式写出zip或tar.gz压缩包
+type ArchiveWriter struct {
+	zw *zip.Writer
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+// NewArchiveWriter 创建写入w的压缩包，format取值为 ArchiveZip 或 ArchiveTarGz
+func NewArchiveWriter(w io.Writer, format string) (*ArchiveWriter, error) {
+	switch format {
+	case ArchiveZip:
+		return &ArchiveWriter{zw: zip.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &ArchiveWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+// Add 写入一个普通文件，tar格式需要预先知道文件大小
+func (a *ArchiveWriter) Add(name string, size int64, r io.Reader) error {
+	if a.zw != nil {
+		w, err := a.zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		Typeflag: tar.TypeReg,
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(a.tw, r, size)
+	return err
+}
+
+// Close 写出压缩包的结尾，不关闭底层的io.Writer
+func (a *ArchiveWriter) Close() error {
+	if a.zw != nil {
+		return a.zw.Close()
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
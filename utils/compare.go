@@ -1,6 +1,9 @@
 package utils
 
-import "strings"
+import (
+	"slices"
+	"strings"
+)
 
 // 比较两个字符串是否相等，忽略末尾的换行符
 func StringsEqualIgnoreFinalNewline(a, b string) bool {
@@ -12,3 +15,8 @@ func StringsEqualIgnoreFinalNewline(a, b string) bool {
 func trimNewline(s string) string {
 	return strings.TrimRight(s, "\r\n")
 }
+
+// TokensEqual 比较两个字符串按空白分隔后的记号序列是否相同，忽略空白的数量与种类
+func TokensEqual(a, b string) bool {
+	return slices.Equal(strings.Fields(a), strings.Fields(b))
+}
@@ -1,6 +1,11 @@
 package utils
 
-import "os"
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
 
 // 判断文件是否存在
 func IsFileExists(filePath string) (bool, error) {
@@ -26,3 +31,67 @@ func EnsureDir(dir string) error {
 	}
 	return nil // 文件夹存在或创建成功
 }
+
+// 计算文件夹内所有文件的总大小（字节）
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// 将文件复制到目标路径并保留权限位，目标所在文件夹需已存在
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// 递归复制文件夹内容到目标文件夹，已存在的同名文件会被覆盖，不复制符号链接等特殊文件
+func CopyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type().IsRegular():
+			return CopyFile(path, target)
+		default:
+			return nil
+		}
+	})
+}
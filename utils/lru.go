@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"container/list"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DiskLRU 磁盘缓存的最近最少使用索引，每个条目是缓存目录下的一个文件或子目录，总大小超出容量时从最久未使用的条目开始删除
+// 最近使用时间记录在条目的修改时间上，重启后据此恢复淘汰顺序
+// DiskLRU 本身不加锁，调用者需串行化访问，通常在同一次加锁中写入条目并登记
+type DiskLRU struct {
+	dir     string
+	maxSize int64                    // 字节
+	name    func(key string) string  // 键到条目文件名的映射
+	entries map[string]*list.Element // 键到lru中元素的映射
+	lru     *list.List               // 队首为最近使用的条目，元素类型为*diskLRUEntry
+	size    int64                    // 当前所有条目的总大小（字节）
+}
+
+type diskLRUEntry struct {
+	key  string
+	size int64
+}
+
+// NewDiskLRU 创建索引并加载目录中已有的条目，超出容量的部分随即淘汰
+// name将键映射为条目的文件名，parse将目录项解析为键，解析失败的目录项视为未写完的残留并被删除
+func NewDiskLRU(dir string, maxSize int64, name func(key string) string, parse func(d fs.DirEntry) (string, bool)) (*DiskLRU, error) {
+	if err := EnsureDir(dir); err != nil {
+		return nil, err
+	}
+	c := &DiskLRU{
+		dir:     dir,
+		maxSize: maxSize,
+		name:    name,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type loaded struct {
+		entry   *diskLRUEntry
+		modTime time.Time
+	}
+	var items []loaded
+	for _, d := range dirEntries {
+		path := filepath.Join(dir, d.Name())
+		key, ok := parse(d)
+		if !ok {
+			os.RemoveAll(path)
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		size, err := DirSize(path)
+		if err != nil {
+			continue
+		}
+		items = append(items, loaded{&diskLRUEntry{key: key, size: size}, info.ModTime()})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].modTime.After(items[j].modTime)
+	})
+	for _, item := range items {
+		c.entries[item.entry.key] = c.lru.PushBack(item.entry)
+		c.size += item.entry.size
+	}
+	c.evict()
+	return c, nil
+}
+
+// Path 返回键对应条目的路径
+func (c *DiskLRU) Path(key string) string {
+	return filepath.Join(c.dir, c.name(key))
+}
+
+// MaxSize 返回容量（字节），超出容量的单个条目不应登记
+func (c *DiskLRU) MaxSize() int64 {
+	return c.maxSize
+}
+
+// Size 返回当前所有条目的总大小（字节）
+func (c *DiskLRU) Size() int64 {
+	return c.size
+}
+
+// Contains 判断条目是否已登记，不改变淘汰顺序
+func (c *DiskLRU) Contains(key string) bool {
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Touch 将条目标记为最近使用并更新其修改时间，条目不存在时返回false
+func (c *DiskLRU) Touch(key string) bool {
+	elem, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	c.lru.MoveToFront(elem)
+	now := time.Now()
+	_ = os.Chtimes(c.Path(key), now, now)
+	return true
+}
+
+// Add 登记已写入 Path(key) 的条目并标记为最近使用，随后淘汰超出容量的条目
+// 条目已登记时（内容已被覆盖）只更新大小
+func (c *DiskLRU) Add(key string, size int64) {
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*diskLRUEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(&diskLRUEntry{key: key, size: size})
+		c.size += size
+	}
+	c.evict()
+}
+
+// Remove 删除条目及其文件，条目不存在时不做处理
+func (c *DiskLRU) Remove(key string) {
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// evict 从最久未使用的条目开始淘汰，直到总大小不超过容量
+func (c *DiskLRU) evict() {
+	for c.size > c.maxSize {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		c.removeElement(elem)
+	}
+}
+
+// removeElement 删除条目及其文件
+func (c *DiskLRU) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*diskLRUEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	os.RemoveAll(c.Path(entry.key))
+}
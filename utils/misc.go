@@ -2,6 +2,9 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -29,3 +32,44 @@ func BoolToInt(b bool) int {
 	}
 	return 0
 }
+
+// TruncateString 将字符串截断到最多n字节，不会截断多字节字符，返回截断后的字符串及是否发生截断
+func TruncateString(s string, n int) (string, bool) {
+	if n < 0 || len(s) <= n {
+		return s, false
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n], true
+}
+
+// JSONStringWriter 将写入的内容转义为JSON字符串的内容（不含两侧的引号）后写入W，用于以流的方式输出较大的字符串
+// 按字节转义，多字节字符可以跨多次写入；写入的内容应为有效的UTF-8
+type JSONStringWriter struct {
+	W io.Writer
+}
+
+func (w JSONStringWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p)+len(p)/8)
+	for _, b := range p {
+		switch {
+		case b == '"' || b == '\\':
+			buf = append(buf, '\\', b)
+		case b == '\n':
+			buf = append(buf, '\\', 'n')
+		case b == '\r':
+			buf = append(buf, '\\', 'r')
+		case b == '\t':
+			buf = append(buf, '\\', 't')
+		case b < 0x20:
+			buf = fmt.Appendf(buf, `\u%04x`, b)
+		default:
+			buf = append(buf, b)
+		}
+	}
+	if _, err := w.W.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -1,8 +1,15 @@
 package utils_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/fs"
 	"math/rand/v2"
 	"nightcord-server/utils"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -17,3 +24,165 @@ func TestRandomString(t *testing.T) {
 		}
 	}
 }
+
+func TestTruncateString(t *testing.T) {
+	if s, truncated := utils.TruncateString("hello", 10); s != "hello" || truncated {
+		t.Errorf("Short string should not be truncated, got %q, %v", s, truncated)
+	}
+	if s, truncated := utils.TruncateString("hello", 3); s != "hel" || !truncated {
+		t.Errorf("Expected \"hel\", true, got %q, %v", s, truncated)
+	}
+	// "错"占3字节，截断位置落在字符中间时应退回到字符边界
+	if s, truncated := utils.TruncateString("a错误", 5); s != "a错" || !truncated {
+		t.Errorf("Expected \"a错\", true, got %q, %v", s, truncated)
+	}
+}
+
+func TestJSONStringWriter(t *testing.T) {
+	want := "a \"quoted\" \\ line\n\ttab\r\x01 中文测试"
+	var buf bytes.Buffer
+	w := utils.JSONStringWriter{W: &buf}
+	// 每次写入3字节，多字节字符被拆分到多次写入中
+	for data := []byte(want); len(data) > 0; {
+		n := min(3, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	var got string
+	if err := json.Unmarshal([]byte(`"`+buf.String()+`"`), &got); err != nil {
+		t.Fatalf("Invalid JSON string %q: %v", buf.String(), err)
+	}
+	if got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestTokensEqual(t *testing.T) {
+	if !utils.TokensEqual("1 2\n3\n", "1  2 3") {
+		t.Errorf("Tokens separated by different whitespace should be equal")
+	}
+	if utils.TokensEqual("1 2 3", "1 23") {
+		t.Errorf("Different tokens should not be equal")
+	}
+	if !utils.TokensEqual("", "\n") {
+		t.Errorf("Blank strings should be equal")
+	}
+}
+
+func TestCleanRelativePath(t *testing.T) {
+	valid := map[string]string{
+		"main.cpp":       "main.cpp",
+		"src/./util.h":   "src/util.h",
+		"pkg\\Main.java": "pkg/Main.java",
+	}
+	for name, want := range valid {
+		got, err := utils.CleanRelativePath(name)
+		if err != nil || got != want {
+			t.Errorf("CleanRelativePath(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", "/etc/passwd", "../main.c", "src/../../x", "C:\\x", "."} {
+		if got, err := utils.CleanRelativePath(name); err == nil {
+			t.Errorf("CleanRelativePath(%q) should fail, got %q", name, got)
+		}
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	build := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for name, content := range files {
+			f, err := w.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte(content))
+		}
+		w.Close()
+		return buf.Bytes()
+	}
+
+	files, err := utils.ReadArchive(build(map[string]string{"src/main.c": "int main(){}", "src/": ""}), utils.ArchiveLimit{})
+	if err != nil || len(files) != 1 || files[0].Name != "src/main.c" {
+		t.Errorf("Unexpected result: %+v, %v", files, err)
+	}
+	if _, err := utils.ReadArchive(build(map[string]string{"../evil": "x"}), utils.ArchiveLimit{}); err == nil {
+		t.Errorf("Path traversal entry should be rejected")
+	}
+	if _, err := utils.ReadArchive(build(map[string]string{"big": "0123456789"}), utils.ArchiveLimit{MaxSize: 5}); err == nil {
+		t.Errorf("Archive exceeding size limit should be rejected")
+	}
+	if _, err := utils.ReadArchive([]byte("not an archive"), utils.ArchiveLimit{}); err == nil {
+		t.Errorf("Unknown format should be rejected")
+	}
+}
+
+func TestArchiveWriter(t *testing.T) {
+	for _, format := range []string{utils.ArchiveZip, utils.ArchiveTarGz} {
+		var buf bytes.Buffer
+		w, err := utils.NewArchiveWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := "1 2\n"
+		if err := w.Add("1.in", int64(len(content)), strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		files, err := utils.ReadArchive(buf.Bytes(), utils.ArchiveLimit{})
+		if err != nil || len(files) != 1 || files[0].Name != "1.in" || string(files[0].Data) != content {
+			t.Errorf("%s: unexpected result: %+v, %v", format, files, err)
+		}
+	}
+}
+
+func TestDiskLRU(t *testing.T) {
+	dir := t.TempDir()
+	name := func(key string) string { return key }
+	parse := func(d fs.DirEntry) (string, bool) { return d.Name(), !strings.HasPrefix(d.Name(), ".tmp") }
+	lru, err := utils.NewDiskLRU(dir, 10, name, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(key, content string) {
+		t.Helper()
+		if err := os.WriteFile(lru.Path(key), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		lru.Add(key, int64(len(content)))
+	}
+	add("a", "1234")
+	add("b", "1234")
+	lru.Touch("a")
+	add("c", "1234")
+	if lru.Contains("b") || !lru.Contains("a") || lru.Size() != 8 {
+		t.Errorf("Expected the least recently used entry to be evicted, size %d", lru.Size())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Errorf("Evicted entry was not removed: %v", err)
+	}
+
+	// 重启后按修改时间恢复条目，清理未写完的残留
+	if err := os.WriteFile(filepath.Join(dir, ".tmp-1"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := utils.NewDiskLRU(dir, 10, name, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Size() != 8 || !reloaded.Contains("a") || !reloaded.Contains("c") {
+		t.Errorf("Entries were not reloaded, size %d", reloaded.Size())
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".tmp-1")); !os.IsNotExist(err) {
+		t.Errorf("Leftover entry was not removed: %v", err)
+	}
+	reloaded.Remove("a")
+	if reloaded.Contains("a") || reloaded.Size() != 4 {
+		t.Errorf("Remove did not update the index, size %d", reloaded.Size())
+	}
+}